@@ -28,6 +28,20 @@ type WebAPIConfiguration struct {
 	DB    struct {
 		Filename string `conf:"default:./fridge.db"`
 	}
+	Trash struct {
+		// Retention is how long deleted items can be restored before being purged
+		Retention time.Duration `conf:"default:720h"`
+	}
+	Backup struct {
+		// Dir enables the daily backups of the database, written in this directory
+		Dir string
+		// Keep is how many daily backups are kept
+		Keep int `conf:"default:7"`
+	}
+	Grocy struct {
+		// APIKey enables the Grocy-compatible API for the clients sending it
+		APIKey string `conf:"noprint"`
+	}
 }
 
 // loadConfiguration creates a WebAPIConfiguration starting from flags, environment variables and configuration file.
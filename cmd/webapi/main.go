@@ -35,7 +35,9 @@ import (
 
 	"github.com/ardanlabs/conf"
 	"github.com/lorenzougolini/wimf-app/service/api"
+	"github.com/lorenzougolini/wimf-app/service/backup"
 	"github.com/lorenzougolini/wimf-app/service/database"
+	"github.com/lorenzougolini/wimf-app/service/events"
 	"github.com/lorenzougolini/wimf-app/service/foodapi"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/sirupsen/logrus"
@@ -79,6 +81,13 @@ func run() error {
 
 	logger.Infof("application initializing")
 
+	// Start the event bus, its subscribers are registered once they are created
+	bus := events.New(logger)
+	defer func() {
+		logger.Debug("event bus stopping")
+		_ = bus.Close()
+	}()
+
 	// Start Database
 	logger.Println("initializing database support")
 	dbconn, err := sql.Open("sqlite3", cfg.DB.Filename)
@@ -90,12 +99,32 @@ func run() error {
 		logger.Debug("database stopping")
 		_ = dbconn.Close()
 	}()
-	db, err := database.New(dbconn)
+	db, err := database.New(dbconn, bus)
 	if err != nil {
 		logger.WithError(err).Error("error creating AppDatabase")
 		return fmt.Errorf("creating AppDatabase: %w", err)
 	}
 
+	// Start the scheduled backups, if enabled
+	var backups *backup.Scheduler
+	if cfg.Backup.Dir != "" {
+		logger.Infof("initializing daily backups in %s", cfg.Backup.Dir)
+		backups, err = backup.New(backup.Config{
+			Logger:   logger,
+			Database: db,
+			Dir:      cfg.Backup.Dir,
+			Keep:     cfg.Backup.Keep,
+		})
+		if err != nil {
+			logger.WithError(err).Error("error starting the backup scheduler")
+			return fmt.Errorf("starting the backup scheduler: %w", err)
+		}
+		defer func() {
+			logger.Debug("backup scheduler stopping")
+			_ = backups.Close()
+		}()
+	}
+
 	// Start (main) API server
 	logger.Info("initializing API server")
 
@@ -116,6 +145,10 @@ func run() error {
 		Logger:   logger,
 		Database: db,
 		FoodApi:  *foodClient,
+
+		TrashRetention: cfg.Trash.Retention,
+		Backups:        backups,
+		GrocyAPIKey:    cfg.Grocy.APIKey,
 	})
 	if err != nil {
 		logger.WithError(err).Error("error creating the API server instance")
@@ -123,6 +156,12 @@ func run() error {
 	}
 	router := apirouter.Handler()
 
+	// Subscribe to the inventory changes
+	bus.Subscribe("sse", 16, apirouter.HandleEvent)
+	bus.Subscribe("expirations", 64, events.Handle(func(e events.ItemExpired) {
+		logger.WithField("barcode", e.Item.Barcode).Infof("%s (%s) expired", e.Item.Name, e.Item.Location)
+	}))
+
 	// router, err = registerWebUI(router)
 	// if err != nil {
 	// 	logger.WithError(err).Error("error registering web UI handler")
@@ -0,0 +1,361 @@
+/*
+Wimfctl is the command line tool to manage the app database. The backup, restore, export, import and merge commands
+work on the database file directly, and they're safe to use while the web API is running; the server doesn't see their
+changes happen though, so its open pages show them only once reloaded (wimfctl reminds it when the server at -api
+answers). The stock, add and remove commands go through the JSON API of a running server instead, and the open pages
+are updated live.
+
+Usage:
+
+	wimfctl [flags] <command> [arguments]
+
+The commands are:
+
+	backup [file]
+		Write a consistent snapshot of the database to a new file. The default name is wimf-<date>-<time>.db in the
+		current directory.
+
+	restore <file>
+		Replace the database with a backup, after checking its integrity. The current content is saved first next to the
+		database, as <database>.before-restore-<date>-<time>.db .
+
+	export [-format csv|json] [file]
+		Write every lot of the inventory to a CSV (the default) or JSON file, or to the standard output if the file is
+		missing.
+
+	import [-format csv|json] [-mode merge|replace] [-dry-run] <file>
+		Add the lots of a CSV or JSON file, updating those with the id of a stored lot. With -mode replace the lots
+		missing from the file are moved to the trash. The format is taken from the file extension if not given, and
+		-dry-run only prints what would change. Nothing is imported if any row is invalid.
+
+	merge [-dry-run]
+		Merge the lots in stock that only differ by their addition date (same barcode, expiration date, location,
+		package size, note and label) into the oldest of them, as new scans do. -dry-run only prints how many lots would
+		be merged. It can't run during a stocktake.
+
+	stock
+		List the products in stock, with their quantity and next expiration.
+
+	add [-quantity n] [-location name] <barcode> <name> <expiration date>
+		Add a lot, expiring at the date given as YYYY-MM-DD, and print its id.
+
+	remove <lot id>
+		Move a lot to the trash.
+
+The flags are:
+
+	-db <path>
+		The database file, ./fridge.db by default (like the web API).
+
+	-api <url>
+		The address of the server, http://localhost:3001 by default (like the web API).
+
+Return values (exit codes):
+
+	0
+		The command was successful
+
+	> 0
+		The command failed, or the usage is wrong
+*/
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lorenzougolini/wimf-app/service/client"
+	"github.com/lorenzougolini/wimf-app/service/database"
+	"github.com/lorenzougolini/wimf-app/service/models"
+	"github.com/lorenzougolini/wimf-app/service/transfer"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const timestampLayout = "2006-01-02-150405"
+
+func main() {
+	var dbFile = flag.String("db", "./fridge.db", "database file")
+	var apiURL = flag.String("api", "http://localhost:3001", "server address")
+	flag.Usage = func() {
+		_, _ = fmt.Fprintln(os.Stderr, "usage: wimfctl [-db path] [-api url] backup [file] | restore <file> | "+
+			"export [file] | import <file> | merge | stock | add <barcode> <name> <expiration> | remove <id>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	var err error
+	switch flag.Arg(0) {
+	case "backup":
+		file := flag.Arg(1)
+		if file == "" {
+			file = "wimf-" + time.Now().Format(timestampLayout) + ".db"
+		}
+		err = backup(*dbFile, file)
+	case "restore":
+		if flag.Arg(1) == "" {
+			flag.Usage()
+			os.Exit(2)
+		}
+		err = restore(*dbFile, flag.Arg(1), *apiURL)
+	case "export":
+		err = export(*dbFile, flag.Args()[1:])
+	case "import":
+		err = importFile(*dbFile, flag.Args()[1:], *apiURL)
+	case "merge":
+		err = merge(*dbFile, flag.Args()[1:], *apiURL)
+	case "stock":
+		err = stock(client.New(*apiURL))
+	case "add":
+		err = add(client.New(*apiURL), flag.Args()[1:])
+	case "remove":
+		if flag.Arg(1) == "" {
+			flag.Usage()
+			os.Exit(2)
+		}
+		err = client.New(*apiURL).DeleteLot(flag.Arg(1))
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+// open opens an existing database file.
+func open(dbFile string) (*sql.DB, error) {
+	if _, err := os.Stat(dbFile); err != nil {
+		return nil, fmt.Errorf("can't open the database: %w", err)
+	}
+	return sql.Open("sqlite3", dbFile)
+}
+
+func backup(dbFile string, file string) error {
+	conn, err := open(dbFile)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	if err := database.Backup(conn, file); err != nil {
+		return err
+	}
+	fmt.Println("backup written to", file) //nolint:forbidigo
+	return nil
+}
+
+func restore(dbFile string, file string, apiURL string) error {
+	conn, err := open(dbFile)
+	if errors.Is(err, os.ErrNotExist) {
+		// restoring on a new installation
+		conn, err = sql.Open("sqlite3", dbFile)
+	}
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	safety := dbFile + ".before-restore-" + time.Now().Format(timestampLayout) + ".db"
+	if err := database.Backup(conn, safety); err != nil {
+		return fmt.Errorf("saving the current database: %w", err)
+	}
+	if err := database.Restore(conn, file); err != nil {
+		return err
+	}
+	// bring an older backup to the current schema
+	if _, err := database.New(conn, nil); err != nil {
+		return fmt.Errorf("migrating the restored database: %w", err)
+	}
+	fmt.Println("database restored from", file, "- the previous content is saved in", safety) //nolint:forbidigo
+	warnRunningServer(apiURL)
+	return nil
+}
+
+func export(dbFile string, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	format := fs.String("format", transfer.FormatCSV, "file format, csv or json")
+	_ = fs.Parse(args)
+
+	conn, err := open(dbFile)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+	db, err := database.New(conn, nil)
+	if err != nil {
+		return err
+	}
+	items, err := db.GetAllItems()
+	if err != nil {
+		return err
+	}
+
+	if fs.Arg(0) == "" {
+		return transfer.Export(os.Stdout, *format, items)
+	}
+	f, err := os.OpenFile(fs.Arg(0), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	if err = transfer.Export(f, *format, items); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(os.Stderr, "%d items exported to %s\n", len(items), fs.Arg(0))
+	return nil
+}
+
+func importFile(dbFile string, args []string, apiURL string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	format := fs.String("format", "", "file format, csv or json (default from the file extension)")
+	mode := fs.String("mode", models.ImportMerge, "merge with the current content, or replace it")
+	dryRun := fs.Bool("dry-run", false, "only print what would change")
+	_ = fs.Parse(args)
+	if fs.Arg(0) == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *format == "" {
+		*format = strings.TrimPrefix(strings.ToLower(filepath.Ext(fs.Arg(0))), ".")
+	}
+	if !slices.Contains(transfer.Formats, *format) {
+		return fmt.Errorf("unsupported format %q, use -format", *format)
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	lots, rowErrors, err := transfer.Import(f, *format)
+	if err != nil {
+		return err
+	}
+	if len(rowErrors) > 0 {
+		for _, e := range rowErrors {
+			_, _ = fmt.Fprintln(os.Stderr, e)
+		}
+		return errors.New("the file has invalid rows, nothing imported")
+	}
+
+	conn, err := open(dbFile)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+	db, err := database.New(conn, nil)
+	if err != nil {
+		return err
+	}
+	result, err := db.WithSource(models.AuditSource{Endpoint: "wimfctl import"}).ImportItems(lots, *mode, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	verb := "imported"
+	if *dryRun {
+		verb = "would import"
+	}
+	fmt.Printf("%s %d new, %d updated and %d removed items\n", //nolint:forbidigo
+		verb, result.Added, result.Updated, result.Removed)
+	if !*dryRun {
+		warnRunningServer(apiURL)
+	}
+	return nil
+}
+
+func merge(dbFile string, args []string, apiURL string) error {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "only print how many lots would be merged")
+	_ = fs.Parse(args)
+
+	conn, err := open(dbFile)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+	db, err := database.New(conn, nil)
+	if err != nil {
+		return err
+	}
+	merged, err := db.WithSource(models.AuditSource{Endpoint: "wimfctl merge"}).MergeDuplicateLots(*dryRun)
+	if err != nil {
+		return err
+	}
+
+	verb := "merged"
+	if *dryRun {
+		verb = "would merge"
+	}
+	fmt.Printf("%s %d duplicate lots\n", verb, merged) //nolint:forbidigo
+	if !*dryRun && merged > 0 {
+		warnRunningServer(apiURL)
+	}
+	return nil
+}
+
+// warnRunningServer reminds to reload the open pages when the server at `apiURL` is running: the changes written to the
+// database file directly aren't published to them, unlike the ones made through the server.
+func warnRunningServer(apiURL string) {
+	c := http.Client{Timeout: 2 * time.Second}
+	resp, err := c.Get(strings.TrimSuffix(apiURL, "/") + "/liveness")
+	if err != nil {
+		return
+	}
+	_ = resp.Body.Close()
+	_, _ = fmt.Fprintf(os.Stderr, "the server at %s is running: reload its open pages to see the changes\n", apiURL)
+}
+
+func stock(c *client.Client) error {
+	products, err := c.AllProducts(models.FridgeFilter{})
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "BARCODE\tNAME\tQUANTITY\tEXPIRES")
+	for _, p := range products {
+		expires := ""
+		if p.NextExpiration != nil {
+			expires = p.NextExpiration.Format("2006-01-02")
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", p.Barcode, p.Name, p.Quantity, expires)
+	}
+	return w.Flush()
+}
+
+func add(c *client.Client, args []string) error {
+	fs := flag.NewFlagSet("add", flag.ExitOnError)
+	quantity := fs.Int("quantity", 1, "number of pieces")
+	location := fs.String("location", "", "storage location (default Frigo)")
+	_ = fs.Parse(args)
+	if fs.NArg() != 3 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	lot, err := c.CreateLot(client.LotRequest{
+		Barcode:        fs.Arg(0),
+		Name:           fs.Arg(1),
+		Quantity:       *quantity,
+		ExpirationDate: fs.Arg(2),
+		Location:       *location,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println(lot.Id) //nolint:forbidigo
+	return nil
+}
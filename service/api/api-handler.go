@@ -9,17 +9,86 @@ func (rt *_router) Handler() http.Handler {
 	// Register routes
 	rt.router.GET("/", rt.getHome)
 	rt.router.GET("/fridge", rt.wrap(rt.getFridge))
+	rt.router.GET("/fridge/rows", rt.wrap(rt.getFridgeRows))
+	rt.router.GET("/fridge/search", rt.wrap(rt.searchFridge))
 	rt.router.GET("/fridge/details", rt.wrap(rt.getFridgeDetails))
 	rt.router.DELETE("/fridge/item", rt.wrap(rt.deleteItem))
+	rt.router.POST("/fridge/item/restore", rt.wrap(rt.restoreItem))
+	rt.router.POST("/fridge/item/quantity", rt.wrap(rt.adjustQuantity))
+	rt.router.POST("/fridge/item/use", rt.wrap(rt.useAmount))
 	rt.router.GET("/fridge/item/edit", rt.wrap(rt.getEditForm))
 	rt.router.PUT("/fridge/items", rt.wrap(rt.updateItem))
+	rt.router.POST("/fridge/item/split", rt.wrap(rt.splitItem))
+	rt.router.PUT("/fridge/product", rt.wrap(rt.updateProduct))
 
 	rt.router.POST("/fridge/items", rt.wrap(rt.addItem))
 	rt.router.GET("/fridge/items/form", rt.wrap(rt.getExpirationForm))
 	rt.router.GET("/fridge/items/manual-form", rt.wrap(rt.getManualForm))
 	rt.router.GET("/fridge/home-items", rt.wrap(rt.getHomeItems))
 
+	rt.router.GET("/fridge/cook/form", rt.wrap(rt.getCookForm))
+	rt.router.POST("/fridge/cook", rt.wrap(rt.cookItems))
+	rt.router.POST("/fridge/consume", rt.wrap(rt.consumeItem))
+	rt.router.POST("/fridge/consume/undo", rt.wrap(rt.undoConsume))
+
+	rt.router.GET("/events", rt.wrap(rt.getEvents))
+
+	rt.router.GET("/bulk", rt.wrap(rt.getBulk))
+	rt.router.DELETE("/bulk", rt.wrap(rt.clearBulk))
+	rt.router.GET("/bulk/rows", rt.wrap(rt.getBulkTable))
+	rt.router.PUT("/bulk/rows", rt.wrap(rt.updateBulkRow))
+	rt.router.DELETE("/bulk/rows", rt.wrap(rt.deleteBulkRow))
+	rt.router.POST("/bulk/scan", rt.wrap(rt.scanBulk))
+	rt.router.POST("/bulk/rule", rt.wrap(rt.applyBulkRule))
+	rt.router.POST("/bulk/commit", rt.wrap(rt.commitBulk))
+
+	rt.router.GET("/stocktake", rt.wrap(rt.getStocktake))
+	rt.router.POST("/stocktake", rt.wrap(rt.startStocktake))
+	rt.router.DELETE("/stocktake", rt.wrap(rt.cancelStocktake))
+	rt.router.GET("/stocktake/lines", rt.wrap(rt.getStocktakeTable))
+	rt.router.PUT("/stocktake/lines", rt.wrap(rt.updateStocktakeLine))
+	rt.router.POST("/stocktake/scan", rt.wrap(rt.scanStocktake))
+	rt.router.GET("/stocktake/review", rt.wrap(rt.getStocktakeReview))
+	rt.router.POST("/stocktake/apply", rt.wrap(rt.applyStocktake))
+
+	rt.router.GET("/pair", rt.wrap(rt.getPairDesktop))
+	rt.router.GET("/pair/events", rt.wrap(rt.getPairEvents))
+	rt.router.GET("/pair/join", rt.wrap(rt.getPairJoin))
+	rt.router.POST("/pair/scan", rt.wrap(rt.postPairScan))
+
+	rt.router.GET("/check", rt.wrap(rt.checkStock))
+
+	rt.router.GET("/trash", rt.wrap(rt.getTrash))
+	rt.router.GET("/audit", rt.wrap(rt.getAudit))
+
+	rt.router.GET("/export", rt.wrap(rt.exportItems))
+	rt.router.GET("/import", rt.wrap(rt.getImportPage))
+	rt.router.POST("/import/preview", rt.wrap(rt.previewImport))
+	rt.router.POST("/import", rt.wrap(rt.importItems))
+
+	if rt.grocyAPIKey != "" {
+		rt.router.GET("/api/system/info", rt.grocy(rt.grocySystemInfo))
+		rt.router.GET("/api/stock", rt.grocy(rt.grocyStock))
+		rt.router.GET("/api/stock/products/*path", rt.grocy(rt.grocyProducts))
+		rt.router.POST("/api/stock/products/*path", rt.grocy(rt.grocyProducts))
+		rt.router.GET("/api/stock/barcodes/external-lookup/:barcode", rt.grocy(rt.grocyExternalLookup))
+	}
+
 	rt.router.GET("/context", rt.wrap(rt.getContextReply))
+	rt.router.GET("/api/openapi.yaml", getOpenAPI)
+	rt.router.GET("/api/v1/locations", rt.wrap(rt.apiListLocations))
+	rt.router.GET("/api/v1/products", rt.wrap(rt.apiListProducts))
+	rt.router.GET("/api/v1/products/:barcode", rt.wrap(rt.apiGetProduct))
+	rt.router.PUT("/api/v1/products/:barcode", rt.wrap(rt.apiUpdateProduct))
+	rt.router.GET("/api/v1/lots", rt.wrap(rt.apiListLots))
+	rt.router.POST("/api/v1/lots", rt.wrap(rt.apiCreateLot))
+	rt.router.GET("/api/v1/lots/:id", rt.wrap(rt.apiGetLot))
+	rt.router.PUT("/api/v1/lots/:id", rt.wrap(rt.apiUpdateLot))
+	rt.router.POST("/api/v1/lots/:id/split", rt.wrap(rt.apiSplitLot))
+	rt.router.DELETE("/api/v1/lots/:id", rt.wrap(rt.apiDeleteLot))
+	rt.router.NotFound = http.HandlerFunc(apiNotFound)
+	rt.router.MethodNotAllowed = http.HandlerFunc(apiMethodNotAllowed)
+
 	// Special routes
 	rt.router.GET("/liveness", rt.liveness)
 
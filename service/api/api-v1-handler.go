@@ -0,0 +1,550 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/lorenzougolini/wimf-app/service/api/reqcontext"
+	"github.com/lorenzougolini/wimf-app/service/database"
+	"github.com/lorenzougolini/wimf-app/service/models"
+)
+
+// The JSON API under /api/v1 exposes the locations, the products (the aggregates of the lots with the same barcode) and
+// the lots. Every error reply is an apiError, with the same status of the response.
+
+// apiVersionPrefix is the path prefix of the JSON API.
+const apiVersionPrefix = "/api/v1/"
+
+// apiMaxPageSize is the largest page of products returned at once.
+const apiMaxPageSize = 500
+
+// apiError is the body of every error reply of the JSON API.
+type apiError struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+	// Fields tells what's wrong with each invalid field of the request
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+type apiLocation struct {
+	Name    string `json:"name"`
+	Default bool   `json:"default"`
+}
+
+// apiProduct is a product with the totals of its lots. Amount sums the measured lots in grams or millilitres, and is
+// left out when there are none. Lots are only listed when a single product is requested.
+type apiProduct struct {
+	Barcode        string        `json:"barcode"`
+	Name           string        `json:"name"`
+	Brand          string        `json:"brand"`
+	Category       string        `json:"category"`
+	Tags           []string      `json:"tags"`
+	Quantity       int           `json:"quantity"`
+	Amount         float64       `json:"amount,omitempty"`
+	Unit           string        `json:"unit,omitempty"`
+	NextExpiration *time.Time    `json:"next_expiration"`
+	Lots           []models.Item `json:"lots,omitempty"`
+}
+
+type apiProductPage struct {
+	Products []apiProduct `json:"products"`
+	// Next is the cursor of the next page, empty on the last one
+	Next string `json:"next"`
+}
+
+// apiProductRequest is the body of a product update.
+type apiProductRequest struct {
+	Category string   `json:"category"`
+	Tags     []string `json:"tags"`
+}
+
+// apiLotRequest is the body of a lot creation or update. Dates are YYYY-MM-DD or RFC 3339 timestamps.
+type apiLotRequest struct {
+	Barcode        string  `json:"barcode"`
+	Name           string  `json:"name"`
+	Brand          string  `json:"brand"`
+	Quantity       int     `json:"quantity"`
+	Unit           string  `json:"unit"`
+	PackageAmount  float64 `json:"package_amount"`
+	ExpirationDate string  `json:"expiration_date"`
+	AdditionDate   string  `json:"added_at"`
+	Location       string  `json:"location"`
+	Note           string  `json:"note"`
+	Label          string  `json:"label"`
+	// Separate keeps a new lot apart from an identical one in stock, instead of adding the units to it
+	Separate bool `json:"separate"`
+}
+
+// apiSplitRequest is the body of a lot split: the units to move, and the fields of the new lot that differ from the
+// split one.
+type apiSplitRequest struct {
+	Quantity       int    `json:"quantity"`
+	ExpirationDate string `json:"expiration_date"`
+	Location       string `json:"location"`
+	Note           string `json:"note"`
+	Label          string `json:"label"`
+}
+
+func (rt *_router) apiListLocations(w http.ResponseWriter, _ *http.Request, _ httprouter.Params, _ reqcontext.RequestContext) {
+	locations := make([]apiLocation, 0, len(models.Locations))
+	for _, l := range models.Locations {
+		locations = append(locations, apiLocation{Name: l, Default: l == models.DefaultLocation})
+	}
+	replyJSON(w, http.StatusOK, locations)
+}
+
+// apiListProducts lists the products in stock, a page at a time. It takes the same filters of the fridge view, plus
+// `cursor` and `limit`.
+func (rt *_router) apiListProducts(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	limit := apiMaxPageSize
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > apiMaxPageSize {
+			replyError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", apiMaxPageSize))
+			return
+		}
+		limit = n
+	}
+
+	products, next, err := rt.db.GetFridge(parseFridgeFilter(r), r.URL.Query().Get("cursor"), limit)
+	if errors.Is(err, database.ErrInvalidCursor) {
+		replyError(w, http.StatusBadRequest, "invalid cursor")
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Error retrieving the products")
+		replyError(w, http.StatusInternalServerError, "error retrieving the products")
+		return
+	}
+
+	page := apiProductPage{Products: make([]apiProduct, 0, len(products)), Next: next}
+	for _, p := range products {
+		page.Products = append(page.Products, newAPIProduct(p))
+	}
+	replyJSON(w, http.StatusOK, page)
+}
+
+func (rt *_router) apiGetProduct(w http.ResponseWriter, _ *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	product, ok := rt.apiProduct(w, ps.ByName("barcode"), ctx)
+	if ok {
+		replyJSON(w, http.StatusOK, product)
+	}
+}
+
+// apiUpdateProduct sets the category and the tags of a product.
+func (rt *_router) apiUpdateProduct(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	barcode := ps.ByName("barcode")
+	stored, err := rt.db.GetProduct(barcode)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Error retrieving the product")
+		replyError(w, http.StatusInternalServerError, "error retrieving the product")
+		return
+	}
+	if stored.Id == 0 {
+		replyError(w, http.StatusNotFound, fmt.Sprintf("product %s not found", barcode))
+		return
+	}
+
+	var req apiProductRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		replyError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if _, ok := models.CategoryLabels[req.Category]; !ok {
+		replyInvalid(w, map[string]string{"category": "unknown category"})
+		return
+	}
+
+	product := models.Product{Barcode: barcode, Category: req.Category, Tags: parseTags(strings.Join(req.Tags, ","))}
+	if err = rt.audited(r).UpdateProduct(product); err != nil {
+		ctx.Logger.WithError(err).Error("Error updating product")
+		replyError(w, http.StatusInternalServerError, "error updating the product")
+		return
+	}
+	if reply, ok := rt.apiProduct(w, barcode, ctx); ok {
+		replyJSON(w, http.StatusOK, reply)
+	}
+}
+
+// apiListLots lists the lots, optionally only those of the product `barcode`.
+func (rt *_router) apiListLots(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	var lots []models.Item
+	var err error
+	if barcode := r.URL.Query().Get("barcode"); barcode != "" {
+		_, lots, err = rt.db.GetItemsByBarcode(barcode)
+	} else {
+		lots, err = rt.db.GetAllItems()
+	}
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Error retrieving the lots")
+		replyError(w, http.StatusInternalServerError, "error retrieving the lots")
+		return
+	}
+	if lots == nil {
+		lots = []models.Item{}
+	}
+	replyJSON(w, http.StatusOK, lots)
+}
+
+func (rt *_router) apiGetLot(w http.ResponseWriter, _ *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	if lot, ok := rt.apiLot(w, ps.ByName("id"), ctx); ok {
+		replyJSON(w, http.StatusOK, lot)
+	}
+}
+
+// apiCreateLot adds a lot, replying 201 with the new lot, or 200 with the identical lot in stock the units were added to.
+func (rt *_router) apiCreateLot(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	var req apiLotRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		replyError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	lot, fields := validateAPILot(req, nil)
+	if len(fields) > 0 {
+		replyInvalid(w, fields)
+		return
+	}
+
+	// a lot merged into an identical one in stock gets the id of that one
+	var err error
+	if lot.Id, err = uuid.NewV7(); err != nil {
+		ctx.Logger.WithError(err).Error("Error generating the lot id")
+		replyError(w, http.StatusInternalServerError, "error adding the lot")
+		return
+	}
+	info := models.ProductInfo{Barcode: lot.Barcode, Name: lot.Name, Brand: lot.Brand}
+	id, err := rt.audited(r).AddItem(info, lot, req.Separate)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Error adding item")
+		replyError(w, http.StatusInternalServerError, "error adding the lot")
+		return
+	}
+	if stored, ok := rt.apiLot(w, id, ctx); ok {
+		w.Header().Set("Location", apiVersionPrefix+"lots/"+id)
+		if id != lot.Id.String() {
+			replyJSON(w, http.StatusOK, stored)
+			return
+		}
+		replyJSON(w, http.StatusCreated, stored)
+	}
+}
+
+// apiUpdateLot edits a lot. The fields left out of the request keep their value; the barcode and the package size can't
+// be changed.
+func (rt *_router) apiUpdateLot(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	stored, ok := rt.apiLot(w, ps.ByName("id"), ctx)
+	if !ok {
+		return
+	}
+
+	req := apiLotRequest{
+		Name:           stored.Name,
+		Brand:          stored.Brand,
+		Quantity:       stored.Quantity,
+		ExpirationDate: stored.ExpirationDate.Format(time.RFC3339),
+		AdditionDate:   stored.AdditionDate.Format(time.RFC3339),
+		Location:       stored.Location,
+		Note:           stored.Note,
+		Label:          stored.Label,
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		replyError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	lot, fields := validateAPILot(req, &stored)
+	if len(fields) > 0 {
+		replyInvalid(w, fields)
+		return
+	}
+
+	lot.Id = stored.Id
+	err := rt.audited(r).UpdateItem(lot)
+	if errors.Is(err, database.ErrItemNotFound) {
+		replyError(w, http.StatusNotFound, fmt.Sprintf("lot %s not found", lot.Id))
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Error updating item")
+		replyError(w, http.StatusInternalServerError, "error updating the lot")
+		return
+	}
+	if updated, ok := rt.apiLot(w, stored.Id.String(), ctx); ok {
+		replyJSON(w, http.StatusOK, updated)
+	}
+}
+
+// apiSplitLot moves some units of a lot to a new lot, replying with the new one. At least one unit must stay.
+func (rt *_router) apiSplitLot(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	stored, ok := rt.apiLot(w, ps.ByName("id"), ctx)
+	if !ok {
+		return
+	}
+
+	req := apiSplitRequest{
+		ExpirationDate: stored.ExpirationDate.Format(time.RFC3339),
+		Location:       stored.Location,
+		Note:           stored.Note,
+		Label:          stored.Label,
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		replyError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	// the rest of the lot is checked like an update
+	part, fields := validateAPILot(apiLotRequest{
+		Name:           stored.Name,
+		Quantity:       req.Quantity,
+		ExpirationDate: req.ExpirationDate,
+		Location:       req.Location,
+		Note:           req.Note,
+		Label:          req.Label,
+	}, &stored)
+	if req.Quantity < 1 || req.Quantity >= stored.Quantity {
+		fields["quantity"] = fmt.Sprintf("must be between 1 and %d", stored.Quantity-1)
+	}
+	if len(fields) > 0 {
+		replyInvalid(w, fields)
+		return
+	}
+
+	id, err := rt.audited(r).SplitItem(stored.Id.String(), part)
+	if errors.Is(err, database.ErrNotEnoughStock) {
+		replyError(w, http.StatusConflict, "not enough units left to split")
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Error splitting item")
+		replyError(w, http.StatusInternalServerError, "error splitting the lot")
+		return
+	}
+	if lot, ok := rt.apiLot(w, id, ctx); ok {
+		w.Header().Set("Location", apiVersionPrefix+"lots/"+id)
+		replyJSON(w, http.StatusCreated, lot)
+	}
+}
+
+// apiDeleteLot moves a lot to the trash.
+func (rt *_router) apiDeleteLot(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	lot, ok := rt.apiLot(w, ps.ByName("id"), ctx)
+	if !ok {
+		return
+	}
+	err := rt.audited(r).DeleteItem(lot.Id.String())
+	if errors.Is(err, database.ErrItemNotFound) {
+		replyError(w, http.StatusNotFound, fmt.Sprintf("lot %s not found", lot.Id))
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Error deleting item")
+		replyError(w, http.StatusInternalServerError, "error deleting the lot")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiNotFound replies to the unknown paths: with an apiError under the JSON API, as usual elsewhere.
+func apiNotFound(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, apiVersionPrefix) {
+		replyError(w, http.StatusNotFound, "not found")
+		return
+	}
+	http.NotFound(w, r)
+}
+
+// apiMethodNotAllowed replies to the requests with an unsupported method, like apiNotFound.
+func apiMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, apiVersionPrefix) {
+		replyError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+}
+
+// apiProduct returns a product with its lots, replying with an error if it can't.
+func (rt *_router) apiProduct(w http.ResponseWriter, barcode string, ctx reqcontext.RequestContext) (apiProduct, bool) {
+	product, err := rt.db.GetProduct(barcode)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Error retrieving the product")
+		replyError(w, http.StatusInternalServerError, "error retrieving the product")
+		return apiProduct{}, false
+	}
+	_, lots, err := rt.db.GetItemsByBarcode(barcode)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Error retrieving the lots of the product")
+		replyError(w, http.StatusInternalServerError, "error retrieving the product")
+		return apiProduct{}, false
+	}
+	// products stay known after their lots are consumed, but they have no name left to show
+	if product.Id == 0 || len(lots) == 0 {
+		replyError(w, http.StatusNotFound, fmt.Sprintf("product %s not found", barcode))
+		return apiProduct{}, false
+	}
+
+	reply := apiProduct{
+		Barcode:  barcode,
+		Name:     lots[0].Name,
+		Brand:    lots[0].Brand,
+		Category: product.Category,
+		Tags:     product.Tags,
+		Lots:     lots,
+	}
+	if reply.Category == "" {
+		reply.Category = models.OtherCategory
+	}
+	if reply.Tags == nil {
+		reply.Tags = []string{}
+	}
+	var grams, millilitres float64
+	for i, lot := range lots {
+		reply.Quantity += lot.Quantity
+		amount, unit := models.BaseAmount(lot.Amount, lot.Unit)
+		switch unit {
+		case models.UnitGram:
+			grams += amount
+		case models.UnitMillilitre:
+			millilitres += amount
+		}
+		if i == 0 {
+			// lots are sorted by expiration
+			reply.NextExpiration = &lots[i].ExpirationDate
+		}
+	}
+	// like in the fridge, weights and volumes aren't summed up together
+	switch {
+	case grams > 0 && millilitres == 0:
+		reply.Amount, reply.Unit = models.RoundAmount(grams), models.UnitGram
+	case millilitres > 0 && grams == 0:
+		reply.Amount, reply.Unit = models.RoundAmount(millilitres), models.UnitMillilitre
+	}
+	return reply, true
+}
+
+// apiLot returns a lot, replying with an error if it can't.
+func (rt *_router) apiLot(w http.ResponseWriter, id string, ctx reqcontext.RequestContext) (models.Item, bool) {
+	lot, err := rt.db.GetItemById(id)
+	if errors.Is(err, database.ErrItemNotFound) {
+		replyError(w, http.StatusNotFound, fmt.Sprintf("lot %s not found", id))
+		return lot, false
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Error retrieving the lot")
+		replyError(w, http.StatusInternalServerError, "error retrieving the lot")
+		return lot, false
+	}
+	return lot, true
+}
+
+func newAPIProduct(p models.Item) apiProduct {
+	product := apiProduct{
+		Barcode:  p.Barcode,
+		Name:     p.Name,
+		Brand:    p.Brand,
+		Category: p.Category,
+		Tags:     p.Tags,
+		Quantity: p.Quantity,
+		Amount:   p.Amount,
+		Unit:     p.Unit,
+	}
+	if product.Tags == nil {
+		product.Tags = []string{}
+	}
+	if !p.ExpirationDate.IsZero() {
+		product.NextExpiration = &p.ExpirationDate
+	}
+	return product
+}
+
+// validateAPILot checks a lot request editing `stored`, or creating a lot when it's nil, and converts it to a lot. On
+// creation the barcode is required too, the package size is read, and the quantity and the addition date default to 1
+// piece and now. The bounds are the ones of the item forms.
+func validateAPILot(req apiLotRequest, stored *models.Item) (models.Item, map[string]string) {
+	creating := stored == nil
+	fields := make(map[string]string)
+	lot := models.Item{
+		Barcode:  strings.TrimSpace(req.Barcode),
+		Name:     strings.TrimSpace(req.Name),
+		Brand:    strings.TrimSpace(req.Brand),
+		Location: strings.TrimSpace(req.Location),
+		Note:     strings.TrimSpace(req.Note),
+		Label:    req.Label,
+	}
+	if lot.Name == "" {
+		fields["name"] = "required"
+	}
+
+	var err error
+	if req.ExpirationDate == "" {
+		fields["expiration_date"] = "required"
+	} else if lot.ExpirationDate, err = parseAPIDate(req.ExpirationDate); err != nil {
+		fields["expiration_date"] = err.Error()
+	}
+	if lot.Location == "" {
+		lot.Location = models.DefaultLocation
+	} else if !slices.Contains(models.Locations, lot.Location) {
+		fields["location"] = "must be one of " + strings.Join(models.Locations, ", ")
+	}
+	if lot.Label != "" && !slices.Contains(models.Labels, lot.Label) {
+		fields["label"] = "must be empty or one of " + strings.Join(models.Labels, ", ")
+	}
+
+	lot.Quantity = req.Quantity
+	if creating && req.Quantity == 0 {
+		lot.Quantity = 1
+	}
+	if req.AdditionDate != "" {
+		if lot.AdditionDate, err = parseAPIDate(req.AdditionDate); err != nil {
+			fields["added_at"] = err.Error()
+		}
+	}
+
+	now := time.Now()
+	if creating {
+		if !models.ValidUnit(req.Unit) {
+			fields["unit"] = "must be empty or one of " + strings.Join(models.Units, ", ")
+		} else if req.Unit != "" {
+			lot.Unit, lot.PackageAmount = req.Unit, models.RoundAmount(req.PackageAmount)
+		}
+		if req.AdditionDate == "" {
+			lot.AdditionDate = now
+		}
+	}
+	for _, b := range checkLotBounds(lot, stored, now) {
+		if _, ok := fields[b.apiField]; !ok {
+			fields[b.apiField] = b.api
+		}
+	}
+	return lot, fields
+}
+
+// parseAPIDate reads a date of the JSON API, as YYYY-MM-DD or as an RFC 3339 timestamp.
+func parseAPIDate(s string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, errors.New("must be a date as YYYY-MM-DD or an RFC 3339 timestamp")
+}
+
+func replyJSON(w http.ResponseWriter, status int, reply any) {
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(reply)
+}
+
+func replyError(w http.ResponseWriter, status int, message string) {
+	replyJSON(w, status, apiError{Status: status, Message: message})
+}
+
+// replyInvalid replies that the request has invalid `fields`, mapped to the reason.
+func replyInvalid(w http.ResponseWriter, fields map[string]string) {
+	replyJSON(w, http.StatusUnprocessableEntity, apiError{
+		Status:  http.StatusUnprocessableEntity,
+		Message: "invalid fields",
+		Fields:  fields,
+	})
+}
@@ -39,9 +39,13 @@ package api
 import (
 	"errors"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/lorenzougolini/wimf-app/service/backup"
 	"github.com/lorenzougolini/wimf-app/service/database"
+	"github.com/lorenzougolini/wimf-app/service/events"
 	"github.com/lorenzougolini/wimf-app/service/foodapi"
 	"github.com/sirupsen/logrus"
 )
@@ -51,6 +55,15 @@ type Config struct {
 	Logger   logrus.FieldLogger
 	Database database.AppDatabase
 	FoodApi  foodapi.Client
+
+	// TrashRetention is how long deleted lots stay in the trash before being purged
+	TrashRetention time.Duration
+
+	// Backups is reported by the liveness endpoint, nil when the scheduled backups are disabled
+	Backups *backup.Scheduler
+
+	// GrocyAPIKey enables the Grocy-compatible API, accepting requests carrying this key. Empty to disable it.
+	GrocyAPIKey string
 }
 
 // Router is the package API interface representing an API handler builder
@@ -58,6 +71,9 @@ type Router interface {
 	// Handler returns an HTTP handler for APIs provided in this package
 	Handler() http.Handler
 
+	// HandleEvent notifies the open pages of a change of the inventory; subscribe it to the event bus
+	HandleEvent(e events.Event)
+
 	// Close terminates any resource used in the package
 	Close() error
 }
@@ -71,6 +87,9 @@ func New(cfg Config) (Router, error) {
 	if cfg.Database == nil {
 		return nil, errors.New("database is required")
 	}
+	if cfg.TrashRetention <= 0 {
+		return nil, errors.New("trash retention must be positive")
+	}
 
 	// Create a new router where we will register HTTP endpoints. The server will pass requests to this router to be
 	// handled.
@@ -79,12 +98,25 @@ func New(cfg Config) (Router, error) {
 	router.RedirectTrailingSlash = false
 	router.RedirectFixedPath = false
 
-	return &_router{
-		router:     router,
-		baseLogger: cfg.Logger,
-		db:         cfg.Database,
-		foodApi:    cfg.FoodApi,
-	}, nil
+	rt := &_router{
+		router:         router,
+		baseLogger:     cfg.Logger,
+		db:             cfg.Database,
+		foodApi:        cfg.FoodApi,
+		trashRetention: cfg.TrashRetention,
+		backups:        cfg.Backups,
+		grocyAPIKey:    cfg.GrocyAPIKey,
+		events:         newEventHub(),
+		pairings:       newScanSessions(),
+		draftLookups:   make(chan struct{}, 1),
+		stop:           make(chan struct{}),
+	}
+	rt.background.Add(3)
+	go rt.purgeTrash()
+	go rt.watchExpirations()
+	go rt.lookupDraft()
+
+	return rt, nil
 }
 
 type _router struct {
@@ -97,4 +129,23 @@ type _router struct {
 	db database.AppDatabase
 
 	foodApi foodapi.Client
+
+	trashRetention time.Duration
+
+	backups *backup.Scheduler
+
+	grocyAPIKey string
+
+	// events notifies the pages connected to /events of the changes published on the event bus
+	events *eventHub
+
+	// pairings are the scan sessions between a desktop and the phones scanning for it
+	pairings *scanSessions
+
+	// draftLookups wakes up the lookups of the barcodes added to the bulk add draft
+	draftLookups chan struct{}
+
+	// stop is closed by Close to terminate the background goroutines, tracked by background
+	stop       chan struct{}
+	background sync.WaitGroup
 }
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"database/sql"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lorenzougolini/wimf-app/service/database"
+	"github.com/lorenzougolini/wimf-app/service/events"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/sirupsen/logrus"
+)
+
+// testGrocyAPIKey enables the Grocy-compatible API of the test servers.
+const testGrocyAPIKey = "test-key"
+
+// newTestServer serves the routes of the package on an empty in-memory database, closed at the end of the test.
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv, _ := newTestServerDB(t)
+	return srv
+}
+
+// newTestServerDB is newTestServer also returning its database, to store what the routes would refuse.
+func newTestServerDB(t *testing.T) (*httptest.Server, database.AppDatabase) {
+	t.Helper()
+	conn, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection would open a database of its own
+	conn.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = conn.Close() })
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	// wired like in cmd/webapi, for the pages connected to /events
+	bus := events.New(logger)
+	db, err := database.New(conn, bus)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rt, err := New(Config{Logger: logger, Database: db, TrashRetention: time.Hour, GrocyAPIKey: testGrocyAPIKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus.Subscribe("sse", 16, rt.HandleEvent)
+	srv := httptest.NewServer(rt.Handler())
+	t.Cleanup(func() {
+		srv.Close()
+		_ = rt.Close()
+		_ = bus.Close()
+	})
+	return srv, db
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/lorenzougolini/wimf-app/service/api/reqcontext"
+	"github.com/lorenzougolini/wimf-app/service/database"
+	"github.com/lorenzougolini/wimf-app/service/models"
+	"github.com/lorenzougolini/wimf-app/service/templates"
+)
+
+// auditPageSize is how many audit entries are shown per page.
+const auditPageSize = 50
+
+// audited returns the database to use for the changes requested by `r`, so they are logged with their endpoint.
+func (rt *_router) audited(r *http.Request) database.AppDatabase {
+	return rt.db.WithSource(models.AuditSource{Endpoint: r.Method + " " + r.URL.Path})
+}
+
+func (rt *_router) getAudit(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	filter := parseAuditFilter(r)
+	before, _ := strconv.ParseInt(r.URL.Query().Get("before"), 10, 64)
+
+	entries, err := rt.db.GetAudit(filter, before, auditPageSize)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Error retrieving the audit log")
+		http.Error(w, "Error retrieving the audit log", http.StatusInternalServerError)
+		return
+	}
+
+	var next int64
+	if len(entries) == auditPageSize {
+		next = entries[len(entries)-1].Id
+	}
+	if err = templates.Audit(entries, filter, next).Render(r.Context(), w); err != nil {
+		ctx.Logger.WithError(err).Error("Error rendering the audit log")
+	}
+}
+
+// parseAuditFilter reads the models.AuditFilter of the audit page from the URL query, ignoring invalid values.
+func parseAuditFilter(r *http.Request) models.AuditFilter {
+	q := r.URL.Query()
+	filter := models.AuditFilter{Query: strings.TrimSpace(q.Get("q"))}
+	if action := q.Get("action"); slices.Contains(models.AuditActions, action) {
+		filter.Action = action
+	}
+	filter.From, _ = time.Parse("2006-01-02", q.Get("from"))
+	filter.To, _ = time.Parse("2006-01-02", q.Get("to"))
+	return filter
+}
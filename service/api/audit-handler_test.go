@@ -0,0 +1,89 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lorenzougolini/wimf-app/service/models"
+)
+
+// TestAuditEveryMutation changes a lot and its product through the forms: every change must be in the audit log,
+// newest first, with the endpoint that made it and the state of the lot before and after it.
+func TestAuditEveryMutation(t *testing.T) {
+	srv, db := newTestServerDB(t)
+	send := func(method, path string, form url.Values) {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("content-type", "application/x-www-form-urlencoded")
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s %s: status %d, want 200", method, path, resp.StatusCode)
+		}
+	}
+	expiration := time.Now().AddDate(0, 0, 10).Format("2006-01-02")
+	send(http.MethodPost, "/fridge/items", url.Values{"barcode": {"8005678"}, "name": {"Yogurt"},
+		"expiration_date": {expiration}, "location": {"Frigo"}})
+	_, lots, err := db.GetItemsByBarcode("8005678")
+	if err != nil || len(lots) != 1 {
+		t.Fatalf("lots = %v, %v; want the one added", lots, err)
+	}
+	id := lots[0].Id.String()
+	send(http.MethodPut, "/fridge/items", url.Values{"id": {id}, "name": {"Yogurt"},
+		"expiration_date": {expiration}, "location": {"Dispensa"}})
+	send(http.MethodPut, "/fridge/product", url.Values{"barcode": {"8005678"}, "category": {"dairy"},
+		"tags": {"colazione"}})
+	send(http.MethodDelete, "/fridge/item?id="+id, nil)
+
+	entries, err := db.GetAudit(models.AuditFilter{}, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct{ action, entity, endpoint string }{
+		{models.AuditDelete, models.AuditItem, "DELETE /fridge/item"},
+		{models.AuditUpdate, models.AuditProduct, "PUT /fridge/product"},
+		{models.AuditUpdate, models.AuditItem, "PUT /fridge/items"},
+		{models.AuditAdd, models.AuditItem, "POST /fridge/items"},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("%d audit entries, want %d: %+v", len(entries), len(want), entries)
+	}
+	for i, w := range want {
+		e := entries[i]
+		if e.Action != w.action || e.Entity != w.entity || e.Endpoint != w.endpoint || e.Barcode != "8005678" {
+			t.Errorf("entry %d = %s %s by %q of %s, want %s %s by %q", i, e.Action, e.Entity, e.Endpoint, e.Barcode,
+				w.action, w.entity, w.endpoint)
+		}
+	}
+	if update := entries[2]; !strings.Contains(update.Before, `"location":"Frigo"`) ||
+		!strings.Contains(update.After, `"location":"Dispensa"`) {
+		t.Errorf("update = %s -> %s, want the location from Frigo to Dispensa", update.Before, update.After)
+	}
+	if add := entries[3]; add.Before != "" || add.After == "" {
+		t.Errorf("add = %q -> %q, want only the state after it", add.Before, add.After)
+	}
+
+	resp, err := srv.Client().Get(srv.URL + models.AuditFilter{Action: models.AuditDelete}.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "Yogurt") {
+		t.Errorf("audit page of the deletions: status %d, Yogurt shown %t", resp.StatusCode,
+			strings.Contains(string(body), "Yogurt"))
+	}
+}
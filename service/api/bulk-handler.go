@@ -0,0 +1,305 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/lorenzougolini/wimf-app/service/api/reqcontext"
+	"github.com/lorenzougolini/wimf-app/service/database"
+	"github.com/lorenzougolini/wimf-app/service/models"
+	"github.com/lorenzougolini/wimf-app/service/templates"
+)
+
+// draftChanged is the event sent on /events when the bulk add draft changes, refreshing its review table.
+const draftChanged = "draft-changed"
+
+// getBulk shows the bulk add page: a scanner filling the draft and the table to review it.
+func (rt *_router) getBulk(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	draft, err := rt.db.GetDraft()
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Error retrieving the draft")
+		http.Error(w, "Error retrieving the draft", http.StatusInternalServerError)
+		return
+	}
+	if err = templates.Bulk(draft).Render(r.Context(), w); err != nil {
+		ctx.Logger.WithError(err).Error("Error rendering the bulk add page")
+	}
+}
+
+// getBulkTable renders the review table of the draft, refreshed when the draft changes.
+func (rt *_router) getBulkTable(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	rt.renderBulkTable(w, r, nil, "", ctx)
+}
+
+// renderBulkTable renders the review table with the current draft, the errors of its rows (by id, "" for the whole
+// draft) and a confirmation `message`.
+func (rt *_router) renderBulkTable(w http.ResponseWriter, r *http.Request, errs models.FormErrors, message string, ctx reqcontext.RequestContext) {
+	draft, err := rt.db.GetDraft()
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Error retrieving the draft")
+		http.Error(w, "Error retrieving the draft", http.StatusInternalServerError)
+		return
+	}
+	if err = templates.BulkTable(draft, errs, message).Render(r.Context(), w); err != nil {
+		ctx.Logger.WithError(err).Error("Error rendering the draft")
+	}
+}
+
+// scanBulk adds a scanned barcode to the draft, replying with the status shown under the scanner. New barcodes are
+// looked up in background.
+func (rt *_router) scanBulk(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	barcode := strings.TrimSpace(r.PostFormValue("barcode"))
+	if len(barcode) < minBarcodeLength {
+		http.Error(w, "Invalid barcode", http.StatusBadRequest)
+		return
+	}
+
+	added, err := rt.db.ScanDraftItem(barcode)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Error adding to the draft")
+		http.Error(w, "Error adding to the draft", http.StatusInternalServerError)
+		return
+	}
+	if added {
+		select {
+		case rt.draftLookups <- struct{}{}:
+		default:
+		}
+	}
+	rt.events.publish(draftChanged)
+	if err = templates.BulkScanStatus(barcode, added).Render(r.Context(), w); err != nil {
+		ctx.Logger.WithError(err).Error("Error rendering the scan status")
+	}
+}
+
+// updateBulkRow saves a row of the review table as soon as one of its fields changes. Invalid values are kept as they
+// were: the errors are shown when committing the draft.
+func (rt *_router) updateBulkRow(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	item, err := rt.db.GetDraftItem(r.URL.Query().Get("id"))
+	if errors.Is(err, database.ErrItemNotFound) {
+		http.Error(w, "Draft item not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Error retrieving the draft item")
+		http.Error(w, "Error retrieving the draft item", http.StatusInternalServerError)
+		return
+	}
+	if err = r.ParseForm(); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	item.Name = strings.TrimSpace(r.PostFormValue("name"))
+	item.Brand = strings.TrimSpace(r.PostFormValue("brand"))
+	if quantity, err := strconv.Atoi(r.PostFormValue("quantity")); err == nil && quantity >= 1 && quantity <= maxLotQuantity {
+		item.Quantity = quantity
+	}
+	if date := strings.TrimSpace(r.PostFormValue("expiration_date")); date == "" {
+		item.ExpirationDate = time.Time{}
+	} else if expiration, err := time.Parse("2006-01-02", date); err == nil {
+		item.ExpirationDate = expiration
+	}
+	if location := r.PostFormValue("location"); slices.Contains(models.Locations, location) {
+		item.Location = location
+	}
+
+	if err = rt.db.UpdateDraftItem(item); err != nil {
+		ctx.Logger.WithError(err).Error("Error updating the draft item")
+		http.Error(w, "Error updating the draft item", http.StatusInternalServerError)
+		return
+	}
+	// the other tabs show the change, and this one the row merged into an identical one
+	rt.events.publish(draftChanged)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteBulkRow removes a row from the draft.
+func (rt *_router) deleteBulkRow(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	if err := rt.db.DeleteDraftItem(r.URL.Query().Get("id")); err != nil {
+		ctx.Logger.WithError(err).Error("Error deleting the draft item")
+		http.Error(w, "Error deleting the draft item", http.StatusInternalServerError)
+		return
+	}
+	rt.events.publish(draftChanged)
+	rt.renderBulkTable(w, r, nil, "", ctx)
+}
+
+// clearBulk discards the whole draft.
+func (rt *_router) clearBulk(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	if err := rt.db.ClearDraft(); err != nil {
+		ctx.Logger.WithError(err).Error("Error clearing the draft")
+		http.Error(w, "Error clearing the draft", http.StatusInternalServerError)
+		return
+	}
+	rt.events.publish(draftChanged)
+	rt.renderBulkTable(w, r, nil, "", ctx)
+}
+
+// applyBulkRule sets the expiration dates of the draft by rule: `rule=category` guesses them from the usual shelf life
+// of each category, `rule=days` sets them `days` days from today. With `only_missing` the dates already set are kept.
+func (rt *_router) applyBulkRule(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	draft, err := rt.db.GetDraft()
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Error retrieving the draft")
+		http.Error(w, "Error retrieving the draft", http.StatusInternalServerError)
+		return
+	}
+
+	today := time.Now().Truncate(24 * time.Hour)
+	onlyMissing := r.PostFormValue("only_missing") != ""
+	days, err := strconv.Atoi(r.PostFormValue("days"))
+	rule := r.PostFormValue("rule")
+	if rule == "days" && (err != nil || days < 0 || days > maxShelfLife*365) {
+		rt.renderBulkTable(w, r, models.FormErrors{"": "Inserisci un numero di giorni valido."}, "", ctx)
+		return
+	}
+
+	dates := make(map[string]time.Time)
+	for _, item := range draft {
+		if onlyMissing && !item.ExpirationDate.IsZero() {
+			continue
+		}
+		switch rule {
+		case "category":
+			category := item.Category
+			if _, ok := models.ShelfLife[category]; !ok {
+				category = models.OtherCategory
+			}
+			dates[item.Id] = today.AddDate(0, 0, models.ShelfLife[category])
+		case "days":
+			dates[item.Id] = today.AddDate(0, 0, days)
+		}
+	}
+	if err = rt.db.SetDraftExpirations(dates); err != nil {
+		ctx.Logger.WithError(err).Error("Error setting the draft expirations")
+		http.Error(w, "Error setting the draft expirations", http.StatusInternalServerError)
+		return
+	}
+	rt.events.publish(draftChanged)
+	rt.renderBulkTable(w, r, nil, "", ctx)
+}
+
+// commitBulk stores the whole draft in one transaction, once every row has a name and a plausible expiration date.
+func (rt *_router) commitBulk(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	draft, err := rt.db.GetDraft()
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Error retrieving the draft")
+		http.Error(w, "Error retrieving the draft", http.StatusInternalServerError)
+		return
+	}
+	if len(draft) == 0 {
+		rt.renderBulkTable(w, r, models.FormErrors{"": "Non c'è niente da aggiungere: scansiona qualche prodotto."}, "", ctx)
+		return
+	}
+	if errs := validateDraft(draft); len(errs) > 0 {
+		errs[""] = "Completa le righe evidenziate prima di aggiungere."
+		rt.renderBulkTable(w, r, errs, "", ctx)
+		return
+	}
+
+	added, err := rt.audited(r).CommitDraft()
+	if errors.Is(err, database.ErrDraftIncomplete) {
+		// the draft changed in the meantime, e.g. from another device
+		rt.renderBulkTable(w, r, models.FormErrors{"": "La spesa è cambiata nel frattempo, controlla e riprova."}, "", ctx)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Error committing the draft")
+		rt.renderBulkTable(w, r, models.FormErrors{"": "Errore durante il salvataggio, riprova."}, "", ctx)
+		return
+	}
+	ctx.Logger.Infof("%d lots added from the draft", added)
+	rt.events.publish(draftChanged)
+	rt.renderBulkTable(w, r, nil, strconv.Itoa(added)+" prodotti aggiunti al frigo.", ctx)
+}
+
+// validateDraft checks the rows of the draft with the bounds of the item forms, returning the error of each invalid
+// row by id.
+func validateDraft(draft []models.DraftItem) models.FormErrors {
+	errs := models.FormErrors{}
+	today := time.Now().Truncate(24 * time.Hour)
+	for _, item := range draft {
+		switch {
+		case item.Name == "" && item.Status == models.DraftPending:
+			errs[item.Id] = "Ricerca in corso, attendi o inserisci il nome."
+		case item.Name == "":
+			errs[item.Id] = "Inserisci il nome del prodotto."
+		case item.ExpirationDate.IsZero():
+			errs[item.Id] = "Inserisci la data di scadenza."
+		default:
+			if msg := checkExpiration(item.ExpirationDate, today); msg != "" {
+				errs[item.Id] = msg
+			}
+		}
+	}
+	return errs
+}
+
+// lookupDraft resolves in background the barcodes of the draft waiting for a lookup, first among the products already
+// stored and then on Open Food Facts, until Close is called. It's woken up by draftLookups.
+func (rt *_router) lookupDraft() {
+	defer rt.background.Done()
+
+	for {
+		draft, err := rt.db.GetDraft()
+		if err != nil {
+			rt.baseLogger.WithError(err).Error("error retrieving the draft")
+		}
+		for _, item := range draft {
+			if item.Status != models.DraftPending {
+				continue
+			}
+			select {
+			case <-rt.stop:
+				return
+			default:
+			}
+
+			product, status := rt.lookupBarcode(item.Barcode)
+			if err := rt.db.ResolveDraftItem(item.Id, product, status); err != nil {
+				rt.baseLogger.WithError(err).Error("error resolving the draft item")
+				continue
+			}
+			rt.events.publish(draftChanged)
+		}
+
+		select {
+		case <-rt.stop:
+			return
+		case <-rt.draftLookups:
+		}
+	}
+}
+
+// lookupBarcode finds the name, the brand, the category and the package size of a barcode, returning the lookup state
+// of the draft.
+func (rt *_router) lookupBarcode(barcode string) (models.ProductInfo, string) {
+	exists, lots, err := rt.db.GetItemsByBarcode(barcode)
+	if err == nil && exists {
+		product, err := rt.db.GetProduct(barcode)
+		if err != nil {
+			rt.baseLogger.WithError(err).Error("error retrieving the product")
+		}
+		return models.ProductInfo{Barcode: barcode, Name: lots[0].Name, Brand: lots[0].Brand, Category: product.Category,
+			Unit: lots[0].Unit, PackageAmount: lots[0].PackageAmount}, models.DraftFound
+	}
+
+	info, err := rt.foodApi.GetProductByBarcode(barcode)
+	if err != nil {
+		rt.baseLogger.WithError(err).Warnf("lookup of %s failed", barcode)
+		return models.ProductInfo{Barcode: barcode}, models.DraftNotFound
+	}
+	return info, models.DraftFound
+}
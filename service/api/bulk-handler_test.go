@@ -0,0 +1,53 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lorenzougolini/wimf-app/service/models"
+)
+
+// TestBulkRowNotifiesTabs edits a row of the bulk add draft while another tab listens to /events: the tab must be told
+// to refresh its table.
+func TestBulkRowNotifiesTabs(t *testing.T) {
+	srv, db := newTestServerDB(t)
+	// a product already stored is looked up without Open Food Facts
+	_, err := db.AddItem(models.ProductInfo{Barcode: "8005678", Name: "Yogurt"}, models.Item{
+		Quantity:       1,
+		ExpirationDate: time.Now().AddDate(0, 0, 10),
+		AdditionDate:   time.Now(),
+		Location:       models.DefaultLocation,
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.ScanDraftItem("8005678"); err != nil {
+		t.Fatal(err)
+	}
+	draft, err := db.GetDraft()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := listenEvents(t, srv)
+
+	form := url.Values{"name": {"Yogurt"}, "quantity": {"2"}, "location": {"Frigo"}}
+	req, err := http.NewRequest(http.MethodPut, srv.URL+"/bulk/rows?id="+draft[0].Id, strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("content-type", "application/x-www-form-urlencoded")
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("status %d, want 204", resp.StatusCode)
+	}
+
+	waitEvent(t, lines, draftChanged)
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lorenzougolini/wimf-app/service/client"
+	"github.com/lorenzougolini/wimf-app/service/models"
+)
+
+// TestClientRoundTrip uses every method of the client against the server, checking that what's sent is read back.
+func TestClientRoundTrip(t *testing.T) {
+	c := client.New(newTestServer(t).URL)
+	expiration := time.Now().AddDate(0, 1, 0).Format("2006-01-02")
+
+	locations, err := c.ListLocations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locations) != len(models.Locations) {
+		t.Errorf("%d locations, want %d", len(locations), len(models.Locations))
+	}
+
+	lot, err := c.CreateLot(client.LotRequest{
+		Barcode:        "8001234",
+		Name:           "Latte",
+		Brand:          "Alpino",
+		Quantity:       3,
+		Unit:           models.UnitLitre,
+		PackageAmount:  1,
+		ExpirationDate: expiration,
+		Location:       "Frigo",
+		Note:           "intero",
+		Label:          "blue",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lot.Name != "Latte" || lot.Brand != "Alpino" || lot.Quantity != 3 || lot.Unit != models.UnitLitre ||
+		lot.Amount != 3 || lot.PackageAmount != 1 || lot.ExpirationDate.Format("2006-01-02") != expiration ||
+		lot.Location != "Frigo" || lot.Note != "intero" || lot.Label != "blue" {
+		t.Errorf("created lot = %+v", lot)
+	}
+	// an identical lot is added to the first one, unless kept apart
+	same := client.LotRequest{Barcode: "8001234", Name: "Latte", Quantity: 1, Unit: models.UnitLitre,
+		PackageAmount: 1, ExpirationDate: expiration, Location: "Frigo", Note: "intero", Label: "blue"}
+	if merged, err := c.CreateLot(same); err != nil || merged.Id != lot.Id || merged.Quantity != 4 {
+		t.Errorf("identical lot = %+v, %v; want the first one with 4 packages", merged, err)
+	}
+	same.Separate = true
+	apart, err := c.CreateLot(same)
+	if err != nil || apart.Id == lot.Id {
+		t.Errorf("separate lot = %+v, %v; want a new lot", apart, err)
+	}
+
+	name, quantity := "Latte fresco", 2
+	updated, err := c.UpdateLot(lot.Id.String(), client.LotUpdate{Name: &name, Quantity: &quantity})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Name != name || updated.Quantity != 2 || updated.Brand != "Alpino" || updated.Note != "intero" {
+		t.Errorf("updated lot = %+v", updated)
+	}
+
+	part, err := c.SplitLot(lot.Id.String(), client.LotSplit{Quantity: 1, Location: "Freezer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if part.Quantity != 1 || part.Location != "Freezer" || part.Name != name || part.Unit != models.UnitLitre {
+		t.Errorf("split lot = %+v", part)
+	}
+	if got, err := c.GetLot(lot.Id.String()); err != nil || got.Quantity != 1 {
+		t.Errorf("lot after the split = %+v, %v; want 1 package", got, err)
+	}
+
+	lots, err := c.ListLots("8001234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lots) != 3 {
+		t.Errorf("%d lots, want 3", len(lots))
+	}
+
+	product, err := c.UpdateProduct("8001234", "dairy", []string{"colazione"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if product.Category != "dairy" || len(product.Tags) != 1 || product.Tags[0] != "colazione" {
+		t.Errorf("updated product = %+v", product)
+	}
+	if product, err = c.GetProduct("8001234"); err != nil {
+		t.Fatal(err)
+	}
+	if product.Quantity != 3 || product.Amount != 3000 || product.Unit != models.UnitMillilitre || len(product.Lots) != 3 {
+		t.Errorf("product = %d packages, %v %s, %d lots; want 3, 3000 ml, 3", product.Quantity, product.Amount,
+			product.Unit, len(product.Lots))
+	}
+	products, err := c.AllProducts(models.FridgeFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(products) != 1 || products[0].Barcode != "8001234" || products[0].Category != "dairy" {
+		t.Errorf("products = %+v", products)
+	}
+
+	if err = c.DeleteLot(lot.Id.String()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.GetLot(lot.Id.String()); !client.IsNotFound(err) {
+		t.Errorf("deleted lot: %v, want not found", err)
+	}
+	if _, err = c.CreateLot(client.LotRequest{Barcode: "8001234"}); err == nil {
+		t.Error("lot without name and expiration created")
+	} else if e, ok := err.(*client.Error); !ok || e.Fields["name"] == "" || e.Fields["expiration_date"] == "" {
+		t.Errorf("invalid lot: %v, want the invalid fields", err)
+	}
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/lorenzougolini/wimf-app/service/api/reqcontext"
+	"github.com/lorenzougolini/wimf-app/service/database"
+	"github.com/lorenzougolini/wimf-app/service/templates"
+)
+
+// consumeItem takes one unit of a product scanned in consume mode, from the lot that expires first. When several lots
+// expire on that same day the user picks one in a modal, which posts back the `id` of the lot.
+func (rt *_router) consumeItem(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	id := r.PostFormValue("id")
+	if id == "" {
+		barcode := strings.TrimSpace(r.PostFormValue("barcode"))
+		if len(barcode) < minBarcodeLength {
+			http.Error(w, "Invalid barcode", http.StatusBadRequest)
+			return
+		}
+		_, lots, err := rt.db.GetItemsByBarcode(barcode)
+		if err != nil {
+			ctx.Logger.WithError(err).Error("Error retrieving the lots to consume")
+			http.Error(w, "Error retrieving the lots", http.StatusInternalServerError)
+			return
+		}
+		if len(lots) == 0 {
+			if err = templates.ConsumeStatus(barcode, "Non c'è nel frigo").Render(r.Context(), w); err != nil {
+				ctx.Logger.WithError(err).Error("Error rendering the consume status")
+			}
+			return
+		}
+
+		// the lots are ordered by expiration date, the first ones expiring on the same day are a tie
+		nearest := lots[:1]
+		for _, lot := range lots[1:] {
+			if !sameDay(lot.ExpirationDate, lots[0].ExpirationDate) {
+				break
+			}
+			nearest = append(nearest, lot)
+		}
+		if len(nearest) > 1 {
+			w.Header().Set("HX-Retarget", "#modals")
+			w.Header().Set("HX-Reswap", "innerHTML")
+			if err = templates.ConsumeChoiceModal(nearest).Render(r.Context(), w); err != nil {
+				ctx.Logger.WithError(err).Error("Error rendering the consume choice")
+			}
+			return
+		}
+		id = lots[0].Id.String()
+	}
+
+	item, entry, err := rt.audited(r).ConsumeItem(id, 1)
+	if errors.Is(err, database.ErrItemNotFound) {
+		http.Error(w, "Item not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Error consuming item")
+		http.Error(w, "Error consuming item", http.StatusInternalServerError)
+		return
+	}
+	if err = templates.ConsumeStatus(item.Barcode, "").Render(r.Context(), w); err != nil {
+		ctx.Logger.WithError(err).Error("Error rendering the consume status")
+	}
+	if err = templates.ConsumeToast(item, entry, undoWindow).Render(r.Context(), w); err != nil {
+		ctx.Logger.WithError(err).Error("Error rendering the consume toast")
+	}
+}
+
+// undoConsume gives back the unit taken by consumeItem, from the toast shown after the scan.
+func (rt *_router) undoConsume(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	id := r.URL.Query().Get("id")
+	entry, err := strconv.ParseInt(r.URL.Query().Get("entry"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid consumption", http.StatusBadRequest)
+		return
+	}
+
+	err = rt.audited(r).UndoConsume(id, entry)
+	if errors.Is(err, database.ErrNothingToUndo) {
+		http.Error(w, "Nothing to undo", http.StatusNotFound)
+		return
+	} else if errors.Is(err, database.ErrUndoConflict) {
+		http.Error(w, "The item changed after the consumption", http.StatusConflict)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Error undoing the consumption")
+		http.Error(w, "Error undoing the consumption", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// sameDay tells if `a` and `b` fall on the same calendar day.
+func sameDay(a, b time.Time) bool {
+	return a.Format("2006-01-02") == b.Format("2006-01-02")
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/lorenzougolini/wimf-app/service/api/reqcontext"
+	"github.com/lorenzougolini/wimf-app/service/database"
+	"github.com/lorenzougolini/wimf-app/service/models"
+	"github.com/lorenzougolini/wimf-app/service/templates"
+)
+
+// leftoverShelfLife is the default shelf life proposed for a new leftover.
+const leftoverShelfLife = 3
+
+func (rt *_router) getCookForm(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	form := cookForm{expiration: time.Now().AddDate(0, 0, leftoverShelfLife)}
+	if err := rt.renderCookModal(w, r, form, nil); err != nil {
+		ctx.Logger.WithError(err).Error("Error rendering cook modal")
+		http.Error(w, "Error rendering modal", http.StatusInternalServerError)
+	}
+}
+
+// cookItems consumes the ingredients chosen in the cook modal and stores the leftover, closing the modal. On error the
+// modal is shown again with the messages next to the fields.
+func (rt *_router) cookItems(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	form, errs := parseCookForm(r)
+	if len(errs) > 0 {
+		rt.renderCookFormErrors(w, r, form, errs, ctx)
+		return
+	}
+
+	leftover := models.ProductInfo{Name: form.name, Brand: "Avanzi"}
+	code, err := rt.audited(r).CookItems(leftover, form.ingredients, form.expiration)
+	if errors.Is(err, database.ErrItemNotFound) {
+		// used up or deleted in the meantime, e.g. from another tab
+		errs["ingredients"] = "Un ingrediente non è più nel frigo."
+	} else if errors.Is(err, database.ErrNotEnoughStock) {
+		errs["ingredients"] = "Non ce n'è abbastanza di un ingrediente."
+	} else if errors.Is(err, database.ErrIncompatibleUnit) {
+		errs["ingredients"] = "L'unità di un ingrediente non è valida."
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Error while cooking items")
+		errs[""] = "Errore durante il salvataggio, riprova."
+	}
+	if len(errs) > 0 {
+		rt.renderCookFormErrors(w, r, form, errs, ctx)
+		return
+	}
+
+	ctx.Logger.Infof("Leftover %s created from %d lots", code, len(form.ingredients))
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(models.Item{Barcode: code})
+}
+
+// cookForm is the content of the cook modal: the leftover to store and the lots used to cook it.
+type cookForm struct {
+	name        string
+	expiration  time.Time
+	ingredients []models.Ingredient
+}
+
+// parseCookForm reads and validates the cook form of `r`. The form is returned even when invalid, to show it again
+// with the errors next to the fields.
+func parseCookForm(r *http.Request) (cookForm, models.FormErrors) {
+	errs := models.FormErrors{}
+	if err := r.ParseForm(); err != nil {
+		errs[""] = "Richiesta non valida, riprova."
+		return cookForm{}, errs
+	}
+
+	form := cookForm{name: strings.TrimSpace(r.PostFormValue("name"))}
+	if form.name == "" {
+		errs["name"] = "Inserisci il nome del piatto."
+	} else if utf8.RuneCountInString(form.name) > maxNameLength {
+		errs["name"] = "Il nome può avere al massimo " + strconv.Itoa(maxNameLength) + " caratteri."
+	}
+
+	expiration, msg := parseFormDate(r.PostFormValue("expiration_date"), "la data di scadenza")
+	today := time.Now().Truncate(24 * time.Hour)
+	if msg == "" && expiration.Before(today) {
+		msg = "Gli avanzi appena cucinati non possono essere già scaduti."
+	} else if msg == "" {
+		msg = checkExpiration(expiration, today)
+	}
+	if msg != "" {
+		errs["expiration_date"] = msg
+	}
+	form.expiration = expiration
+
+	// every lot in the form has a "qty-<id>" field, or an "amount-<id>" and a "unit-<id>" one when it's measured. The
+	// lots left at zero are not used
+	for key := range r.PostForm {
+		if id, ok := strings.CutPrefix(key, "qty-"); ok {
+			quantity, err := strconv.Atoi(strings.TrimSpace(r.PostForm.Get(key)))
+			if err != nil || quantity < 0 || quantity > maxLotQuantity {
+				errs["ingredients"] = "Le unità usate devono essere tra 0 e " + strconv.Itoa(maxLotQuantity) + "."
+			} else if quantity > 0 {
+				form.ingredients = append(form.ingredients, models.Ingredient{ItemId: id, Quantity: quantity})
+			}
+		} else if id, ok := strings.CutPrefix(key, "amount-"); ok && strings.TrimSpace(r.PostForm.Get(key)) != "" {
+			amount, err := models.ParseAmount(r.PostForm.Get(key))
+			if err != nil || amount < 0 {
+				errs["ingredients"] = "Inserisci le quantità usate come numeri, ad esempio 200."
+			} else if amount > 0 {
+				form.ingredients = append(form.ingredients,
+					models.Ingredient{ItemId: id, Amount: amount, Unit: r.PostForm.Get("unit-" + id)})
+			}
+		}
+	}
+	if len(form.ingredients) == 0 && !errs.Has("ingredients") {
+		errs["ingredients"] = "Scegli almeno un ingrediente."
+	}
+	return form, errs
+}
+
+// renderCookModal renders the cook modal with the lots in stock, filled with `form`.
+func (rt *_router) renderCookModal(w http.ResponseWriter, r *http.Request, form cookForm, errs models.FormErrors) error {
+	items, err := rt.db.GetAllItems()
+	if err != nil {
+		return err
+	}
+	return templates.CookModal(items, form.name, form.expiration, form.ingredients, errs).Render(r.Context(), w)
+}
+
+// renderCookFormErrors shows the cook modal again in place of the one submitted, with the errors of the form.
+func (rt *_router) renderCookFormErrors(w http.ResponseWriter, r *http.Request, form cookForm, errs models.FormErrors, ctx reqcontext.RequestContext) {
+	w.Header().Set("HX-Retarget", "#modal-backdrop")
+	w.Header().Set("HX-Reswap", "outerHTML")
+	if err := rt.renderCookModal(w, r, form, errs); err != nil {
+		ctx.Logger.WithError(err).Error("Error rendering the cook form")
+		http.Error(w, "Error rendering modal", http.StatusInternalServerError)
+	}
+}
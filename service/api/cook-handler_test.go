@@ -0,0 +1,102 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lorenzougolini/wimf-app/service/client"
+	"github.com/lorenzougolini/wimf-app/service/models"
+)
+
+// TestCookFormBounds cooks with an invalid form: the modal is shown again with the error and the ingredients are left
+// untouched, until the form is fixed.
+func TestCookFormBounds(t *testing.T) {
+	srv := newTestServer(t)
+	c := client.New(srv.URL)
+	lot, err := c.CreateLot(client.LotRequest{Barcode: "8005678", Name: "Yogurt", Quantity: 2,
+		ExpirationDate: time.Now().AddDate(0, 0, 10).Format("2006-01-02")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	qty := "qty-" + lot.Id.String()
+
+	tests := []struct {
+		field string
+		form  url.Values
+	}{
+		{"name", url.Values{"name": {""}}},
+		{"name", url.Values{"name": {strings.Repeat("a", maxNameLength+1)}}},
+		{"expiration_date", url.Values{"expiration_date": {"domani"}}},
+		{"expiration_date", url.Values{"expiration_date": {time.Now().AddDate(0, 0, -1).Format("2006-01-02")}}},
+		{"expiration_date", url.Values{"expiration_date": {time.Now().AddDate(11, 0, 0).Format("2006-01-02")}}},
+		{"ingredients", url.Values{qty: {"0"}}},
+		{"ingredients", url.Values{qty: {"-1"}}},
+		{"ingredients", url.Values{qty: {"3"}}},
+	}
+	for _, tt := range tests {
+		form := url.Values{"name": {"Frullato"}, qty: {"1"},
+			"expiration_date": {time.Now().AddDate(0, 0, 2).Format("2006-01-02")}}
+		for k, v := range tt.form {
+			form[k] = v
+		}
+		resp, err := srv.Client().PostForm(srv.URL+"/fridge/cook", form)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("HX-Retarget") == "" {
+			t.Errorf("cooking with %s %v: status %d, want the form shown again with the error",
+				tt.field, tt.form, resp.StatusCode)
+		}
+	}
+	if stored, err := c.GetLot(lot.Id.String()); err != nil || stored.Quantity != 2 {
+		t.Fatalf("ingredient after the invalid forms = %v, %v; want 2 units", stored, err)
+	}
+
+	form := url.Values{"name": {"Frullato"}, qty: {"1"},
+		"expiration_date": {time.Now().AddDate(0, 0, 2).Format("2006-01-02")}}
+	resp, err := srv.Client().PostForm(srv.URL+"/fridge/cook", form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("HX-Retarget") != "" {
+		t.Errorf("cooking with a valid form: status %d, want the modal closed", resp.StatusCode)
+	}
+	if stored, err := c.GetLot(lot.Id.String()); err != nil || stored.Quantity != 1 {
+		t.Errorf("ingredient after cooking = %v, %v; want 1 unit", stored, err)
+	}
+}
+
+// TestCookMeasuredLot cooks with part of a measured lot from the cook form, by amount.
+func TestCookMeasuredLot(t *testing.T) {
+	srv, db := newTestServerDB(t)
+	id, err := db.AddItem(models.ProductInfo{Barcode: "8001234", Name: "Farina"}, models.Item{
+		Quantity:       1,
+		Unit:           models.UnitGram,
+		PackageAmount:  1000,
+		ExpirationDate: time.Now().AddDate(0, 6, 0),
+		AdditionDate:   time.Now(),
+		Location:       models.DefaultLocation,
+	}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	form := url.Values{"name": {"Pizza"}, "amount-" + id: {"0,3"}, "unit-" + id: {models.UnitKilogram},
+		"expiration_date": {time.Now().AddDate(0, 0, 2).Format("2006-01-02")}}
+	resp, err := srv.Client().PostForm(srv.URL+"/fridge/cook", form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("HX-Retarget") != "" {
+		t.Fatalf("cooking 0,3 kg of flour: status %d, want the modal closed", resp.StatusCode)
+	}
+	if lot, err := db.GetItemById(id); err != nil || lot.Amount != 700 {
+		t.Errorf("flour after cooking = %v, %v; want 700 g", lot, err)
+	}
+}
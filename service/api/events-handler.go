@@ -0,0 +1,161 @@
+package api
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/lorenzougolini/wimf-app/service/api/reqcontext"
+	"github.com/lorenzougolini/wimf-app/service/events"
+)
+
+// inventoryChanged is the event sent on /events for every event of the bus: lots or products added, edited, removed or
+// expired. The pages refresh their lists with `hx-trigger="sse:inventory-changed"`.
+const inventoryChanged = "inventory-changed"
+
+// expiryCheckInterval is how often the lots reaching their expiration date are looked for.
+const expiryCheckInterval = time.Hour
+
+// eventsKeepAlive is how often an idle event stream gets a comment, so that connections dropped by the client or by a
+// proxy are noticed and closed.
+const eventsKeepAlive = time.Minute
+
+// eventHub fans the events out to the clients connected to /events.
+type eventHub struct {
+	mu      sync.Mutex
+	clients map[*eventClient]struct{}
+}
+
+// eventClient is a client connected to /events. Pending holds the names of the events it still has to send, guarded by
+// the mutex of the hub, and ready is signalled when some are added.
+type eventClient struct {
+	pending map[string]bool
+	ready   chan struct{}
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{clients: make(map[*eventClient]struct{})}
+}
+
+// subscribe returns a new client, to be released with unsubscribe.
+func (h *eventHub) subscribe() *eventClient {
+	c := &eventClient{pending: make(map[string]bool), ready: make(chan struct{}, 1)}
+	h.mu.Lock()
+	h.clients[c] = struct{}{}
+	h.mu.Unlock()
+	return c
+}
+
+func (h *eventHub) unsubscribe(c *eventClient) {
+	h.mu.Lock()
+	delete(h.clients, c)
+	h.mu.Unlock()
+}
+
+// publish queues `event` for every client without blocking. Events with the same name are coalesced until the client
+// sends them, as one refresh is enough to catch up, while events with different names are all kept.
+func (h *eventHub) publish(event string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.clients {
+		c.pending[event] = true
+		select {
+		case c.ready <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// take returns the names of the events pending for the client `c`, sorted, and forgets them.
+func (h *eventHub) take(c *eventClient) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	events := slices.Sorted(maps.Keys(c.pending))
+	clear(c.pending)
+	return events
+}
+
+// HandleEvent forwards the inventory changes published on the event bus to the pages connected to /events.
+func (rt *_router) HandleEvent(events.Event) {
+	rt.events.publish(inventoryChanged)
+}
+
+// watchExpirations has the database publish ItemExpired for the lots expiring while the server is running, until Close
+// is called.
+func (rt *_router) watchExpirations() {
+	defer rt.background.Done()
+
+	ticker := time.NewTicker(expiryCheckInterval)
+	defer ticker.Stop()
+	since := time.Now()
+	for {
+		select {
+		case <-rt.stop:
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		expired, err := rt.db.NotifyExpired(since, now)
+		if err != nil {
+			rt.baseLogger.WithError(err).Error("error looking for expired items")
+			continue
+		}
+		if expired > 0 {
+			rt.baseLogger.Infof("%d items expired", expired)
+		}
+		since = now
+	}
+}
+
+// getEvents streams the events of the hub to the client as Server-Sent Events, until the client goes away or Close is
+// called.
+func (rt *_router) getEvents(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	rc := http.NewResponseController(w)
+	// The stream outlives the write timeout of the server
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		ctx.Logger.WithError(err).Error("Error disabling the write deadline of the event stream")
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	client := rt.events.subscribe()
+	defer rt.events.unsubscribe(client)
+
+	w.Header().Set("content-type", "text/event-stream")
+	w.Header().Set("cache-control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if _, err := fmt.Fprint(w, ": connected\n\n"); err != nil || rc.Flush() != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case <-rt.stop:
+			return
+		case <-client.ready:
+			for _, event := range rt.events.take(client) {
+				if _, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, event); err != nil {
+					break
+				}
+			}
+		case <-keepAlive.C:
+			_, err = fmt.Fprint(w, ": keep-alive\n\n")
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/lorenzougolini/wimf-app/service/client"
+)
+
+// listenEvents connects to the /events stream of `srv` like an open page, returning its lines once subscribed. The
+// stream is closed at the end of the test, or after 5 seconds.
+func listenEvents(t *testing.T, srv *httptest.Server) *bufio.Scanner {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = stream.Body.Close() })
+	lines := bufio.NewScanner(stream.Body)
+	// subscribed once the stream starts
+	if !lines.Scan() {
+		t.Fatalf("event stream closed: %v", lines.Err())
+	}
+	return lines
+}
+
+// waitEvent reads `lines` until the event `name`, failing the test if the stream ends first.
+func waitEvent(t *testing.T, lines *bufio.Scanner, name string) {
+	t.Helper()
+	for lines.Scan() {
+		if lines.Text() == "event: "+name {
+			return
+		}
+	}
+	t.Fatalf("no %s event: %v", name, lines.Err())
+}
+
+// TestLotChangeReachesPages adds a lot through the JSON API while a page listens to /events: the page must be told to
+// refresh, with no polling.
+func TestLotChangeReachesPages(t *testing.T) {
+	srv := newTestServer(t)
+	lines := listenEvents(t, srv)
+
+	_, err := client.New(srv.URL).CreateLot(client.LotRequest{Barcode: "8005678", Name: "Yogurt",
+		ExpirationDate: time.Now().AddDate(0, 0, 10).Format("2006-01-02")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitEvent(t, lines, inventoryChanged)
+}
+
+// TestEventHubCoalesces checks that a client busy sending gets each event name once, without losing the other names.
+func TestEventHubCoalesces(t *testing.T) {
+	hub := newEventHub()
+	client := hub.subscribe()
+	defer hub.unsubscribe(client)
+
+	hub.publish(inventoryChanged)
+	hub.publish(draftChanged)
+	hub.publish(inventoryChanged)
+	hub.publish(stocktakeChanged)
+
+	select {
+	case <-client.ready:
+	default:
+		t.Fatal("client not signalled")
+	}
+	want := []string{draftChanged, inventoryChanged, stocktakeChanged}
+	if got := hub.take(client); !slices.Equal(got, want) {
+		t.Errorf("pending events = %v, want %v", got, want)
+	}
+	if got := hub.take(client); len(got) > 0 {
+		t.Errorf("pending events after sending them = %v, want none", got)
+	}
+}
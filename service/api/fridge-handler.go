@@ -1,29 +1,58 @@
 package api
 
 import (
+	"errors"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/lorenzougolini/wimf-app/service/api/reqcontext"
+	"github.com/lorenzougolini/wimf-app/service/database"
+	"github.com/lorenzougolini/wimf-app/service/forecast"
 	"github.com/lorenzougolini/wimf-app/service/models"
 	"github.com/lorenzougolini/wimf-app/service/templates"
 )
 
+// fridgePageSize is the number of products loaded at once by the fridge infinite scroll.
+const fridgePageSize = 25
+
 func (rt *_router) getFridge(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
-	items, err := rt.db.GetFridge()
+	filter := parseFridgeFilter(r)
+
+	// the grouped view needs every product to build its sections, so it's not paginated
+	limit := fridgePageSize
+	if filter.Grouped {
+		limit = 0
+	}
+	items, next, err := rt.db.GetFridge(filter, "", limit)
 	if err != nil {
 		http.Error(w, "Error retrieving the fridge", http.StatusInternalServerError)
 		return
 	}
+	if err = rt.attachForecasts(items); err != nil {
+		ctx.Logger.WithError(err).Error("Error forecasting consumption")
+	}
+
+	var categories []models.CategorySummary
+	if filter.Grouped {
+		categories, err = rt.db.GetCategorySummary(filter)
+		if err != nil {
+			http.Error(w, "Error retrieving the fridge", http.StatusInternalServerError)
+			return
+		}
+	}
 
 	isHTMX := r.Header.Get("HX-Request") == "true"
 
 	if isHTMX {
 		// Just refresh the table part (No Header, No Footer)
-		templates.FridgeTable(items).Render(r.Context(), w)
+		templates.FridgeTable(items, filter, categories, next).Render(r.Context(), w)
 	} else {
 		// Full Page Load (Includes Header, Footer, CSS)
-		templates.Fridge(items).Render(r.Context(), w)
+		templates.Fridge(items, filter, categories, next).Render(r.Context(), w)
 	}
 
 	// fridgeTemplate := templates.Fridge(items)
@@ -33,26 +62,192 @@ func (rt *_router) getFridge(w http.ResponseWriter, r *http.Request, ps httprout
 	// }
 }
 
+// getFridgeRows renders the next page of the fridge table, requested by the infinite scroll.
+func (rt *_router) getFridgeRows(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	filter := parseFridgeFilter(r)
+	items, next, err := rt.db.GetFridge(filter, r.URL.Query().Get("cursor"), fridgePageSize)
+	if errors.Is(err, database.ErrInvalidCursor) {
+		http.Error(w, "Invalid cursor", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Error retrieving the fridge page")
+		http.Error(w, "Error retrieving the fridge", http.StatusInternalServerError)
+		return
+	}
+	if err = rt.attachForecasts(items); err != nil {
+		ctx.Logger.WithError(err).Error("Error forecasting consumption")
+	}
+
+	err = templates.FridgeRows(items, filter, next).Render(r.Context(), w)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Error rendering fridge rows")
+	}
+}
+
 func (rt *_router) getFridgeDetails(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	barcode := r.URL.Query().Get("barcode")
-	_, items, err := rt.db.GetItemsByBarcode(barcode)
+	exists, items, err := rt.db.GetItemsByBarcode(barcode)
 	if err != nil {
 		http.Error(w, "Error retrieving fridge details", http.StatusInternalServerError)
 		return
 	}
+	if !exists {
+		http.Error(w, "Product not found", http.StatusNotFound)
+		return
+	}
+	rt.renderFridgeDetails(w, r, barcode, items, ctx)
+}
+
+// renderFridgeDetails renders the detail modal of the lots `items` of `barcode`.
+func (rt *_router) renderFridgeDetails(w http.ResponseWriter, r *http.Request, barcode string, items []models.Item, ctx reqcontext.RequestContext) {
+	// leftovers have a single lot, show what it was cooked with
+	var sources []models.ItemSource
+	if strings.HasPrefix(barcode, models.LeftoverPrefix) {
+		var err error
+		sources, err = rt.db.GetItemSources(items[0].Id.String())
+		if err != nil {
+			ctx.Logger.WithError(err).Error("Error retrieving leftover sources")
+		}
+	}
+
+	stats, err := rt.db.GetConsumptionStats(time.Now().Add(-forecast.Window))
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Error forecasting consumption")
+	}
+	prediction := forecast.Product(stats[barcode], items, time.Now())
+
+	product, err := rt.db.GetProduct(barcode)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Error retrieving product")
+	}
+
+	if err = templates.FridgeDetailModal(items, sources, prediction, product).Render(r.Context(), w); err != nil {
+		ctx.Logger.WithError(err).Error("Error rendering the fridge details")
+	}
+}
+
+// searchFridge renders the live search results for the search box of the fridge page.
+func (rt *_router) searchFridge(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	hits, err := rt.db.Search(query, 20)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Error searching the fridge")
+		http.Error(w, "Error searching the fridge", http.StatusInternalServerError)
+		return
+	}
+
+	err = templates.SearchResults(hits, query).Render(r.Context(), w)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Error rendering search results")
+	}
+}
+
+// updateProduct overrides the category and the tags of a product.
+func (rt *_router) updateProduct(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	product := models.Product{
+		Barcode:  strings.TrimSpace(r.FormValue("barcode")),
+		Category: r.FormValue("category"),
+		Tags:     parseTags(r.FormValue("tags")),
+	}
+	if product.Barcode == "" {
+		http.Error(w, "Missing barcode", http.StatusBadRequest)
+		return
+	}
+	if _, ok := models.CategoryLabels[product.Category]; !ok {
+		http.Error(w, "Unknown category", http.StatusBadRequest)
+		return
+	}
+
+	if err = rt.audited(r).UpdateProduct(product); err != nil {
+		ctx.Logger.WithError(err).Error("Error updating product")
+		http.Error(w, "Error updating product", http.StatusInternalServerError)
+		return
+	}
 
-	templates.FridgeDetailModal(items).Render(r.Context(), w)
+	w.WriteHeader(http.StatusOK)
 }
 
 func (rt *_router) getEditForm(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
 	id := r.URL.Query().Get("id")
 
-	item, _ := rt.db.GetItemById(id)
+	// deleted in the meantime, e.g. from another tab
+	item, err := rt.db.GetItemById(id)
+	if errors.Is(err, database.ErrItemNotFound) {
+		http.Error(w, "Item not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Error retrieving the item to edit")
+		http.Error(w, "Error retrieving the item", http.StatusInternalServerError)
+		return
+	}
 
 	info := models.ProductInfo{
 		Barcode: item.Barcode,
 		Name:    item.Name,
 		Brand:   item.Brand,
 	}
-	templates.ExpirationModal(info, true, id, item.ExpirationDate).Render(r.Context(), w)
+	err = templates.ExpirationModal(info, true, item, models.StockSummary{}, nil).Render(r.Context(), w)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Error rendering edit modal")
+		http.Error(w, "Render error", http.StatusInternalServerError)
+	}
+}
+
+// attachForecasts fills the consumption forecast of each product in `products`.
+func (rt *_router) attachForecasts(products []models.Item) error {
+	now := time.Now()
+	stats, err := rt.db.GetConsumptionStats(now.Add(-forecast.Window))
+	if err != nil {
+		return err
+	}
+	lots, err := rt.db.GetAllItems()
+	if err != nil {
+		return err
+	}
+
+	forecasts := forecast.All(stats, lots, now)
+	for i := range products {
+		products[i].Forecast = forecasts[products[i].Barcode]
+	}
+	return nil
+}
+
+// parseFridgeFilter reads the fridge view filter from the URL query.
+func parseFridgeFilter(r *http.Request) models.FridgeFilter {
+	query := r.URL.Query()
+	filter := models.FridgeFilter{
+		Category: query.Get("category"),
+		Grouped:  query.Get("group") == "category",
+		Query:    strings.TrimSpace(query.Get("q")),
+		Sort:     query.Get("sort"),
+		Location: query.Get("location"),
+		Expired:  query.Get("expired") == "true",
+	}
+	for _, tag := range query["tag"] {
+		filter.Tags = append(filter.Tags, parseTags(tag)...)
+	}
+	if !slices.Contains([]string{models.SortName, models.SortAdded, models.SortQuantity}, filter.Sort) {
+		filter.Sort = models.SortExpiry
+	}
+	if within, err := strconv.Atoi(query.Get("within")); err == nil && within > 0 {
+		filter.Within = within
+	}
+	return filter
+}
+
+// parseTags splits a comma separated list of tags, normalizing them to trimmed lowercase words without duplicates.
+func parseTags(s string) []string {
+	var tags []string
+	for _, tag := range strings.Split(s, ",") {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" && !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
 }
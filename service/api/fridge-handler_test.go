@@ -0,0 +1,76 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/lorenzougolini/wimf-app/service/client"
+)
+
+// TestSearchEscapesBarcode searches a product whose barcode isn't safe in a URL: the link to its details must keep it
+// whole.
+func TestSearchEscapesBarcode(t *testing.T) {
+	srv := newTestServer(t)
+	_, err := client.New(srv.URL).CreateLot(client.LotRequest{
+		Barcode:        "12&sort=x",
+		Name:           "Caffè",
+		ExpirationDate: time.Now().AddDate(0, 6, 0).Format("2006-01-02"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := srv.Client().Get(srv.URL + "/fridge/search?q=caffe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(page), "/fridge/details?barcode=12%26sort%3Dx") {
+		t.Errorf("no escaped link to the details in the results:\n%s", page)
+	}
+}
+
+// TestEditFormOfMissingLot opens the edit form of a lot, of one moved to the trash and of one that never existed: only
+// the first one has a form.
+func TestEditFormOfMissingLot(t *testing.T) {
+	srv := newTestServer(t)
+	c := client.New(srv.URL)
+	lots := make([]string, 2)
+	for i := range lots {
+		lot, err := c.CreateLot(client.LotRequest{Barcode: "8005678", Name: "Yogurt", Separate: true,
+			ExpirationDate: time.Now().AddDate(0, 0, 10).Format("2006-01-02")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		lots[i] = lot.Id.String()
+	}
+	if err := c.DeleteLot(lots[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		lot    string
+		status int
+	}{
+		{lots[0], http.StatusOK},
+		{lots[1], http.StatusNotFound},
+		{uuid.Must(uuid.NewV7()).String(), http.StatusNotFound},
+	} {
+		resp, err := srv.Client().Get(srv.URL + "/fridge/item/edit?id=" + tt.lot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("edit form of %s: status %d, want %d", tt.lot, resp.StatusCode, tt.status)
+		}
+	}
+}
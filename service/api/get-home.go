@@ -2,14 +2,26 @@ package api
 
 import (
 	"net/http"
+	"net/url"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/lorenzougolini/wimf-app/service/templates"
 )
 
-// getHelloWorld is an example of HTTP endpoint that returns "Hello world!" as a plain text
+// getHome renders the scanner page. With `?pair=` the scans go to the desktop of that scan session, joined from
+// /pair/join.
 func (rt *_router) getHome(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	homeTemplate := templates.Home()
+	var pairing string
+	if code := r.URL.Query().Get("pair"); code != "" {
+		session, err := rt.pairings.get(code, time.Now())
+		if err != nil {
+			http.Redirect(w, r, "/pair/join?"+url.Values{"code": {code}}.Encode(), http.StatusSeeOther)
+			return
+		}
+		pairing = session.code
+	}
+	homeTemplate := templates.Home(pairing)
 	err := templates.Layout(homeTemplate, "Home", "/").Render(r.Context(), w)
 	if err != nil {
 		// log.Printf("error rendering the home: %v", err)
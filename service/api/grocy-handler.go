@@ -0,0 +1,471 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/lorenzougolini/wimf-app/service/api/reqcontext"
+	"github.com/lorenzougolini/wimf-app/service/database"
+	"github.com/lorenzougolini/wimf-app/service/models"
+)
+
+// The Grocy-compatible API mimics the subset of the Grocy stock API (https://demo.grocy.info/api) used by barcode
+// scanner apps and scripts: the stock overview, the product details by id or barcode, adding and consuming by barcode,
+// and the external barcode lookup, backed by Open Food Facts. It's only enabled when an API key is configured.
+//
+// Grocy products map to the products of the app, with a single barcode each. Quantities are counted in pieces, the only
+// quantity unit, and Grocy locations map to models.Locations (location_id is the position in the list, from 1).
+
+// grocyAPIKeyHeader carries the API key, as in Grocy. Like Grocy, the key is also accepted as a query parameter.
+const grocyAPIKeyHeader = "GROCY-API-KEY"
+
+// grocyVersion is the Grocy release whose API is mimicked, reported to the clients checking it.
+const grocyVersion = "4.0.0"
+
+// grocyUnit is the only quantity unit.
+var grocyUnit = grocyQuantityUnit{Id: 1, Name: "Pezzo", NamePlural: "Pezzi"}
+
+type grocyError struct {
+	ErrorMessage string `json:"error_message"`
+}
+
+type grocySystemInfo struct {
+	GrocyVersion struct {
+		Version     string `json:"Version"`
+		ReleaseDate string `json:"ReleaseDate"`
+	} `json:"grocy_version"`
+}
+
+type grocyProduct struct {
+	Id                    int64   `json:"id"`
+	Name                  string  `json:"name"`
+	Description           string  `json:"description"`
+	LocationId            int     `json:"location_id"`
+	QuIdPurchase          int     `json:"qu_id_purchase"`
+	QuIdStock             int     `json:"qu_id_stock"`
+	MinStockAmount        float64 `json:"min_stock_amount"`
+	DefaultBestBeforeDays int     `json:"default_best_before_days"`
+	Active                int     `json:"active"`
+}
+
+type grocyLocation struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type grocyQuantityUnit struct {
+	Id         int    `json:"id"`
+	Name       string `json:"name"`
+	NamePlural string `json:"name_plural"`
+}
+
+type grocyBarcode struct {
+	Id        int64  `json:"id"`
+	ProductId int64  `json:"product_id"`
+	Barcode   string `json:"barcode"`
+}
+
+type grocyStockEntry struct {
+	ProductId              int64        `json:"product_id"`
+	Amount                 float64      `json:"amount"`
+	AmountAggregated       float64      `json:"amount_aggregated"`
+	AmountOpened           float64      `json:"amount_opened"`
+	AmountOpenedAggregated float64      `json:"amount_opened_aggregated"`
+	BestBeforeDate         string       `json:"best_before_date"`
+	IsAggregatedAmount     int          `json:"is_aggregated_amount"`
+	Product                grocyProduct `json:"product"`
+}
+
+type grocyProductDetails struct {
+	Product                     grocyProduct      `json:"product"`
+	ProductBarcodes             []grocyBarcode    `json:"product_barcodes"`
+	StockAmount                 float64           `json:"stock_amount"`
+	StockAmountOpened           float64           `json:"stock_amount_opened"`
+	NextDueDate                 *string           `json:"next_due_date"`
+	LastPurchased               *string           `json:"last_purchased"`
+	Location                    grocyLocation     `json:"location"`
+	QuantityUnitStock           grocyQuantityUnit `json:"quantity_unit_stock"`
+	DefaultQuantityUnitPurchase grocyQuantityUnit `json:"default_quantity_unit_purchase"`
+}
+
+// grocyStockRequest is the body of the add and consume requests. The fields of Grocy not listed are ignored.
+type grocyStockRequest struct {
+	Amount         float64 `json:"amount"`
+	BestBeforeDate string  `json:"best_before_date"`
+	LocationId     int     `json:"location_id"`
+}
+
+// grocyStockLog is a row of the Grocy stock journal, returned by the add and consume requests.
+type grocyStockLog struct {
+	ProductId           int64   `json:"product_id"`
+	Amount              float64 `json:"amount"`
+	BestBeforeDate      string  `json:"best_before_date"`
+	PurchasedDate       string  `json:"purchased_date"`
+	StockId             string  `json:"stock_id"`
+	TransactionType     string  `json:"transaction_type"`
+	TransactionId       string  `json:"transaction_id"`
+	LocationId          int     `json:"location_id"`
+	Spoiled             int     `json:"spoiled"`
+	Undone              int     `json:"undone"`
+	RowCreatedTimestamp string  `json:"row_created_timestamp"`
+}
+
+type grocyExternalLookup struct {
+	Name                    string  `json:"name"`
+	LocationId              int     `json:"location_id"`
+	QuIdPurchase            int     `json:"qu_id_purchase"`
+	QuIdStock               int     `json:"qu_id_stock"`
+	QuFactorPurchaseToStock float64 `json:"__qu_factor_purchase_to_stock"`
+	Barcode                 string  `json:"__barcode"`
+}
+
+// grocy wraps the handlers of the Grocy-compatible API, checking the API key.
+func (rt *_router) grocy(fn httpRouterHandler) httprouter.Handle {
+	return rt.wrap(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+		key := r.Header.Get(grocyAPIKeyHeader)
+		if key == "" {
+			key = r.URL.Query().Get(grocyAPIKeyHeader)
+		}
+		if subtle.ConstantTimeCompare([]byte(key), []byte(rt.grocyAPIKey)) != 1 {
+			grocyFail(w, http.StatusUnauthorized, "Invalid API key")
+			return
+		}
+		fn(w, r, ps, ctx)
+	})
+}
+
+func (rt *_router) grocySystemInfo(w http.ResponseWriter, _ *http.Request, _ httprouter.Params, _ reqcontext.RequestContext) {
+	var info grocySystemInfo
+	info.GrocyVersion.Version = grocyVersion
+	grocyReply(w, info)
+}
+
+// grocyStock lists the products in stock, with their total amount and nearest expiration.
+func (rt *_router) grocyStock(w http.ResponseWriter, _ *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	lots, err := rt.db.GetAllItems()
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Error retrieving the stock")
+		grocyFail(w, http.StatusInternalServerError, "Error retrieving the stock")
+		return
+	}
+
+	stock := []grocyStockEntry{}
+	index := make(map[string]int)
+	for _, lot := range lots {
+		if i, ok := index[lot.Barcode]; ok {
+			stock[i].Amount += float64(lot.Quantity)
+			stock[i].AmountAggregated = stock[i].Amount
+			if date := lot.ExpirationDate.Format("2006-01-02"); date < stock[i].BestBeforeDate {
+				stock[i].BestBeforeDate = date
+			}
+			continue
+		}
+
+		product, err := rt.db.GetProduct(lot.Barcode)
+		if err != nil {
+			ctx.Logger.WithError(err).Error("Error retrieving a product of the stock")
+			grocyFail(w, http.StatusInternalServerError, "Error retrieving the stock")
+			return
+		}
+		index[lot.Barcode] = len(stock)
+		stock = append(stock, grocyStockEntry{
+			ProductId:        product.Id,
+			Amount:           float64(lot.Quantity),
+			AmountAggregated: float64(lot.Quantity),
+			BestBeforeDate:   lot.ExpirationDate.Format("2006-01-02"),
+			Product:          newGrocyProduct(product.Id, lot),
+		})
+	}
+	grocyReply(w, stock)
+}
+
+// grocyProducts serves the routes under /api/stock/products/, which httprouter can't register one by one: a product is
+// addressed by id (/{id}) or by barcode (/by-barcode/{barcode}), optionally followed by /add or /consume.
+func (rt *_router) grocyProducts(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	parts := strings.Split(strings.Trim(ps.ByName("path"), "/"), "/")
+
+	var barcode string
+	if parts[0] == "by-barcode" && len(parts) > 1 {
+		barcode = parts[1]
+		parts = parts[2:]
+	} else {
+		id, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			grocyFail(w, http.StatusNotFound, "Not found")
+			return
+		}
+		product, err := rt.db.GetProductById(id)
+		if errors.Is(err, database.ErrProductNotFound) {
+			grocyFail(w, http.StatusBadRequest, fmt.Sprintf("Product does not exist: %d", id))
+			return
+		} else if err != nil {
+			ctx.Logger.WithError(err).Error("Error retrieving the product")
+			grocyFail(w, http.StatusInternalServerError, "Error retrieving the product")
+			return
+		}
+		barcode = product.Barcode
+		parts = parts[1:]
+	}
+
+	switch {
+	case len(parts) == 0 && r.Method == http.MethodGet:
+		rt.grocyProductDetails(w, barcode, ctx)
+	case len(parts) == 1 && parts[0] == "add" && r.Method == http.MethodPost:
+		rt.grocyAdd(w, r, barcode, ctx)
+	case len(parts) == 1 && parts[0] == "consume" && r.Method == http.MethodPost:
+		rt.grocyConsume(w, r, barcode, ctx)
+	default:
+		grocyFail(w, http.StatusNotFound, "Not found")
+	}
+}
+
+func (rt *_router) grocyProductDetails(w http.ResponseWriter, barcode string, ctx reqcontext.RequestContext) {
+	product, err := rt.db.GetProduct(barcode)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Error retrieving the product")
+		grocyFail(w, http.StatusInternalServerError, "Error retrieving the product")
+		return
+	}
+	if product.Id == 0 {
+		grocyFail(w, http.StatusBadRequest, fmt.Sprintf("No product with barcode %s found", barcode))
+		return
+	}
+	_, lots, err := rt.db.GetItemsByBarcode(barcode)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Error retrieving the lots of the product")
+		grocyFail(w, http.StatusInternalServerError, "Error retrieving the product")
+		return
+	}
+
+	var lot models.Item
+	if len(lots) > 0 {
+		lot = lots[0]
+	} else {
+		// the product is out of stock, so its name is only known by Open Food Facts
+		lot = models.Item{Barcode: barcode, Name: barcode, Location: models.DefaultLocation}
+		if info, err := rt.foodApi.GetProductByBarcode(barcode); err == nil {
+			lot.Name, lot.Brand = info.Name, info.Brand
+		}
+	}
+
+	details := grocyProductDetails{
+		Product:                     newGrocyProduct(product.Id, lot),
+		ProductBarcodes:             []grocyBarcode{{Id: product.Id, ProductId: product.Id, Barcode: barcode}},
+		Location:                    grocyLocation{Id: grocyLocationId(lot.Location), Name: lot.Location},
+		QuantityUnitStock:           grocyUnit,
+		DefaultQuantityUnitPurchase: grocyUnit,
+	}
+	for i, l := range lots {
+		details.StockAmount += float64(l.Quantity)
+		if i == 0 {
+			// lots are sorted by expiration
+			due := l.ExpirationDate.Format("2006-01-02")
+			details.NextDueDate = &due
+		}
+		if purchased := l.AdditionDate.Format("2006-01-02"); details.LastPurchased == nil || purchased > *details.LastPurchased {
+			details.LastPurchased = &purchased
+		}
+	}
+	grocyReply(w, details)
+}
+
+// grocyAdd stores a new lot of a product. Unknown barcodes are looked up on Open Food Facts, like the scanner does.
+func (rt *_router) grocyAdd(w http.ResponseWriter, r *http.Request, barcode string, ctx reqcontext.RequestContext) {
+	req, amount, ok := decodeGrocyStockRequest(w, r)
+	if !ok {
+		return
+	}
+	if len(barcode) < 3 {
+		grocyFail(w, http.StatusBadRequest, fmt.Sprintf("Invalid barcode: %s", barcode))
+		return
+	}
+
+	now := time.Now()
+	// like the add form, lots without an expiration date expire in two weeks
+	expiration := now.AddDate(0, 0, 14)
+	if req.BestBeforeDate != "" {
+		var err error
+		if expiration, err = time.Parse("2006-01-02", req.BestBeforeDate); err != nil {
+			grocyFail(w, http.StatusBadRequest, fmt.Sprintf("Invalid best_before_date: %s", req.BestBeforeDate))
+			return
+		}
+	}
+	location := models.DefaultLocation
+	if req.LocationId != 0 {
+		if req.LocationId < 1 || req.LocationId > len(models.Locations) {
+			grocyFail(w, http.StatusBadRequest, fmt.Sprintf("Location does not exist: %d", req.LocationId))
+			return
+		}
+		location = models.Locations[req.LocationId-1]
+	}
+
+	exists, lots, err := rt.db.GetItemsByBarcode(barcode)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to check existing items")
+		grocyFail(w, http.StatusInternalServerError, "Error adding the product")
+		return
+	}
+	var info models.ProductInfo
+	if exists {
+		info = models.ProductInfo{Barcode: barcode, Name: lots[0].Name, Brand: lots[0].Brand}
+	} else if info, err = rt.foodApi.GetProductByBarcode(barcode); err != nil {
+		ctx.Logger.WithError(err).Error("Failed to fetch product info")
+		grocyFail(w, http.StatusInternalServerError, "Error looking up the product")
+		return
+	}
+
+	lot := models.Item{
+		Quantity:       amount,
+		ExpirationDate: expiration,
+		AdditionDate:   now,
+		Location:       location,
+	}
+	id, err := rt.audited(r).AddItem(info, lot, false)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Error adding item from the Grocy API")
+		grocyFail(w, http.StatusInternalServerError, "Error adding the product")
+		return
+	}
+	product, err := rt.db.GetProduct(barcode)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Error retrieving the product")
+		grocyFail(w, http.StatusInternalServerError, "Error retrieving the product")
+		return
+	}
+	ctx.Logger.Infof("added %d x %s from the Grocy API", amount, barcode)
+
+	lot.Id = uuid.FromStringOrNil(id)
+	lot.Barcode = barcode
+	grocyReply(w, []grocyStockLog{newGrocyStockLog(product.Id, "purchase", lot, now)})
+}
+
+// grocyConsume consumes units of a product first-expired, first-out.
+func (rt *_router) grocyConsume(w http.ResponseWriter, r *http.Request, barcode string, ctx reqcontext.RequestContext) {
+	_, amount, ok := decodeGrocyStockRequest(w, r)
+	if !ok {
+		return
+	}
+	product, err := rt.db.GetProduct(barcode)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Error retrieving the product")
+		grocyFail(w, http.StatusInternalServerError, "Error retrieving the product")
+		return
+	}
+	if product.Id == 0 {
+		grocyFail(w, http.StatusBadRequest, fmt.Sprintf("No product with barcode %s found", barcode))
+		return
+	}
+
+	consumed, err := rt.audited(r).ConsumeByBarcode(barcode, amount)
+	if errors.Is(err, database.ErrNotEnoughStock) {
+		grocyFail(w, http.StatusBadRequest, "Amount to be consumed cannot be > current stock amount")
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Error consuming item from the Grocy API")
+		grocyFail(w, http.StatusInternalServerError, "Error consuming the product")
+		return
+	}
+	ctx.Logger.Infof("consumed %d x %s from the Grocy API", amount, barcode)
+
+	now := time.Now()
+	logs := make([]grocyStockLog, 0, len(consumed))
+	for _, lot := range consumed {
+		entry := newGrocyStockLog(product.Id, "consume", lot, now)
+		entry.Amount = -entry.Amount
+		logs = append(logs, entry)
+	}
+	grocyReply(w, logs)
+}
+
+// grocyExternalLookup looks a barcode up on Open Food Facts.
+func (rt *_router) grocyExternalLookup(w http.ResponseWriter, _ *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	barcode := ps.ByName("barcode")
+	info, err := rt.foodApi.GetProductByBarcode(barcode)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to fetch product info")
+		grocyFail(w, http.StatusBadRequest, "Error while executing the barcode lookup plugin")
+		return
+	}
+	grocyReply(w, grocyExternalLookup{
+		Name:                    info.Name,
+		LocationId:              grocyLocationId(models.DefaultLocation),
+		QuIdPurchase:            grocyUnit.Id,
+		QuIdStock:               grocyUnit.Id,
+		QuFactorPurchaseToStock: 1,
+		Barcode:                 barcode,
+	})
+}
+
+// decodeGrocyStockRequest reads the body of an add or consume request, replying with an error if it's not valid. Only
+// whole amounts are accepted, as lots are counted in pieces, up to the largest quantity of a lot.
+func decodeGrocyStockRequest(w http.ResponseWriter, r *http.Request) (grocyStockRequest, int, bool) {
+	var req grocyStockRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		grocyFail(w, http.StatusBadRequest, "Invalid request body")
+		return req, 0, false
+	}
+	if req.Amount < 1 || req.Amount > maxLotQuantity || req.Amount != math.Trunc(req.Amount) {
+		grocyFail(w, http.StatusBadRequest, "The amount must be a whole number between 1 and "+
+			strconv.Itoa(maxLotQuantity))
+		return req, 0, false
+	}
+	return req, int(req.Amount), true
+}
+
+func newGrocyProduct(id int64, lot models.Item) grocyProduct {
+	return grocyProduct{
+		Id:           id,
+		Name:         lot.Name,
+		Description:  lot.Brand,
+		LocationId:   grocyLocationId(lot.Location),
+		QuIdPurchase: grocyUnit.Id,
+		QuIdStock:    grocyUnit.Id,
+		Active:       1,
+	}
+}
+
+func newGrocyStockLog(productId int64, transactionType string, lot models.Item, now time.Time) grocyStockLog {
+	entry := grocyStockLog{
+		ProductId:           productId,
+		Amount:              float64(lot.Quantity),
+		BestBeforeDate:      lot.ExpirationDate.Format("2006-01-02"),
+		PurchasedDate:       lot.AdditionDate.Format("2006-01-02"),
+		TransactionType:     transactionType,
+		LocationId:          grocyLocationId(lot.Location),
+		RowCreatedTimestamp: now.Format(models.DbTimeLayout),
+	}
+	if lot.Id != uuid.Nil {
+		entry.StockId = lot.Id.String()
+	}
+	if id, err := uuid.NewV4(); err == nil {
+		entry.TransactionId = id.String()
+	}
+	return entry
+}
+
+// grocyLocationId returns the Grocy id of a location, 0 if it's unknown.
+func grocyLocationId(location string) int {
+	return slices.Index(models.Locations, location) + 1
+}
+
+func grocyReply(w http.ResponseWriter, reply any) {
+	w.Header().Set("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(reply)
+}
+
+// grocyFail replies with an error object shaped like Grocy's.
+func grocyFail(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(grocyError{ErrorMessage: message})
+}
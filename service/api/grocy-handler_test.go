@@ -0,0 +1,57 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lorenzougolini/wimf-app/service/client"
+)
+
+// TestGrocyInvalidAmount adds amounts that aren't a whole number of pieces, or more than a lot can hold, through the
+// Grocy-compatible API: they must be refused with a Grocy error, leaving the stock as it is.
+func TestGrocyInvalidAmount(t *testing.T) {
+	srv := newTestServer(t)
+	c := client.New(srv.URL)
+	// a product already stored doesn't need Open Food Facts
+	_, err := c.CreateLot(client.LotRequest{
+		Barcode:        "8005678",
+		Name:           "Yogurt",
+		ExpirationDate: time.Now().AddDate(0, 0, 10).Format("2006-01-02"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	add := func(body string) (int, string) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, srv.URL+"/api/stock/products/by-barcode/8005678/add",
+			strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(grocyAPIKeyHeader, testGrocyAPIKey)
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var reply grocyError
+		_ = json.NewDecoder(resp.Body).Decode(&reply)
+		return resp.StatusCode, reply.ErrorMessage
+	}
+
+	for _, amount := range []string{"0", "-1", "1.5", "1000", "1e9"} {
+		if status, msg := add(`{"amount": ` + amount + `}`); status != http.StatusBadRequest || msg == "" {
+			t.Errorf("amount %s: status %d, error %q; want 400 with a message", amount, status, msg)
+		}
+	}
+	if status, msg := add(`{"amount": 2}`); status != http.StatusOK {
+		t.Errorf("amount 2: status %d, error %q; want 200", status, msg)
+	}
+	if product, err := c.GetProduct("8005678"); err != nil || product.Quantity != 3 {
+		t.Errorf("product = %+v, %v; want 3 pieces", product, err)
+	}
+}
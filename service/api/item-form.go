@@ -0,0 +1,240 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lorenzougolini/wimf-app/service/models"
+)
+
+// Bounds of the item forms. Dates outside them are typos (e.g. a wrong year) more often than real dates.
+const (
+	maxExpiredAge    = 1  // years an expiration date can be in the past
+	maxShelfLife     = 10 // years an expiration date can be in the future
+	maxPurchaseAge   = 1  // years an addition date can be in the past
+	maxLotQuantity   = models.MaxLotQuantity
+	maxPackageAmount = 10000
+	maxNoteLength    = 500
+	maxNameLength    = 100
+	minBarcodeLength = 3
+)
+
+// itemForm is the content of the modal adding a lot (scanned or inserted manually) or editing one. A new lot is added
+// to an identical one in stock, unless `separate` is ticked.
+type itemForm struct {
+	product  models.ProductInfo
+	lot      models.Item
+	manual   bool
+	separate bool
+}
+
+// parseItemForm reads and validates the item form of `r`, editing `stored` or adding a new lot when it's nil. The form
+// is returned even when invalid, to show it again with the errors next to the fields.
+func parseItemForm(r *http.Request, stored *models.Item) (itemForm, models.FormErrors) {
+	errs := models.FormErrors{}
+	if err := r.ParseForm(); err != nil {
+		errs[""] = "Richiesta non valida, riprova."
+		return itemForm{}, errs
+	}
+
+	form := itemForm{
+		product: models.ProductInfo{
+			Barcode: strings.TrimSpace(r.FormValue("barcode")),
+			Name:    strings.TrimSpace(r.FormValue("name")),
+			Brand:   strings.TrimSpace(r.FormValue("brand")),
+		},
+		lot: models.Item{
+			Location: strings.TrimSpace(r.FormValue("location")),
+			Note:     strings.TrimSpace(r.FormValue("note")),
+			Label:    validLabel(r.FormValue("label")),
+			Quantity: 1,
+		},
+		manual:   r.FormValue("isManual") == "true",
+		separate: r.FormValue("separate") == "true",
+	}
+	now := time.Now()
+
+	editing := stored != nil
+	if editing {
+		form.lot.Id = stored.Id
+	}
+	if (form.manual || editing) && form.product.Name == "" {
+		errs["name"] = "Inserisci il nome del prodotto."
+	}
+
+	expiration, msg := parseFormDate(r.FormValue("expiration_date"), "la data di scadenza")
+	if msg != "" {
+		errs["expiration_date"] = msg
+	}
+	form.lot.ExpirationDate = expiration
+
+	form.lot.AdditionDate = now
+	if form.manual {
+		addition, msg := parseFormDate(r.FormValue("addition_date"), "la data di acquisto")
+		if msg != "" {
+			errs["addition_date"] = msg
+		}
+		form.lot.AdditionDate = addition
+	}
+
+	if q := strings.TrimSpace(r.FormValue("quantity")); q != "" {
+		quantity, err := strconv.Atoi(q)
+		if err != nil {
+			errs["quantity"] = "La quantità deve essere tra 1 e " + strconv.Itoa(maxLotQuantity) + "."
+		} else {
+			form.lot.Quantity = quantity
+		}
+	}
+	if unit := r.FormValue("unit"); !models.ValidUnit(unit) {
+		errs["package_amount"] = "Scegli una delle unità."
+	} else if unit != "" {
+		form.lot.Unit = unit
+		amount, err := models.ParseAmount(r.FormValue("package_amount"))
+		if err != nil {
+			errs["package_amount"] = "Inserisci il contenuto di una confezione, ad esempio 500."
+		} else {
+			form.lot.PackageAmount = amount
+		}
+	}
+	if form.lot.Location == "" {
+		form.lot.Location = models.DefaultLocation
+	} else if !slices.Contains(models.Locations, form.lot.Location) {
+		errs["location"] = "Scegli una delle posizioni."
+		form.lot.Location = models.DefaultLocation
+	}
+
+	form.lot.Barcode = form.product.Barcode
+	form.lot.Name = form.product.Name
+	form.lot.Brand = form.product.Brand
+	for _, b := range checkLotBounds(form.lot, stored, now) {
+		if !errs.Has(b.field) {
+			errs[b.field] = b.message
+		}
+	}
+	return form, errs
+}
+
+// parseSplitForm reads and validates the form moving part of `lot` to a new lot. The part keeps the label of `lot`.
+func parseSplitForm(r *http.Request, lot models.Item) (models.Item, models.FormErrors) {
+	errs := models.FormErrors{}
+	if err := r.ParseForm(); err != nil {
+		errs[""] = "Richiesta non valida, riprova."
+		return models.Item{}, errs
+	}
+	part := models.Item{
+		Location: r.PostFormValue("location"),
+		Note:     strings.TrimSpace(r.PostFormValue("note")),
+		Label:    lot.Label,
+	}
+
+	quantity, err := strconv.Atoi(strings.TrimSpace(r.PostFormValue("quantity")))
+	if err != nil || quantity < 1 || quantity >= lot.Quantity {
+		errs["quantity"] = "Puoi spostare da 1 a " + strconv.Itoa(lot.Quantity-1) + " unità."
+	}
+	part.Quantity = quantity
+	expiration, msg := parseFormDate(r.PostFormValue("expiration_date"), "la data di scadenza")
+	if msg != "" {
+		errs["expiration_date"] = msg
+	}
+	part.ExpirationDate = expiration
+	if !slices.Contains(models.Locations, part.Location) {
+		errs["location"] = "Scegli una delle posizioni."
+		part.Location = lot.Location
+	}
+	for _, b := range checkLotBounds(part, &lot, time.Now()) {
+		if !errs.Has(b.field) {
+			errs[b.field] = b.message
+		}
+	}
+	return part, errs
+}
+
+// lotBound is a bound broken by a lot, with the message shown next to `field` in the modal and the one replied by the
+// JSON API, where the field is `apiField`.
+type lotBound struct {
+	field    string
+	apiField string
+	message  string
+	api      string
+}
+
+// checkLotBounds returns the bounds broken by `lot`, the same for the item forms and the JSON API. `stored` is the lot
+// being edited, nil for a new one: a lot stored for long keeps its dates, which are only checked when they change.
+// Zero dates are left to the callers, which report them as missing or invalid.
+func checkLotBounds(lot models.Item, stored *models.Item, now time.Time) []lotBound {
+	var bounds []lotBound
+	today := now.Truncate(24 * time.Hour)
+	var storedExpiration, storedAddition string
+	if stored != nil {
+		storedExpiration = stored.ExpirationDate.Format("2006-01-02")
+		storedAddition = stored.AdditionDate.Format("2006-01-02")
+	}
+
+	if stored == nil && len(lot.Barcode) < minBarcodeLength {
+		bounds = append(bounds, lotBound{"barcode", "barcode",
+			"Il codice deve avere almeno " + strconv.Itoa(minBarcodeLength) + " caratteri.",
+			"must be at least " + strconv.Itoa(minBarcodeLength) + " characters"})
+	}
+	if lot.Quantity < 1 || lot.Quantity > maxLotQuantity {
+		bounds = append(bounds, lotBound{"quantity", "quantity",
+			"La quantità deve essere tra 1 e " + strconv.Itoa(maxLotQuantity) + ".",
+			"must be between 1 and " + strconv.Itoa(maxLotQuantity)})
+	}
+	if lot.Unit != "" && (lot.PackageAmount <= 0 || lot.PackageAmount > maxPackageAmount) {
+		bounds = append(bounds, lotBound{"package_amount", "package_amount",
+			"Inserisci il contenuto di una confezione, ad esempio 500.",
+			"must be positive and at most " + strconv.Itoa(maxPackageAmount)})
+	}
+	if !lot.ExpirationDate.IsZero() && lot.ExpirationDate.Format("2006-01-02") != storedExpiration {
+		if msg := checkExpiration(lot.ExpirationDate, today); msg != "" {
+			bounds = append(bounds, lotBound{"expiration_date", "expiration_date", msg,
+				"must be within " + strconv.Itoa(maxExpiredAge) + " year in the past and " +
+					strconv.Itoa(maxShelfLife) + " years in the future"})
+		}
+	}
+	if !lot.AdditionDate.IsZero() {
+		if lot.AdditionDate.After(now) {
+			bounds = append(bounds, lotBound{"addition_date", "added_at",
+				"La data di acquisto non può essere nel futuro.", "can't be in the future"})
+		} else if lot.AdditionDate.Format("2006-01-02") != storedAddition &&
+			lot.AdditionDate.Before(today.AddDate(-maxPurchaseAge, 0, 0)) {
+			bounds = append(bounds, lotBound{"addition_date", "added_at",
+				"La data di acquisto è di più di un anno fa, controlla l'anno.",
+				"must be within " + strconv.Itoa(maxPurchaseAge) + " year in the past"})
+		}
+	}
+	if utf8.RuneCountInString(lot.Note) > maxNoteLength {
+		bounds = append(bounds, lotBound{"note", "note",
+			"La nota può avere al massimo " + strconv.Itoa(maxNoteLength) + " caratteri.",
+			"must be at most " + strconv.Itoa(maxNoteLength) + " characters"})
+	}
+	return bounds
+}
+
+// checkExpiration returns the message to show when `expiration` is too far from `today` to be plausible, empty when
+// it's fine.
+func checkExpiration(expiration time.Time, today time.Time) string {
+	if expiration.Before(today.AddDate(-maxExpiredAge, 0, 0)) {
+		return "La data di scadenza è passata da più di un anno, controlla l'anno."
+	} else if expiration.After(today.AddDate(maxShelfLife, 0, 0)) {
+		return "La data di scadenza è oltre " + strconv.Itoa(maxShelfLife) + " anni, controlla l'anno."
+	}
+	return ""
+}
+
+// parseFormDate reads a YYYY-MM-DD date input. On error it returns the message to show, mentioning `what`.
+func parseFormDate(s string, what string) (time.Time, string) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, "Inserisci " + what + "."
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, "Inserisci " + what + " nel formato gg/mm/aaaa."
+	}
+	return t, ""
+}
@@ -1,68 +1,44 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
-	"time"
 
+	"github.com/gofrs/uuid"
 	"github.com/julienschmidt/httprouter"
 	"github.com/lorenzougolini/wimf-app/service/api/reqcontext"
+	"github.com/lorenzougolini/wimf-app/service/database"
+	"github.com/lorenzougolini/wimf-app/service/forecast"
 	"github.com/lorenzougolini/wimf-app/service/models"
 	"github.com/lorenzougolini/wimf-app/service/templates"
 )
 
 func (rt *_router) addItem(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
-	w.Header().Set("content-type", "application/json")
-
-	err := r.ParseForm()
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		_ = json.NewEncoder(w).Encode("Invalid request body")
+	form, errs := parseItemForm(r, nil)
+	if len(errs) > 0 {
+		rt.renderItemFormErrors(w, r, form, errs, ctx)
 		return
 	}
-	barcode := strings.TrimSpace(r.FormValue("barcode"))
-	name := strings.TrimSpace(r.FormValue("name"))
-	brand := strings.TrimSpace(r.FormValue("brand"))
-	expDate := strings.TrimSpace(r.FormValue("expiration_date"))
-	addDate := strings.TrimSpace(r.FormValue("addition_date"))
-	manual := strings.TrimSpace(r.FormValue("isManual"))
-	var message string
 
-	// check valid barcode and parse date
-	if barcode == "" || len(barcode) < 3 {
-		w.WriteHeader(http.StatusBadRequest)
-		message = fmt.Sprintf("The provided barcode '%s' is not valid", barcode)
-		_ = json.NewEncoder(w).Encode(message)
-		return
-	}
-	expirationDate, err := time.Parse("2006-01-02", expDate)
+	_, err := rt.audited(r).AddItem(form.product, form.lot, form.separate)
 	if err != nil {
-		expirationDate = time.Now().AddDate(0, 0, 14)
-	}
-
-	additionDate := time.Now()
-	if manual == "true" {
-		additionDate, _ = time.Parse("2006-01-02", addDate)
-	}
-
-	itemtToAdd := models.ProductInfo{
-		Barcode: barcode,
-		Name:    name,
-		Brand:   brand,
-	}
-	err = rt.db.AddItem(itemtToAdd, expirationDate, additionDate)
-	if err != nil {
-		ctx.Logger.Errorf("Error while adding item: adding new item", err)
-		http.Error(w, "Error while adding item: adding new item", http.StatusInternalServerError)
+		ctx.Logger.WithError(err).Error("Error while adding item")
+		errs[""] = "Errore durante il salvataggio, riprova."
+		rt.renderItemFormErrors(w, r, form, errs, ctx)
 		return
 	}
 
 	ctx.Logger.Info("Item added succesfully")
-	w.Header().Set("HX-Trigger", `{"item-added": true}`)
+	w.Header().Set("content-type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	_ = json.NewEncoder(w).Encode(models.Item{Barcode: barcode})
+	_ = json.NewEncoder(w).Encode(models.Item{Barcode: form.product.Barcode})
 }
 
 func (rt *_router) getExpirationForm(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
@@ -83,37 +59,75 @@ func (rt *_router) getExpirationForm(w http.ResponseWriter, r *http.Request, _ h
 		return
 	}
 
+	if err = rt.renderScanForm(r.Context(), w, barcode, ctx); err != nil {
+		http.Error(w, "Failed to render the form", http.StatusInternalServerError)
+	}
+}
+
+// renderScanForm renders the ExpirationModal of a scanned barcode to `w`, with the product stored locally or, for new
+// ones, looked up on Open Food Facts. The package size is seeded from the lots already stored, or from the OFF quantity.
+func (rt *_router) renderScanForm(c context.Context, w io.Writer, barcode string, ctx reqcontext.RequestContext) error {
 	// check if item already exists, if yes add, else create new
 	exists, localItem, err := rt.db.GetItemsByBarcode(barcode)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to check existing items")
+		return err
+	}
 	var itemtToAdd models.ProductInfo
+	lot := models.Item{Location: models.DefaultLocation}
 	if exists {
 		itemtToAdd = models.ProductInfo{
 			Barcode: barcode,
 			Name:    localItem[0].Name,
 			Brand:   localItem[0].Brand,
 		}
+		lot.Unit, lot.PackageAmount = localItem[0].Unit, localItem[0].PackageAmount
 	} else {
 		apiInfo, err := rt.foodApi.GetProductByBarcode(barcode)
 		if err != nil {
-			ctx.Logger.Errorf("Failed to fetch product info", err)
-			http.Error(w, "Failed to fetch product info", http.StatusInternalServerError)
-			return
+			ctx.Logger.WithError(err).Error("Failed to fetch product info")
+			return err
 		}
 		itemtToAdd = apiInfo
+		lot.Unit, lot.PackageAmount = apiInfo.Unit, apiInfo.PackageAmount
 	}
 
 	// render the expiration modal
-	err = templates.ExpirationModal(itemtToAdd, false, "", time.Time{}).Render(r.Context(), w)
+	// warn about what is already stored, to avoid buying duplicates
+	stock := models.SummarizeStock(barcode, localItem)
+	err = templates.ExpirationModal(itemtToAdd, false, lot, stock, nil).Render(c, w)
 	if err != nil {
 		ctx.Logger.Errorf("Error rendering modal: %v", err)
-		http.Error(w, "Error rendering modal", http.StatusInternalServerError)
 	}
+	return err
+}
+
+// checkStock is a lightweight JSON endpoint telling how many units of a barcode are already stored, meant for quick
+// checks from a phone while shopping. It only looks at the local database.
+func (rt *_router) checkStock(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("content-type", "application/json")
+
+	barcode := strings.TrimSpace(r.URL.Query().Get("barcode"))
+	if barcode == "" || len(barcode) < 3 {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(fmt.Sprintf("The provided barcode '%s' is not valid", barcode))
+		return
+	}
+
+	_, lots, err := rt.db.GetItemsByBarcode(barcode)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to check stock")
+		http.Error(w, "Failed to check stock", http.StatusInternalServerError)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(models.SummarizeStock(barcode, lots))
 }
 
 func (rt *_router) getManualForm(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
 	emptyProduct := models.ProductInfo{}
 
-	err := templates.ExpirationModal(emptyProduct, true, "", time.Time{}).Render(r.Context(), w)
+	err := templates.ExpirationModal(emptyProduct, true, models.Item{Location: models.DefaultLocation}, models.StockSummary{}, nil).Render(r.Context(), w)
 	if err != nil {
 		ctx.Logger.Errorf("Error rendering manual modal: %v", err)
 		http.Error(w, "Render error", http.StatusInternalServerError)
@@ -139,6 +153,18 @@ func (rt *_router) getHomeItems(w http.ResponseWriter, r *http.Request, _ httpro
 	}
 	homeItems.ExpiringItems = expiringItems
 
+	// the at risk list needs every product, as the risk does not follow the expiration order
+	products, _, err := rt.db.GetFridge(models.FridgeFilter{}, "", 0)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to get home items")
+		http.Error(w, "Failed loading home items", http.StatusInternalServerError)
+		return
+	}
+	if err = rt.attachForecasts(products); err != nil {
+		ctx.Logger.WithError(err).Error("Failed to forecast consumption")
+	}
+	homeItems.AtRiskItems = forecast.MostAtRisk(products, 10)
+
 	err = templates.HomeItems(homeItems).Render(r.Context(), w)
 	if err != nil {
 		http.Error(w, "HomeItems render error", http.StatusInternalServerError)
@@ -146,32 +172,201 @@ func (rt *_router) getHomeItems(w http.ResponseWriter, r *http.Request, _ httpro
 }
 
 func (rt *_router) updateItem(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
-	r.ParseForm()
-	id := r.FormValue("id")
-	name := r.FormValue("name")
-	brand := r.FormValue("brand")
-	dateStr := r.FormValue("expiration_date")
-	date, _ := time.Parse("2006-01-02", dateStr)
+	// deleted in the meantime, e.g. from another tab
+	stored, err := rt.db.GetItemById(r.FormValue("id"))
+	if errors.Is(err, database.ErrItemNotFound) {
+		http.Error(w, "Item not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Error retrieving the item to update")
+		http.Error(w, "Error retrieving the item", http.StatusInternalServerError)
+		return
+	}
+	form, errs := parseItemForm(r, &stored)
+	if len(errs) > 0 {
+		rt.renderItemFormErrors(w, r, form, errs, ctx)
+		return
+	}
+
+	err = rt.audited(r).UpdateItem(form.lot)
+	if errors.Is(err, database.ErrItemNotFound) {
+		http.Error(w, "Item not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Error while updating item")
+		errs[""] = "Errore durante il salvataggio, riprova."
+		rt.renderItemFormErrors(w, r, form, errs, ctx)
+		return
+	}
 
-	err := rt.db.UpdateItem(id, name, brand, date)
+	w.WriteHeader(http.StatusOK)
+}
+
+// renderItemFormErrors shows the item modal again in place of the submitted one, with the errors next to the fields.
+// The status stays 200, or HTMX wouldn't swap it.
+func (rt *_router) renderItemFormErrors(w http.ResponseWriter, r *http.Request, form itemForm, errs models.FormErrors, ctx reqcontext.RequestContext) {
+	// a scanned product keeps the warning about what is already stored
+	var stock models.StockSummary
+	if !form.manual && form.lot.Id == uuid.Nil && len(form.product.Barcode) >= minBarcodeLength {
+		if _, lots, err := rt.db.GetItemsByBarcode(form.product.Barcode); err == nil {
+			stock = models.SummarizeStock(form.product.Barcode, lots)
+		}
+	}
+
+	w.Header().Set("HX-Retarget", "#modal-backdrop")
+	w.Header().Set("HX-Reswap", "outerHTML")
+	err := templates.ExpirationModal(form.product, form.manual, form.lot, stock, errs).Render(r.Context(), w)
 	if err != nil {
-		http.Error(w, "Error while updating item", http.StatusInternalServerError)
-		message := fmt.Sprintf("Error: %s", err)
-		_ = json.NewEncoder(w).Encode(message)
+		ctx.Logger.WithError(err).Error("Error rendering the item form")
+	}
+}
+
+// splitItem moves some units of a lot to a new one from the edit modal, which is closed once done. On error the split
+// form is shown again with the messages next to the fields.
+func (rt *_router) splitItem(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	lot, err := rt.db.GetItemById(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Item not found", http.StatusNotFound)
 		return
 	}
 
-	w.Header().Set("HX-Trigger", `{"update-fridge": true}`)
+	part, errs := parseSplitForm(r, lot)
+	if len(errs) == 0 {
+		_, err = rt.audited(r).SplitItem(lot.Id.String(), part)
+		if errors.Is(err, database.ErrNotEnoughStock) {
+			// units taken in the meantime
+			errs["quantity"] = "Non ci sono abbastanza unità da spostare."
+		} else if err != nil {
+			ctx.Logger.WithError(err).Error("Error splitting the item")
+			errs[""] = "Errore durante il salvataggio, riprova."
+		}
+	}
+	if len(errs) > 0 {
+		if err = templates.SplitForm(lot, part, errs).Render(r.Context(), w); err != nil {
+			ctx.Logger.WithError(err).Error("Error rendering the split form")
+		}
+		return
+	}
+	w.Header().Set("HX-Retarget", "#modal-backdrop")
+	w.Header().Set("HX-Reswap", "delete")
 	w.WriteHeader(http.StatusOK)
 }
 
 func (rt *_router) deleteItem(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
 	id := r.URL.Query().Get("id")
-	err := rt.db.DeleteItem(id)
+	item, err := rt.db.GetItemById(id)
 	if err != nil {
+		http.Error(w, "Item not found", http.StatusNotFound)
+		return
+	}
+	err = rt.audited(r).DeleteItem(id)
+	if errors.Is(err, database.ErrItemNotFound) {
+		// deleted in the meantime, e.g. from another tab
+		http.Error(w, "Item not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Error deleting item")
 		http.Error(w, "Error deleting item", http.StatusInternalServerError)
 		return
 	}
-	w.Header().Set("HX-Trigger", `{"update-fridge": true}`)
 	w.WriteHeader(http.StatusOK)
+	// the lot goes to the trash, the toast lets the user restore it right away
+	if err = templates.UndoToast(item, undoWindow).Render(r.Context(), w); err != nil {
+		ctx.Logger.WithError(err).Error("Error rendering the undo toast")
+	}
+}
+
+// adjustQuantity adds or takes units from a lot with the +/- buttons of the detail modal, then renders the modal again.
+// The modal is closed when the last lot of the product is used up.
+func (rt *_router) adjustQuantity(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	id := r.URL.Query().Get("id")
+	delta, err := strconv.Atoi(r.URL.Query().Get("delta"))
+	if err != nil || delta == 0 || delta < -maxLotQuantity || delta > maxLotQuantity {
+		http.Error(w, "Invalid quantity", http.StatusBadRequest)
+		return
+	}
+	item, err := rt.db.GetItemById(id)
+	if err != nil {
+		http.Error(w, "Item not found", http.StatusNotFound)
+		return
+	}
+	if item.Quantity+delta > maxLotQuantity {
+		http.Error(w, "Quantity too large", http.StatusBadRequest)
+		return
+	}
+
+	_, err = rt.audited(r).AdjustItemQuantity(id, delta)
+	if errors.Is(err, database.ErrItemNotFound) {
+		http.Error(w, "Item not found", http.StatusNotFound)
+		return
+	} else if errors.Is(err, database.ErrNotEnoughStock) {
+		http.Error(w, "Not enough units", http.StatusConflict)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Error adjusting the quantity")
+		http.Error(w, "Error adjusting the quantity", http.StatusInternalServerError)
+		return
+	}
+	rt.refreshFridgeDetails(w, r, item.Barcode, ctx)
+}
+
+// useAmount consumes part of a measured lot, e.g. 200 g of a 1 kg bag, from the detail modal, then renders the modal
+// again like adjustQuantity.
+func (rt *_router) useAmount(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	id := r.URL.Query().Get("id")
+	amount, err := models.ParseAmount(r.PostFormValue("amount"))
+	if err != nil || amount <= 0 {
+		http.Error(w, "Invalid amount", http.StatusBadRequest)
+		return
+	}
+	item, err := rt.db.GetItemById(id)
+	if err != nil {
+		http.Error(w, "Item not found", http.StatusNotFound)
+		return
+	}
+
+	_, err = rt.audited(r).ConsumeAmount(id, amount, r.PostFormValue("unit"))
+	if errors.Is(err, database.ErrItemNotFound) {
+		http.Error(w, "Item not found", http.StatusNotFound)
+		return
+	} else if errors.Is(err, database.ErrIncompatibleUnit) {
+		http.Error(w, "Invalid unit", http.StatusBadRequest)
+		return
+	} else if errors.Is(err, database.ErrNotEnoughStock) {
+		http.Error(w, "Not enough left", http.StatusConflict)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Error consuming the amount")
+		http.Error(w, "Error consuming the amount", http.StatusInternalServerError)
+		return
+	}
+	rt.refreshFridgeDetails(w, r, item.Barcode, ctx)
+}
+
+// refreshFridgeDetails renders the detail modal of `barcode` again after one of its lots changed. The reply is empty
+// when no lot is left, which removes the modal.
+func (rt *_router) refreshFridgeDetails(w http.ResponseWriter, r *http.Request, barcode string, ctx reqcontext.RequestContext) {
+	exists, items, err := rt.db.GetItemsByBarcode(barcode)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Error retrieving the lots")
+		http.Error(w, "Error retrieving the lots", http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	rt.renderFridgeDetails(w, r, barcode, items, ctx)
+}
+
+// validLabel returns `label` if it's one of models.Labels, an empty label otherwise.
+func validLabel(label string) string {
+	if slices.Contains(models.Labels, label) {
+		return label
+	}
+	return ""
 }
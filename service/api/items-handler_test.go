@@ -0,0 +1,372 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/lorenzougolini/wimf-app/service/client"
+	"github.com/lorenzougolini/wimf-app/service/models"
+)
+
+// TestUpdateOldLot edits a lot expired long ago: its date is kept as it is, while a new one is checked like when
+// adding.
+func TestUpdateOldLot(t *testing.T) {
+	srv, db := newTestServerDB(t)
+	expired := time.Now().AddDate(-2, 0, 0).Format("2006-01-02")
+	// the forms and the API refuse such dates on new lots
+	id, err := db.AddItem(models.ProductInfo{Barcode: "8005678", Name: "Marmellata"}, models.Item{
+		Quantity:       1,
+		ExpirationDate: time.Now().AddDate(-2, 0, 0),
+		AdditionDate:   time.Now().AddDate(-3, 0, 0),
+		Location:       models.DefaultLocation,
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lot, err := client.New(srv.URL).GetLot(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	update := func(expiration string) *http.Response {
+		t.Helper()
+		form := url.Values{
+			"id":              {lot.Id.String()},
+			"isManual":        {"true"},
+			"name":            {"Marmellata di fragole"},
+			"quantity":        {"1"},
+			"expiration_date": {expiration},
+			"addition_date":   {lot.AdditionDate.Format("2006-01-02")},
+		}
+		req, err := http.NewRequest(http.MethodPut, srv.URL+"/fridge/items", strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("content-type", "application/x-www-form-urlencoded")
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		return resp
+	}
+
+	if resp := update(expired); resp.StatusCode != http.StatusOK || resp.Header.Get("HX-Retarget") != "" {
+		t.Errorf("keeping the expiration date: status %d, form shown again %t; want it saved", resp.StatusCode,
+			resp.Header.Get("HX-Retarget") != "")
+	}
+	if resp := update(time.Now().AddDate(-2, 0, 1).Format("2006-01-02")); resp.Header.Get("HX-Retarget") == "" {
+		t.Error("changing the expiration date to two years ago: saved, want the form shown again with the error")
+	}
+}
+
+// TestUseInvalidAmount takes amounts that aren't numbers from a measured lot, which must be left as it is.
+func TestUseInvalidAmount(t *testing.T) {
+	srv := newTestServer(t)
+	c := client.New(srv.URL)
+	lot, err := c.CreateLot(client.LotRequest{
+		Barcode:        "8001234",
+		Name:           "Farina",
+		Quantity:       2,
+		Unit:           "g",
+		PackageAmount:  1000,
+		ExpirationDate: time.Now().AddDate(0, 6, 0).Format("2006-01-02"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, amount := range []string{"NaN", "Inf", "+Inf", "-Inf", "0", "abc"} {
+		form := url.Values{"amount": {amount}, "unit": {"g"}}
+		resp, err := srv.Client().PostForm(srv.URL+"/fridge/item/use?id="+lot.Id.String(), form)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("using %s g: status %d, want %d", amount, resp.StatusCode, http.StatusBadRequest)
+		}
+	}
+	stored, err := c.GetLot(lot.Id.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Quantity != 2 || stored.Amount != 2000 {
+		t.Errorf("lot = %d packages, %v g; want 2, 2000", stored.Quantity, stored.Amount)
+	}
+}
+
+// TestCheckStock asks what is stored of a barcode before buying it again: the units of all its lots, and the lot
+// expiring first.
+func TestCheckStock(t *testing.T) {
+	srv, db := newTestServerDB(t)
+	yogurt := models.ProductInfo{Barcode: "8005678", Name: "Yogurt"}
+	soon := time.Now().AddDate(0, 0, 3)
+	for _, lot := range []models.Item{
+		{Quantity: 2, ExpirationDate: time.Now().AddDate(0, 1, 0), Location: "Dispensa"},
+		{Quantity: 1, ExpirationDate: soon, Location: "Frigo"},
+	} {
+		lot.AdditionDate = time.Now()
+		if _, err := db.AddItem(yogurt, lot, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check := func(barcode string) (int, models.StockSummary) {
+		t.Helper()
+		resp, err := srv.Client().Get(srv.URL + "/check?barcode=" + url.QueryEscape(barcode))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var summary models.StockSummary
+		if resp.StatusCode == http.StatusOK {
+			if err = json.NewDecoder(resp.Body).Decode(&summary); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.StatusCode, summary
+	}
+
+	want := models.StockSummary{Barcode: "8005678", Name: "Yogurt", InStock: true, Quantity: 3, Lots: 2,
+		NextExpiration: soon.Format("2006-01-02"), NextLocation: "Frigo"}
+	if status, summary := check("8005678"); status != http.StatusOK || summary != want {
+		t.Errorf("stock = %d %+v, want %+v", status, summary, want)
+	}
+	if status, summary := check("8001234"); status != http.StatusOK || summary.InStock || summary.Quantity != 0 {
+		t.Errorf("stock of a product never bought = %d %+v, want none", status, summary)
+	}
+	if status, _ := check("80"); status != http.StatusBadRequest {
+		t.Errorf("short barcode: status %d, want 400", status)
+	}
+}
+
+// TestLotNoteAndLabel writes a note and a label from the edit form: both are kept on the lot, the note is shown on the
+// fridge cards and found by the search, and a colour that isn't one of the labels clears the label.
+func TestLotNoteAndLabel(t *testing.T) {
+	srv, db := newTestServerDB(t)
+	id, err := db.AddItem(models.ProductInfo{Barcode: "8005678", Name: "Panna"}, models.Item{
+		Quantity:       1,
+		ExpirationDate: time.Now().AddDate(0, 0, 10),
+		AdditionDate:   time.Now(),
+		Location:       models.DefaultLocation,
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lot, err := db.GetItemById(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edit := func(note, label string) models.Item {
+		t.Helper()
+		form := url.Values{
+			"id":              {lot.Id.String()},
+			"name":            {lot.Name},
+			"expiration_date": {lot.ExpirationDate.Format("2006-01-02")},
+			"note":            {note},
+			"label":           {label},
+		}
+		req, err := http.NewRequest(http.MethodPut, srv.URL+"/fridge/items", strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("content-type", "application/x-www-form-urlencoded")
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("edit: status %d, want 200", resp.StatusCode)
+		}
+		edited, err := db.GetItemById(lot.Id.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return edited
+	}
+	fridge := func(query string) string {
+		t.Helper()
+		resp, err := srv.Client().Get(srv.URL + "/fridge?q=" + url.QueryEscape(query))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(body)
+	}
+
+	note := "per la torta di domenica"
+	if edited := edit(note, "blue"); edited.Note != note || edited.Label != "blue" {
+		t.Errorf("lot = note %q, label %q; want %q, blue", edited.Note, edited.Label, note)
+	}
+	if !strings.Contains(fridge("torta"), note) {
+		t.Error("searching the note: lot not shown with its note")
+	}
+	if strings.Contains(fridge("biscotti"), note) {
+		t.Error("searching another word: lot shown")
+	}
+	if edited := edit(note, "pink"); edited.Label != "" {
+		t.Errorf("label = %q after choosing an unknown colour, want none", edited.Label)
+	}
+}
+
+// TestAddAndAdjustQuantity adds six yogurts at once from the add form, then takes some away with the -/+ controls of the
+// detail modal, which must never take more than the lot has.
+func TestAddAndAdjustQuantity(t *testing.T) {
+	srv, db := newTestServerDB(t)
+	form := url.Values{
+		"isManual":        {"true"},
+		"barcode":         {"8005678"},
+		"name":            {"Yogurt"},
+		"quantity":        {"6"},
+		"expiration_date": {time.Now().AddDate(0, 0, 10).Format("2006-01-02")},
+		"addition_date":   {time.Now().Format("2006-01-02")},
+	}
+	resp, err := srv.Client().PostForm(srv.URL+"/fridge/items", form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("HX-Retarget") != "" {
+		t.Fatalf("add: status %d, form shown again %t; want it saved", resp.StatusCode,
+			resp.Header.Get("HX-Retarget") != "")
+	}
+	_, lots, err := db.GetItemsByBarcode("8005678")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lots) != 1 || lots[0].Quantity != 6 {
+		t.Fatalf("lots = %+v, want one of 6 units", lots)
+	}
+	id := lots[0].Id.String()
+
+	adjust := func(delta string) int {
+		t.Helper()
+		resp, err := srv.Client().Post(srv.URL+"/fridge/item/quantity?id="+id+"&delta="+delta, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+	for _, tt := range []struct {
+		delta    string
+		status   int
+		quantity int
+	}{
+		{"-2", http.StatusOK, 4},
+		{"1", http.StatusOK, 5},
+		{"-6", http.StatusConflict, 5},
+		{"0", http.StatusBadRequest, 5},
+		{"uno", http.StatusBadRequest, 5},
+		{"995", http.StatusBadRequest, 5},
+	} {
+		if status := adjust(tt.delta); status != tt.status {
+			t.Errorf("delta %s: status %d, want %d", tt.delta, status, tt.status)
+		}
+		if lot, err := db.GetItemById(id); err != nil {
+			t.Fatal(err)
+		} else if lot.Quantity != tt.quantity {
+			t.Errorf("after delta %s: %d units, want %d", tt.delta, lot.Quantity, tt.quantity)
+		}
+	}
+}
+
+func TestUpdateMissingLot(t *testing.T) {
+	srv := newTestServer(t)
+	form := url.Values{
+		"id":              {uuid.Must(uuid.NewV7()).String()},
+		"isManual":        {"true"},
+		"name":            {"Yogurt"},
+		"expiration_date": {time.Now().AddDate(0, 0, 10).Format("2006-01-02")},
+		"addition_date":   {time.Now().Format("2006-01-02")},
+	}
+	req, err := http.NewRequest(http.MethodPut, srv.URL+"/fridge/items", strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("content-type", "application/x-www-form-urlencoded")
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("updating a missing lot: status %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestDeleteMissingLot(t *testing.T) {
+	srv := newTestServer(t)
+	req, err := http.NewRequest(http.MethodDelete, srv.URL+"/fridge/item?id="+uuid.Must(uuid.NewV7()).String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("deleting a missing lot: status %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+}
+
+// TestLotBounds adds the same lots out of bounds from the item form and from the JSON API, which must both refuse them.
+func TestLotBounds(t *testing.T) {
+	srv := newTestServer(t)
+	c := client.New(srv.URL)
+	expiration := time.Now().AddDate(0, 1, 0).Format("2006-01-02")
+	tests := []struct {
+		field string
+		form  url.Values
+		lot   client.LotRequest
+	}{
+		{"quantity", url.Values{"quantity": {"1000"}}, client.LotRequest{Quantity: 1000}},
+		{"note", url.Values{"note": {strings.Repeat("a", maxNoteLength+1)}},
+			client.LotRequest{Note: strings.Repeat("a", maxNoteLength+1)}},
+		{"expiration_date", url.Values{"expiration_date": {time.Now().AddDate(11, 0, 0).Format("2006-01-02")}},
+			client.LotRequest{ExpirationDate: time.Now().AddDate(11, 0, 0).Format("2006-01-02")}},
+	}
+	for _, tt := range tests {
+		form := url.Values{"barcode": {"8005678"}, "isManual": {"true"}, "name": {"Yogurt"},
+			"expiration_date": {expiration}, "addition_date": {time.Now().Format("2006-01-02")}}
+		for k, v := range tt.form {
+			form[k] = v
+		}
+		resp, err := srv.Client().PostForm(srv.URL+"/fridge/items", form)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		if resp.Header.Get("HX-Retarget") == "" {
+			t.Errorf("form with %s out of bounds: saved, want the form shown again with the error", tt.field)
+		}
+
+		lot := tt.lot
+		lot.Barcode, lot.Name = "8005678", "Yogurt"
+		if lot.ExpirationDate == "" {
+			lot.ExpirationDate = expiration
+		}
+		if _, err = c.CreateLot(lot); err == nil {
+			t.Errorf("API lot with %s out of bounds: created", tt.field)
+		} else if e, ok := err.(*client.Error); !ok || e.Fields[tt.field] == "" {
+			t.Errorf("API lot with %s out of bounds: %v, want the field invalid", tt.field, err)
+		}
+	}
+	if lots, err := c.ListLots("8005678"); err != nil || len(lots) != 0 {
+		t.Errorf("lots = %v, %v; want none", lots, err)
+	}
+}
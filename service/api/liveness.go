@@ -1,16 +1,34 @@
 package api
 
 import (
-	"github.com/julienschmidt/httprouter"
+	"encoding/json"
 	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/lorenzougolini/wimf-app/service/backup"
 )
 
+// livenessReply is the body of the liveness endpoint.
+type livenessReply struct {
+	Status string `json:"status"`
+	// Backup is omitted when the scheduled backups are disabled
+	Backup *backup.Status `json:"backup,omitempty"`
+}
+
 // liveness is an HTTP handler that checks the API server status. If the server cannot serve requests (e.g., some
 // resources are not ready), this should reply with HTTP Status 500. Otherwise, with HTTP Status 200
 func (rt *_router) liveness(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	/* Example of liveness check:
-	if err := rt.DB.Ping(); err != nil {
+	if err := rt.db.Ping(); err != nil {
+		rt.baseLogger.WithError(err).Error("liveness: database not reachable")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
-	}*/
+	}
+
+	reply := livenessReply{Status: "ok"}
+	if rt.backups != nil {
+		status := rt.backups.Status()
+		reply.Backup = &status
+	}
+	w.Header().Set("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(reply)
 }
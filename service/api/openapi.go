@@ -0,0 +1,19 @@
+package api
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// openAPISpec is the OpenAPI document of the JSON API under /api/v1.
+//
+//go:embed openapi.yaml
+var openAPISpec []byte
+
+// getOpenAPI serves the OpenAPI document of the JSON API.
+func getOpenAPI(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+	w.Header().Set("content-type", "application/yaml")
+	_, _ = w.Write(openAPISpec)
+}
@@ -0,0 +1,364 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	_ "github.com/mattn/go-sqlite3"
+	"gopkg.in/yaml.v2"
+)
+
+// openAPIDoc is the OpenAPI document of the JSON API, read to check the requests and the replies against it. Only the
+// parts of the schemas used by the document are supported.
+type openAPIDoc struct {
+	root map[string]any
+	// tested holds the operations exercised, as "METHOD /path"
+	tested map[string]bool
+}
+
+func loadOpenAPI(t *testing.T) *openAPIDoc {
+	t.Helper()
+	var doc any
+	if err := yaml.Unmarshal(openAPISpec, &doc); err != nil {
+		t.Fatalf("invalid openapi.yaml: %v", err)
+	}
+	return &openAPIDoc{root: stringKeys(doc).(map[string]any), tested: make(map[string]bool)}
+}
+
+// stringKeys converts the maps decoded by yaml.v2 to maps with string keys, like the ones decoded from JSON.
+func stringKeys(v any) any {
+	switch v := v.(type) {
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = stringKeys(e)
+		}
+		return m
+	case []any:
+		for i, e := range v {
+			v[i] = stringKeys(e)
+		}
+	}
+	return v
+}
+
+// resolve follows the $ref of `node`, if any.
+func (d *openAPIDoc) resolve(node map[string]any) map[string]any {
+	ref, ok := node["$ref"].(string)
+	if !ok {
+		return node
+	}
+	target := any(d.root)
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		target = target.(map[string]any)[part]
+	}
+	return d.resolve(target.(map[string]any))
+}
+
+// operation returns the operation of the document for `method` on `path`, e.g. "/lots/{id}".
+func (d *openAPIDoc) operation(t *testing.T, method, path string) map[string]any {
+	t.Helper()
+	item, _ := d.root["paths"].(map[string]any)[path].(map[string]any)
+	op, ok := item[strings.ToLower(method)].(map[string]any)
+	if !ok {
+		t.Fatalf("%s %s is not in openapi.yaml", method, path)
+	}
+	d.tested[method+" "+path] = true
+	return op
+}
+
+// call sends `body` (if not nil) to `url`, the operation `method` `path` of the document, and checks the reply
+// against it. Bodies of the requests expected to succeed are checked against the document too. It returns the status
+// and the decoded reply.
+func (d *openAPIDoc) call(t *testing.T, srv *httptest.Server, method, path, url string, body any) (int, any) {
+	t.Helper()
+	op := d.operation(t, method, path)
+
+	var reqBody io.Reader
+	var sent []byte
+	if body != nil {
+		var err error
+		if sent, err = json.Marshal(body); err != nil {
+			t.Fatal(err)
+		}
+		reqBody = bytes.NewReader(sent)
+	}
+	req, err := http.NewRequest(method, srv.URL+"/api/v1"+url, reqBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("content-type", "application/json")
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	replied, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	where := fmt.Sprintf("%s %s (%d)", method, url, resp.StatusCode)
+	if resp.StatusCode < http.StatusBadRequest && body != nil {
+		schema := d.resolve(op["requestBody"].(map[string]any))["content"].(map[string]any)["application/json"]
+		for _, e := range d.validate(schema.(map[string]any)["schema"].(map[string]any), decodeJSON(t, sent), "request") {
+			t.Errorf("%s: %s", where, e)
+		}
+	}
+
+	spec, ok := op["responses"].(map[string]any)[strconv.Itoa(resp.StatusCode)].(map[string]any)
+	if !ok {
+		t.Fatalf("%s: status not in openapi.yaml, reply %s", where, replied)
+	}
+	spec = d.resolve(spec)
+	headers, _ := spec["headers"].(map[string]any)
+	for name := range headers {
+		if resp.Header.Get(name) == "" {
+			t.Errorf("%s: missing header %s", where, name)
+		}
+	}
+	content, ok := spec["content"].(map[string]any)
+	if !ok {
+		if len(replied) > 0 {
+			t.Errorf("%s: unexpected reply %s", where, replied)
+		}
+		return resp.StatusCode, nil
+	}
+	if ct := resp.Header.Get("content-type"); !strings.HasPrefix(ct, "application/json") {
+		t.Errorf("%s: content-type %q, want application/json", where, ct)
+	}
+	reply := decodeJSON(t, replied)
+	schema := content["application/json"].(map[string]any)["schema"].(map[string]any)
+	for _, e := range d.validate(schema, reply, "reply") {
+		t.Errorf("%s: %s", where, e)
+	}
+	return resp.StatusCode, reply
+}
+
+// untested returns the operations of the document that were never called.
+func (d *openAPIDoc) untested() []string {
+	var missing []string
+	for path, item := range d.root["paths"].(map[string]any) {
+		for method := range item.(map[string]any) {
+			op := strings.ToUpper(method) + " " + path
+			if method != "parameters" && !d.tested[op] {
+				missing = append(missing, op)
+			}
+		}
+	}
+	slices.Sort(missing)
+	return missing
+}
+
+// validate checks `value`, decoded from JSON, against `schema`, returning what doesn't match. Objects can't have
+// properties missing from the schema, so that the document can't fall behind the code.
+func (d *openAPIDoc) validate(schema map[string]any, value any, at string) []string {
+	schema = d.resolve(schema)
+	if value == nil {
+		if schema["nullable"] == true {
+			return nil
+		}
+		return []string{at + " is null"}
+	}
+	if enum, ok := schema["enum"].([]any); ok && !slices.Contains(enum, value) {
+		return []string{fmt.Sprintf("%s = %v, not one of %v", at, value, enum)}
+	}
+
+	var errs []string
+	switch schema["type"] {
+	case "object":
+		obj, ok := value.(map[string]any)
+		if !ok {
+			return []string{at + " is not an object"}
+		}
+		required, _ := schema["required"].([]any)
+		for _, name := range required {
+			if _, ok := obj[name.(string)]; !ok {
+				errs = append(errs, fmt.Sprintf("%s.%s is missing", at, name))
+			}
+		}
+		properties, _ := schema["properties"].(map[string]any)
+		for name, v := range obj {
+			property, ok := properties[name].(map[string]any)
+			if !ok {
+				property, ok = schema["additionalProperties"].(map[string]any)
+			}
+			if !ok {
+				errs = append(errs, fmt.Sprintf("%s.%s is not in the schema", at, name))
+				continue
+			}
+			errs = append(errs, d.validate(property, v, at+"."+name)...)
+		}
+	case "array":
+		arr, ok := value.([]any)
+		if !ok {
+			return []string{at + " is not an array"}
+		}
+		for i, v := range arr {
+			errs = append(errs, d.validate(schema["items"].(map[string]any), v, fmt.Sprintf("%s[%d]", at, i))...)
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return []string{at + " is not a string"}
+		}
+		switch schema["format"] {
+		case "date-time":
+			if _, err := time.Parse(time.RFC3339, s); err != nil {
+				errs = append(errs, fmt.Sprintf("%s = %q, not a date-time", at, s))
+			}
+		case "uuid":
+			if _, err := uuid.FromString(s); err != nil {
+				errs = append(errs, fmt.Sprintf("%s = %q, not a uuid", at, s))
+			}
+		}
+	case "integer", "number":
+		n, ok := value.(json.Number)
+		if !ok {
+			return []string{at + " is not a number"}
+		}
+		f, err := n.Float64()
+		if schema["type"] == "integer" {
+			_, err = n.Int64()
+		}
+		if err != nil {
+			return []string{fmt.Sprintf("%s = %s, not an %s", at, n, schema["type"])}
+		}
+		if minimum, ok := schema["minimum"].(int); ok && f < float64(minimum) {
+			errs = append(errs, fmt.Sprintf("%s = %s, less than %d", at, n, minimum))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			errs = append(errs, at+" is not a boolean")
+		}
+	}
+	return errs
+}
+
+func decodeJSON(t *testing.T, data []byte) any {
+	t.Helper()
+	var v any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		t.Fatalf("invalid JSON %s: %v", data, err)
+	}
+	return v
+}
+
+func TestAPIMatchesOpenAPI(t *testing.T) {
+	srv := newTestServer(t)
+	doc := loadOpenAPI(t)
+	expect := func(status, want int) {
+		t.Helper()
+		if status != want {
+			t.Errorf("status %d, want %d", status, want)
+		}
+	}
+	expiration := time.Now().AddDate(0, 1, 0).Format("2006-01-02")
+	unknown := uuid.Must(uuid.NewV7()).String()
+
+	status, _ := doc.call(t, srv, "GET", "/locations", "/locations", nil)
+	expect(status, http.StatusOK)
+
+	// lots
+	status, reply := doc.call(t, srv, "POST", "/lots", "/lots", map[string]any{
+		"barcode": "8001234", "name": "Farina", "brand": "Molino", "quantity": 3, "unit": "kg",
+		"package_amount": 1, "expiration_date": expiration, "label": "green",
+	})
+	expect(status, http.StatusCreated)
+	measured := reply.(map[string]any)["id"].(string)
+	status, reply = doc.call(t, srv, "POST", "/lots", "/lots", map[string]any{
+		"barcode": "8005678", "name": "Yogurt", "quantity": 4, "expiration_date": expiration, "location": "Frigo",
+		"note": "bianco", "separate": true,
+	})
+	expect(status, http.StatusCreated)
+	pieces := reply.(map[string]any)["id"].(string)
+	status, reply = doc.call(t, srv, "POST", "/lots", "/lots", map[string]any{
+		"barcode": "8005678", "name": "Yogurt", "expiration_date": expiration, "location": "Frigo", "note": "bianco",
+	})
+	expect(status, http.StatusOK)
+	if id := reply.(map[string]any)["id"]; id != pieces {
+		t.Errorf("identical lot added to %v, want %s", id, pieces)
+	}
+	status, _ = doc.call(t, srv, "POST", "/lots", "/lots", map[string]any{"barcode": "80", "unit": "oz"})
+	expect(status, http.StatusUnprocessableEntity)
+	status, _ = doc.call(t, srv, "POST", "/lots", "/lots", "not a lot")
+	expect(status, http.StatusBadRequest)
+
+	status, _ = doc.call(t, srv, "GET", "/lots", "/lots", nil)
+	expect(status, http.StatusOK)
+	status, _ = doc.call(t, srv, "GET", "/lots", "/lots?barcode=8001234", nil)
+	expect(status, http.StatusOK)
+	status, _ = doc.call(t, srv, "GET", "/lots/{id}", "/lots/"+measured, nil)
+	expect(status, http.StatusOK)
+	status, _ = doc.call(t, srv, "GET", "/lots/{id}", "/lots/"+unknown, nil)
+	expect(status, http.StatusNotFound)
+
+	status, _ = doc.call(t, srv, "PUT", "/lots/{id}", "/lots/"+pieces, map[string]any{
+		"quantity": 5, "added_at": time.Now().Format(time.RFC3339), "label": "",
+	})
+	expect(status, http.StatusOK)
+	status, _ = doc.call(t, srv, "PUT", "/lots/{id}", "/lots/"+pieces, map[string]any{"location": "Cantina"})
+	expect(status, http.StatusUnprocessableEntity)
+	status, _ = doc.call(t, srv, "PUT", "/lots/{id}", "/lots/"+pieces, "not a lot")
+	expect(status, http.StatusBadRequest)
+	status, _ = doc.call(t, srv, "PUT", "/lots/{id}", "/lots/"+unknown, map[string]any{"quantity": 1})
+	expect(status, http.StatusNotFound)
+
+	status, _ = doc.call(t, srv, "POST", "/lots/{id}/split", "/lots/"+pieces+"/split", map[string]any{
+		"quantity": 2, "location": "Freezer",
+	})
+	expect(status, http.StatusCreated)
+	status, _ = doc.call(t, srv, "POST", "/lots/{id}/split", "/lots/"+pieces+"/split", map[string]any{"quantity": 3})
+	expect(status, http.StatusUnprocessableEntity)
+	status, _ = doc.call(t, srv, "POST", "/lots/{id}/split", "/lots/"+pieces+"/split", "not a split")
+	expect(status, http.StatusBadRequest)
+	status, _ = doc.call(t, srv, "POST", "/lots/{id}/split", "/lots/"+unknown+"/split", map[string]any{"quantity": 1})
+	expect(status, http.StatusNotFound)
+
+	// products
+	status, reply = doc.call(t, srv, "GET", "/products", "/products?sort=name&limit=1", nil)
+	expect(status, http.StatusOK)
+	next := reply.(map[string]any)["next"].(string)
+	status, _ = doc.call(t, srv, "GET", "/products", "/products?sort=name&limit=1&cursor="+next, nil)
+	expect(status, http.StatusOK)
+	// a cursor of another order has a key of the wrong type
+	status, _ = doc.call(t, srv, "GET", "/products", "/products?sort=quantity&cursor="+next, nil)
+	expect(status, http.StatusBadRequest)
+	status, _ = doc.call(t, srv, "GET", "/products", "/products?limit=0", nil)
+	expect(status, http.StatusBadRequest)
+	status, _ = doc.call(t, srv, "GET", "/products/{barcode}", "/products/8001234", nil)
+	expect(status, http.StatusOK)
+	status, _ = doc.call(t, srv, "GET", "/products/{barcode}", "/products/0000000", nil)
+	expect(status, http.StatusNotFound)
+	status, _ = doc.call(t, srv, "PUT", "/products/{barcode}", "/products/8005678", map[string]any{
+		"category": "dairy", "tags": []string{"colazione"},
+	})
+	expect(status, http.StatusOK)
+	status, _ = doc.call(t, srv, "PUT", "/products/{barcode}", "/products/8005678", map[string]any{"category": "toys"})
+	expect(status, http.StatusUnprocessableEntity)
+	status, _ = doc.call(t, srv, "PUT", "/products/{barcode}", "/products/8005678", "not a product")
+	expect(status, http.StatusBadRequest)
+	status, _ = doc.call(t, srv, "PUT", "/products/{barcode}", "/products/0000000", map[string]any{"category": "dairy"})
+	expect(status, http.StatusNotFound)
+
+	status, _ = doc.call(t, srv, "DELETE", "/lots/{id}", "/lots/"+measured, nil)
+	expect(status, http.StatusNoContent)
+	status, _ = doc.call(t, srv, "DELETE", "/lots/{id}", "/lots/"+measured, nil)
+	expect(status, http.StatusNotFound)
+
+	if missing := doc.untested(); len(missing) > 0 {
+		t.Errorf("operations not tested: %s", strings.Join(missing, ", "))
+	}
+}
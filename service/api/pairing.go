@@ -0,0 +1,301 @@
+package api
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/lorenzougolini/wimf-app/service/api/reqcontext"
+	"github.com/lorenzougolini/wimf-app/service/templates"
+)
+
+const (
+	// pairingTTL is how long a scan session lasts after it's opened or after the last scan
+	pairingTTL = 30 * time.Minute
+	// maxPairings bounds the scan sessions open at the same time
+	maxPairings = 64
+	// pairingQueue is how many scans wait for the desktop, e.g. while it reconnects
+	pairingQueue = 8
+
+	// pairingAlphabet leaves out the characters that are easily confused, like 0 and O
+	pairingAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	pairingCodeLen  = 6
+)
+
+var (
+	errPairingNotFound = errors.New("scan session not found or expired")
+	errPairingBusy     = errors.New("scan session not listening")
+	errTooManyPairings = errors.New("too many scan sessions")
+)
+
+// scanSession pairs a desktop, listening on /pair/events, with the phones scanning barcodes for it.
+type scanSession struct {
+	code    string
+	expires time.Time
+	scans   chan string
+}
+
+// scanSessions are the open scan sessions by code. They live in memory only: a restart closes them.
+type scanSessions struct {
+	mu       sync.Mutex
+	sessions map[string]*scanSession
+}
+
+func newScanSessions() *scanSessions {
+	return &scanSessions{sessions: make(map[string]*scanSession)}
+}
+
+// open starts a new scan session with a random code, removing the expired ones.
+func (s *scanSessions) open(now time.Time) (*scanSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for code, session := range s.sessions {
+		if !now.Before(session.expires) {
+			delete(s.sessions, code)
+		}
+	}
+	if len(s.sessions) >= maxPairings {
+		return nil, errTooManyPairings
+	}
+
+	for {
+		code, err := newPairingCode()
+		if err != nil {
+			return nil, err
+		}
+		if _, taken := s.sessions[code]; taken {
+			continue
+		}
+		session := &scanSession{code: code, expires: now.Add(pairingTTL), scans: make(chan string, pairingQueue)}
+		s.sessions[code] = session
+		return session, nil
+	}
+}
+
+// get returns the scan session `code`, or errPairingNotFound if it doesn't exist or is expired.
+func (s *scanSessions) get(code string, now time.Time) (*scanSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[normalizePairingCode(code)]
+	if !ok || !now.Before(session.expires) {
+		return nil, errPairingNotFound
+	}
+	return session, nil
+}
+
+// expiry returns when the scan session `code` expires, zero if it's already gone.
+func (s *scanSessions) expiry(code string) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if session, ok := s.sessions[code]; ok {
+		return session.expires
+	}
+	return time.Time{}
+}
+
+// scan sends `barcode` to the desktop of the scan session `code`, extending the session.
+func (s *scanSessions) scan(code string, barcode string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[normalizePairingCode(code)]
+	if !ok || !now.Before(session.expires) {
+		return errPairingNotFound
+	}
+	select {
+	case session.scans <- barcode:
+	default:
+		return errPairingBusy
+	}
+	session.expires = now.Add(pairingTTL)
+	return nil
+}
+
+// close ends the scan session `code`.
+func (s *scanSessions) close(code string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, normalizePairingCode(code))
+}
+
+func newPairingCode() (string, error) {
+	code := make([]byte, pairingCodeLen)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(pairingAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		code[i] = pairingAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// normalizePairingCode accepts the codes typed in lowercase or with spaces.
+func normalizePairingCode(code string) string {
+	return strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(code), " ", ""))
+}
+
+// pairingJoinURL is the URL encoded in the QR code of the scan session `code`, opened by the phone.
+func pairingJoinURL(r *http.Request, code string) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	u := url.URL{Scheme: scheme, Host: r.Host, Path: "/pair/join", RawQuery: url.Values{"code": {code}}.Encode()}
+	return u.String()
+}
+
+// getPairDesktop opens a new scan session and shows its code, waiting for the scans of the phone.
+func (rt *_router) getPairDesktop(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	session, err := rt.pairings.open(time.Now())
+	if errors.Is(err, errTooManyPairings) {
+		http.Error(w, "Too many scan sessions, try again later", http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Error opening the scan session")
+		http.Error(w, "Error opening the scan session", http.StatusInternalServerError)
+		return
+	}
+
+	err = templates.PairDesktop(session.code, pairingJoinURL(r, session.code), pairingTTL).Render(r.Context(), w)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Error rendering the pairing page")
+	}
+}
+
+// getPairEvents streams to the desktop the ExpirationModal of each barcode scanned by the phone, as the `scan` event,
+// and `expired` once the scan session ends.
+func (rt *_router) getPairEvents(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	session, err := rt.pairings.get(r.URL.Query().Get("code"), time.Now())
+	if err != nil {
+		http.Error(w, "Scan session not found", http.StatusNotFound)
+		return
+	}
+	rc := http.NewResponseController(w)
+	// The stream outlives the write timeout of the server
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		ctx.Logger.WithError(err).Error("Error disabling the write deadline of the event stream")
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("content-type", "text/event-stream")
+	w.Header().Set("cache-control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if _, err := fmt.Fprint(w, ": connected\n\n"); err != nil || rc.Flush() != nil {
+		return
+	}
+
+	// scans extend the session concurrently, so its expiry is only read under the lock
+	expired := time.NewTimer(time.Until(rt.pairings.expiry(session.code)))
+	defer expired.Stop()
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case <-rt.stop:
+			return
+		case barcode := <-session.scans:
+			var modal bytes.Buffer
+			if rt.renderScanForm(r.Context(), &modal, barcode, ctx) != nil {
+				modal.Reset()
+				err = templates.PairScanError(barcode).Render(r.Context(), &modal)
+			}
+			if err == nil {
+				err = writeEvent(w, "scan", modal.String())
+			}
+		case <-expired.C:
+			if until := time.Until(rt.pairings.expiry(session.code)); until > 0 {
+				expired.Reset(until)
+				continue
+			}
+			rt.pairings.close(session.code)
+			var status bytes.Buffer
+			if err = templates.PairExpired().Render(r.Context(), &status); err == nil {
+				err = writeEvent(w, "expired", status.String())
+			}
+			if err == nil {
+				_ = rc.Flush()
+			}
+			return
+		case <-keepAlive.C:
+			_, err = fmt.Fprint(w, ": keep-alive\n\n")
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// writeEvent writes a Server-Sent Event, splitting `data` in as many data lines as needed.
+func writeEvent(w http.ResponseWriter, event string, data string) error {
+	var b strings.Builder
+	b.WriteString("event: " + event + "\n")
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	_, err := w.Write([]byte(b.String()))
+	return err
+}
+
+// getPairJoin lets the phone join a scan session by typing its code, or straight from the QR code. Once joined, the
+// scanner of the home page sends the barcodes to the desktop.
+func (rt *_router) getPairJoin(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	code := r.URL.Query().Get("code")
+	var message string
+	if code != "" {
+		session, err := rt.pairings.get(code, time.Now())
+		if err == nil {
+			http.Redirect(w, r, "/?"+url.Values{"pair": {session.code}}.Encode(), http.StatusSeeOther)
+			return
+		}
+		message = "Codice non valido o scaduto: controlla quello mostrato sul computer."
+	}
+	if err := templates.PairJoin(normalizePairingCode(code), message).Render(r.Context(), w); err != nil {
+		ctx.Logger.WithError(err).Error("Error rendering the pairing page")
+	}
+}
+
+// postPairScan sends a barcode scanned by the phone to the desktop of the scan session, replying with the status shown
+// under the scanner.
+func (rt *_router) postPairScan(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	barcode := strings.TrimSpace(r.PostFormValue("barcode"))
+	if len(barcode) < 3 {
+		http.Error(w, "Invalid barcode", http.StatusBadRequest)
+		return
+	}
+
+	var message string
+	err := rt.pairings.scan(r.PostFormValue("code"), barcode, time.Now())
+	switch {
+	case errors.Is(err, errPairingNotFound):
+		message = "Sessione scaduta: apri di nuovo la pagina Abbina sul computer."
+	case errors.Is(err, errPairingBusy):
+		message = "Il computer non risponde: controlla che la pagina Abbina sia aperta."
+	case err != nil:
+		ctx.Logger.WithError(err).Error("Error sending the scan")
+		message = "Errore durante l'invio, riprova."
+	}
+	if err := templates.PairScanStatus(barcode, message).Render(r.Context(), w); err != nil {
+		ctx.Logger.WithError(err).Error("Error rendering the scan status")
+	}
+}
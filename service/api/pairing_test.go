@@ -0,0 +1,78 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lorenzougolini/wimf-app/service/client"
+)
+
+// TestPairingScan scans a barcode with the phone while the desktop is listening, as in a paired session.
+func TestPairingScan(t *testing.T) {
+	srv := newTestServer(t)
+	// a product already stored doesn't need Open Food Facts
+	_, err := client.New(srv.URL).CreateLot(client.LotRequest{
+		Barcode:        "8005678",
+		Name:           "Yogurt",
+		ExpirationDate: time.Now().AddDate(0, 0, 10).Format("2006-01-02"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := srv.Client().Get(srv.URL + "/pair")
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	match := regexp.MustCompile(`/pair/events\?code=([A-Z0-9]+)`).FindSubmatch(page)
+	if match == nil {
+		t.Fatalf("no scan session in the pairing page:\n%s", page)
+	}
+	code := string(match[1])
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/pair/events?code="+code, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Body.Close()
+
+	resp, err = srv.Client().PostForm(srv.URL+"/pair/scan", url.Values{"code": {code}, "barcode": {"8005678"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+
+	lines := bufio.NewScanner(stream.Body)
+	for lines.Scan() {
+		if lines.Text() != "event: scan" {
+			continue
+		}
+		var data strings.Builder
+		for lines.Scan() && lines.Text() != "" {
+			data.WriteString(lines.Text())
+		}
+		if !strings.Contains(data.String(), "Yogurt") {
+			t.Errorf("scan event without the product:\n%s", data.String())
+		}
+		return
+	}
+	t.Fatalf("no scan event: %v", lines.Err())
+}
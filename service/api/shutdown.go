@@ -2,5 +2,7 @@ package api
 
 // Close should close everything opened in the lifecycle of the `_router`; for example, background goroutines.
 func (rt *_router) Close() error {
+	close(rt.stop)
+	rt.background.Wait()
 	return nil
 }
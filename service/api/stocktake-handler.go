@@ -0,0 +1,236 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/lorenzougolini/wimf-app/service/api/reqcontext"
+	"github.com/lorenzougolini/wimf-app/service/database"
+	"github.com/lorenzougolini/wimf-app/service/models"
+	"github.com/lorenzougolini/wimf-app/service/templates"
+)
+
+// stocktakeChanged is the event sent on /events when the count of the stocktake changes, refreshing its table.
+const stocktakeChanged = "stocktake-changed"
+
+// getStocktake shows the stocktake in progress, or the form to start one.
+func (rt *_router) getStocktake(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	stocktake, err := rt.db.GetStocktake()
+	var current *models.Stocktake
+	if err == nil {
+		current = &stocktake
+	} else if !errors.Is(err, database.ErrNoStocktake) {
+		ctx.Logger.WithError(err).Error("Error retrieving the stocktake")
+		http.Error(w, "Error retrieving the stocktake", http.StatusInternalServerError)
+		return
+	}
+	var message string
+	if changed, err := strconv.Atoi(r.URL.Query().Get("done")); err == nil {
+		message = "Inventario concluso: " + strconv.Itoa(changed) + " prodotti aggiornati."
+	}
+	if err = templates.Stocktake(current, message).Render(r.Context(), w); err != nil {
+		ctx.Logger.WithError(err).Error("Error rendering the stocktake page")
+	}
+}
+
+// startStocktake takes the snapshot of the lots of a location, then goes back to the stocktake page to count them.
+func (rt *_router) startStocktake(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	location := r.PostFormValue("location")
+	if !slices.Contains(models.Locations, location) {
+		http.Error(w, "Invalid location", http.StatusBadRequest)
+		return
+	}
+	// a stocktake already in progress, e.g. started from another device, is simply shown
+	if err := rt.db.StartStocktake(location); err != nil && !errors.Is(err, database.ErrStocktakeInProgress) {
+		ctx.Logger.WithError(err).Error("Error starting the stocktake")
+		http.Error(w, "Error starting the stocktake", http.StatusInternalServerError)
+		return
+	}
+	rt.events.publish(stocktakeChanged)
+	http.Redirect(w, r, "/stocktake", http.StatusSeeOther)
+}
+
+// cancelStocktake drops the stocktake in progress, leaving the lots as they are.
+func (rt *_router) cancelStocktake(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	if err := rt.db.CancelStocktake(); err != nil {
+		ctx.Logger.WithError(err).Error("Error cancelling the stocktake")
+		http.Error(w, "Error cancelling the stocktake", http.StatusInternalServerError)
+		return
+	}
+	rt.events.publish(stocktakeChanged)
+	reloadStocktake(w, "/stocktake")
+}
+
+// getStocktakeTable renders the count of the stocktake, refreshed when it changes.
+func (rt *_router) getStocktakeTable(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	stocktake, err := rt.db.GetStocktake()
+	if errors.Is(err, database.ErrNoStocktake) {
+		// closed from another device
+		reloadStocktake(w, "/stocktake")
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Error retrieving the stocktake")
+		http.Error(w, "Error retrieving the stocktake", http.StatusInternalServerError)
+		return
+	}
+	if err = templates.StocktakeTable(stocktake).Render(r.Context(), w); err != nil {
+		ctx.Logger.WithError(err).Error("Error rendering the stocktake")
+	}
+}
+
+// scanStocktake counts a unit of a scanned barcode, replying with the status shown under the scanner.
+func (rt *_router) scanStocktake(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	barcode := strings.TrimSpace(r.PostFormValue("barcode"))
+	if len(barcode) < minBarcodeLength {
+		http.Error(w, "Invalid barcode", http.StatusBadRequest)
+		return
+	}
+
+	line, err := rt.db.CountStocktake(barcode)
+	if errors.Is(err, database.ErrNoStocktake) {
+		http.Error(w, "No stocktake in progress", http.StatusConflict)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Error counting the barcode")
+		http.Error(w, "Error counting the barcode", http.StatusInternalServerError)
+		return
+	}
+	rt.events.publish(stocktakeChanged)
+	if err = templates.StocktakeScanStatus(line).Render(r.Context(), w); err != nil {
+		ctx.Logger.WithError(err).Error("Error rendering the scan status")
+	}
+}
+
+// updateStocktakeLine sets the units counted on a line of the stocktake, ticked by hand.
+func (rt *_router) updateStocktakeLine(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	counted, err := strconv.Atoi(strings.TrimSpace(r.PostFormValue("counted")))
+	if err != nil || counted < 0 || counted > maxLotQuantity {
+		http.Error(w, "Invalid quantity", http.StatusBadRequest)
+		return
+	}
+
+	err = rt.db.SetStocktakeCount(r.URL.Query().Get("id"), counted)
+	if errors.Is(err, database.ErrItemNotFound) {
+		http.Error(w, "Line not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Error updating the stocktake")
+		http.Error(w, "Error updating the stocktake", http.StatusInternalServerError)
+		return
+	}
+	rt.events.publish(stocktakeChanged)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getStocktakeReview shows the differences between the count and the snapshot, to choose which ones to apply.
+func (rt *_router) getStocktakeReview(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	stocktake, err := rt.db.GetStocktake()
+	if errors.Is(err, database.ErrNoStocktake) {
+		reloadStocktake(w, "/stocktake")
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Error retrieving the stocktake")
+		http.Error(w, "Error retrieving the stocktake", http.StatusInternalServerError)
+		return
+	}
+	rt.renderStocktakeReview(w, r, stocktake, nil, nil, ctx)
+}
+
+// renderStocktakeReview renders the differences of `stocktake`, all ticked but the lines in `skipped`, with the errors
+// of its lines (by id, "" for the whole review).
+func (rt *_router) renderStocktakeReview(w http.ResponseWriter, r *http.Request, stocktake models.Stocktake, skipped []string, errs models.FormErrors, ctx reqcontext.RequestContext) {
+	if err := templates.StocktakeReview(stocktake, skipped, errs).Render(r.Context(), w); err != nil {
+		ctx.Logger.WithError(err).Error("Error rendering the stocktake review")
+	}
+}
+
+// applyStocktake applies the differences ticked in the review and closes the stocktake. The products found but not
+// recorded need a name and an expiration date to be added.
+func (rt *_router) applyStocktake(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	stocktake, err := rt.db.GetStocktake()
+	if errors.Is(err, database.ErrNoStocktake) {
+		reloadStocktake(w, "/stocktake")
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Error retrieving the stocktake")
+		http.Error(w, "Error retrieving the stocktake", http.StatusInternalServerError)
+		return
+	}
+
+	// the fields typed in the review are kept on the lines, to show them again on error
+	applied := r.PostForm["apply"]
+	var lines []models.StocktakeLine
+	var skipped []string
+	errs := models.FormErrors{}
+	today := time.Now().Truncate(24 * time.Hour)
+	for i := range stocktake.Lines {
+		line := &stocktake.Lines[i]
+		if !line.Recorded() {
+			line.Name = strings.TrimSpace(r.PostFormValue("name-" + line.Id))
+			line.ExpirationDate, _ = time.Parse("2006-01-02", r.PostFormValue("expiration_date-"+line.Id))
+		}
+		if !slices.Contains(applied, line.Id) {
+			skipped = append(skipped, line.Id)
+			continue
+		}
+		if !line.Recorded() {
+			expiration, msg := parseFormDate(r.PostFormValue("expiration_date-"+line.Id), "la data di scadenza")
+			if msg == "" {
+				msg = checkExpiration(expiration, today)
+			}
+			if line.Name == "" {
+				msg = "Inserisci il nome del prodotto."
+			}
+			if msg != "" {
+				errs[line.Id] = msg
+			}
+		}
+		lines = append(lines, *line)
+	}
+	if len(errs) > 0 {
+		errs[""] = "Completa i prodotti evidenziati, o togli la spunta per non aggiungerli."
+		rt.renderStocktakeReview(w, r, stocktake, skipped, errs, ctx)
+		return
+	}
+
+	changed, err := rt.audited(r).ApplyStocktake(lines)
+	if errors.Is(err, database.ErrNoStocktake) {
+		reloadStocktake(w, "/stocktake")
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Error applying the stocktake")
+		errs[""] = "Errore durante il salvataggio, riprova."
+		rt.renderStocktakeReview(w, r, stocktake, skipped, errs, ctx)
+		return
+	}
+	ctx.Logger.Infof("stocktake of %s applied, %d lots changed", stocktake.Location, changed)
+	rt.events.publish(stocktakeChanged)
+	reloadStocktake(w, "/stocktake?done="+strconv.Itoa(changed))
+}
+
+// reloadStocktake makes htmx load the stocktake page at `url`, once the stocktake is started or closed.
+func reloadStocktake(w http.ResponseWriter, url string) {
+	w.Header().Set("HX-Redirect", url)
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,157 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/lorenzougolini/wimf-app/service/api/reqcontext"
+	"github.com/lorenzougolini/wimf-app/service/models"
+	"github.com/lorenzougolini/wimf-app/service/templates"
+	"github.com/lorenzougolini/wimf-app/service/transfer"
+)
+
+// maxImportSize is the largest file accepted by the import page.
+const maxImportSize = 10 << 20
+
+// exportItems downloads every lot as a CSV (the default) or JSON file.
+func (rt *_router) exportItems(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = transfer.FormatCSV
+	}
+	if !slices.Contains(transfer.Formats, format) {
+		http.Error(w, "Unsupported format", http.StatusBadRequest)
+		return
+	}
+
+	items, err := rt.db.GetAllItems()
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Error retrieving the items to export")
+		http.Error(w, "Error retrieving the fridge", http.StatusInternalServerError)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err = transfer.Export(&buf, format, items); err != nil {
+		ctx.Logger.WithError(err).Error("Error exporting the items")
+		http.Error(w, "Error exporting the fridge", http.StatusInternalServerError)
+		return
+	}
+	if format == transfer.FormatCSV {
+		w.Header().Set("content-type", "text/csv; charset=utf-8")
+	} else {
+		w.Header().Set("content-type", "application/json")
+	}
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="wimf-%s.%s"`, time.Now().Format("2006-01-02"), format))
+	_, _ = w.Write(buf.Bytes())
+}
+
+func (rt *_router) getImportPage(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	if err := templates.Import().Render(r.Context(), w); err != nil {
+		ctx.Logger.WithError(err).Error("Error rendering the import page")
+	}
+}
+
+// previewImport validates an uploaded file and dry-runs its import, showing what would change.
+func (rt *_router) previewImport(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	if err := r.ParseMultipartForm(maxImportSize); err != nil {
+		renderImportError(w, r, ctx, "Il file è troppo grande o non è stato inviato correttamente.")
+		return
+	}
+	mode := importMode(r.FormValue("mode"))
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		renderImportError(w, r, ctx, "Scegli un file da importare.")
+		return
+	}
+	defer file.Close()
+
+	format := r.FormValue("format")
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(header.Filename)), ".")
+	}
+	if !slices.Contains(transfer.Formats, format) {
+		renderImportError(w, r, ctx, "Formato non riconosciuto: usa un file .csv o .json.")
+		return
+	}
+
+	lots, rowErrors, err := transfer.Import(file, format)
+	if err != nil {
+		renderImportError(w, r, ctx, "Il file non è valido: "+err.Error())
+		return
+	}
+
+	var result models.ImportResult
+	if len(rowErrors) == 0 {
+		result, err = rt.db.ImportItems(lots, mode, true)
+		if err != nil {
+			ctx.Logger.WithError(err).Error("Error previewing the import")
+			renderImportError(w, r, ctx, "Errore durante la verifica dell'importazione.")
+			return
+		}
+	}
+
+	// the confirmation posts the validated lots back, so the file doesn't have to be uploaded again
+	var data bytes.Buffer
+	if err = transfer.Export(&data, transfer.FormatJSON, lots); err != nil {
+		ctx.Logger.WithError(err).Error("Error encoding the import preview")
+		renderImportError(w, r, ctx, "Errore durante la verifica dell'importazione.")
+		return
+	}
+	err = templates.ImportPreview(lots, rowErrors, result, mode, data.String()).Render(r.Context(), w)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Error rendering the import preview")
+	}
+}
+
+// importItems stores the lots confirmed in the preview.
+func (rt *_router) importItems(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	if err := r.ParseForm(); err != nil {
+		renderImportError(w, r, ctx, "Richiesta non valida.")
+		return
+	}
+	mode := importMode(r.FormValue("mode"))
+
+	lots, rowErrors, err := transfer.Import(strings.NewReader(r.FormValue("data")), transfer.FormatJSON)
+	if err != nil || len(rowErrors) > 0 {
+		renderImportError(w, r, ctx, "I dati da importare non sono validi, ricarica il file.")
+		return
+	}
+
+	result, err := rt.audited(r).ImportItems(lots, mode, false)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Error importing items")
+		renderImportError(w, r, ctx, "Errore durante l'importazione, nessun prodotto è stato modificato.")
+		return
+	}
+	ctx.Logger.Infof("imported %d new, %d updated and %d removed items", result.Added, result.Updated, result.Removed)
+
+	if err = templates.ImportDone(result).Render(r.Context(), w); err != nil {
+		ctx.Logger.WithError(err).Error("Error rendering the import result")
+	}
+}
+
+// importMode returns `mode` if it's a valid import mode, models.ImportMerge otherwise.
+func importMode(mode string) string {
+	if mode == models.ImportReplace {
+		return mode
+	}
+	return models.ImportMerge
+}
+
+// renderImportError shows `message` in place of the import preview. The status stays 200, or HTMX wouldn't swap it.
+func renderImportError(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext, message string) {
+	if err := templates.ImportError(message).Render(r.Context(), w); err != nil {
+		ctx.Logger.WithError(err).Error("Error rendering the import error")
+	}
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/lorenzougolini/wimf-app/service/api/reqcontext"
+	"github.com/lorenzougolini/wimf-app/service/database"
+	"github.com/lorenzougolini/wimf-app/service/models"
+	"github.com/lorenzougolini/wimf-app/service/templates"
+)
+
+// undoWindow is how long the undo toast is shown after deleting a lot.
+const undoWindow = 10 * time.Second
+
+// trashPurgeInterval is how often the lots older than the retention period are removed from the trash.
+const trashPurgeInterval = time.Hour
+
+func (rt *_router) getTrash(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	items, err := rt.db.GetTrash()
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Error retrieving the trash")
+		http.Error(w, "Error retrieving the trash", http.StatusInternalServerError)
+		return
+	}
+	if err = templates.Trash(items, rt.trashRetention).Render(r.Context(), w); err != nil {
+		ctx.Logger.WithError(err).Error("Error rendering the trash")
+	}
+}
+
+// restoreItem brings a lot back from the trash, from the undo toast or the trash page.
+func (rt *_router) restoreItem(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	id := r.URL.Query().Get("id")
+	err := rt.audited(r).RestoreItem(id)
+	if errors.Is(err, database.ErrNotInTrash) {
+		http.Error(w, "Item not in the trash", http.StatusNotFound)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Error restoring item")
+		http.Error(w, "Error restoring item", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// purgeTrash periodically removes the lots that have been in the trash for longer than the retention period, until
+// Close is called.
+func (rt *_router) purgeTrash() {
+	defer rt.background.Done()
+
+	db := rt.db.WithSource(models.AuditSource{Endpoint: "trash purge"})
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+	for {
+		purged, err := db.PurgeTrash(time.Now().Add(-rt.trashRetention))
+		if err != nil {
+			rt.baseLogger.WithError(err).Error("error purging the trash")
+		} else if purged > 0 {
+			rt.baseLogger.Infof("purged %d items from the trash", purged)
+		}
+
+		select {
+		case <-rt.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
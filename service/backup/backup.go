@@ -0,0 +1,190 @@
+/*
+Package backup writes a daily snapshot of the app database to a directory, keeping only the most recent ones.
+
+The Scheduler checks every hour if the backup of the current day exists, so a server restarted during the day doesn't
+write a second one and a server that was down at midnight catches up as soon as it starts. Backups are named
+`auto-wimf-YYYY-MM-DD.db`, and only they are rotated: everything else in the directory is left alone, like the
+snapshots of `wimfctl backup` (`wimf-<date>-<time>.db`).
+*/
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lorenzougolini/wimf-app/service/database"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	filePrefix    = "auto-wimf-"
+	fileSuffix    = ".db"
+	checkInterval = time.Hour
+)
+
+// Config is used to provide dependencies and configuration to the New function.
+type Config struct {
+	Logger   logrus.FieldLogger
+	Database database.AppDatabase
+
+	// Dir is where the backups are written, created if missing
+	Dir string
+	// Keep is how many daily backups are kept, older ones are removed
+	Keep int
+}
+
+// Status is the outcome of the backups, reported by the liveness endpoint.
+type Status struct {
+	LastSuccess time.Time `json:"last_success"`
+	LastFile    string    `json:"last_file,omitempty"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// Scheduler writes the daily backups in background until closed.
+type Scheduler struct {
+	cfg Config
+
+	mu     sync.Mutex
+	status Status
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New checks the configuration, creates the backup directory and starts the scheduler.
+func New(cfg Config) (*Scheduler, error) {
+	if cfg.Logger == nil {
+		return nil, errors.New("logger is required")
+	}
+	if cfg.Database == nil {
+		return nil, errors.New("database is required")
+	}
+	if cfg.Dir == "" {
+		return nil, errors.New("backup directory is required")
+	}
+	if cfg.Keep < 1 {
+		return nil, errors.New("at least one backup must be kept")
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o750); err != nil {
+		return nil, fmt.Errorf("creating the backup directory: %w", err)
+	}
+
+	s := &Scheduler{
+		cfg:  cfg,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	if last, err := s.backups(); err == nil && len(last) > 0 {
+		name := last[len(last)-1]
+		if info, err := os.Stat(filepath.Join(cfg.Dir, name)); err == nil {
+			s.status = Status{LastSuccess: info.ModTime(), LastFile: name}
+		}
+	}
+
+	go s.run()
+	return s, nil
+}
+
+// Status returns the outcome of the last backups.
+func (s *Scheduler) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+// Close stops the scheduler, waiting for a running backup to finish.
+func (s *Scheduler) Close() error {
+	close(s.stop)
+	<-s.done
+	return nil
+}
+
+func (s *Scheduler) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		if err := s.daily(time.Now()); err != nil {
+			s.cfg.Logger.WithError(err).Error("error writing the daily backup")
+			s.mu.Lock()
+			s.status.LastError = err.Error()
+			s.mu.Unlock()
+		}
+
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// daily writes the backup of the day of `now` if missing, then removes the backups exceeding Config.Keep.
+func (s *Scheduler) daily(now time.Time) error {
+	name := filePrefix + now.Format("2006-01-02") + fileSuffix
+	path := filepath.Join(s.cfg.Dir, name)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	// VACUUM INTO refuses to overwrite a file, and a half-written backup must never look like a good one
+	tmp := path + ".tmp"
+	_ = os.Remove(tmp)
+	if err := s.cfg.Database.Backup(tmp); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("renaming backup %s: %w", tmp, err)
+	}
+
+	s.mu.Lock()
+	s.status = Status{LastSuccess: now, LastFile: name}
+	s.mu.Unlock()
+	s.cfg.Logger.Infof("database backup written to %s", path)
+
+	return s.rotate()
+}
+
+// rotate removes the oldest backups, keeping Config.Keep of them.
+func (s *Scheduler) rotate() error {
+	names, err := s.backups()
+	if err != nil {
+		return err
+	}
+	for len(names) > s.cfg.Keep {
+		if err := os.Remove(filepath.Join(s.cfg.Dir, names[0])); err != nil {
+			return fmt.Errorf("removing old backup: %w", err)
+		}
+		names = names[1:]
+	}
+	return nil
+}
+
+// backups lists the names of the daily backups in the directory, oldest first.
+func (s *Scheduler) backups() ([]string, error) {
+	entries, err := os.ReadDir(s.cfg.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("listing backups: %w", err)
+	}
+	var names []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, filePrefix) || !strings.HasSuffix(name, fileSuffix) {
+			continue
+		}
+		date := strings.TrimSuffix(strings.TrimPrefix(name, filePrefix), fileSuffix)
+		if _, err := time.Parse("2006-01-02", date); err == nil {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
@@ -0,0 +1,64 @@
+package backup
+
+import (
+	"database/sql"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/lorenzougolini/wimf-app/service/database"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/sirupsen/logrus"
+)
+
+// TestRotateKeepsOtherFiles writes the daily backups of several days: only the oldest of them are removed, while the
+// snapshots of wimfctl and the other files in the directory are left alone.
+func TestRotateKeepsOtherFiles(t *testing.T) {
+	dir := t.TempDir()
+	conn, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "fridge.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	db, err := database.New(conn, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	others := []string{"notes.txt", "wimf-2026-01-01.db", "wimf-2026-01-01-093000.db", "auto-wimf-before-update.db"}
+	for _, name := range others {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := &Scheduler{cfg: Config{Logger: logger, Database: db, Dir: dir, Keep: 2}}
+	day := time.Date(2026, 1, 1, 3, 0, 0, 0, time.UTC)
+	for i := range 4 {
+		if err := s.daily(day.AddDate(0, 0, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	want := append([]string{"auto-wimf-2026-01-03.db", "auto-wimf-2026-01-04.db"}, others...)
+	slices.Sort(want)
+	if !slices.Equal(names, want) {
+		t.Errorf("files = %v, want %v", names, want)
+	}
+	if status := s.Status(); status.LastFile != "auto-wimf-2026-01-04.db" || status.LastError != "" {
+		t.Errorf("status = %+v, want the last backup", status)
+	}
+}
@@ -0,0 +1,264 @@
+/*
+Package client is a typed client of the JSON API under /api/v1, described by the OpenAPI document served at
+/api/openapi.yaml. Scripts and the CLI should use it instead of building HTTP requests by hand.
+
+Example:
+
+	c := client.New("http://localhost:3001")
+	lot, err := c.CreateLot(client.LotRequest{
+		Barcode:        "8001234567890",
+		Name:           "Yogurt",
+		ExpirationDate: "2026-11-01",
+	})
+
+Errors replied by the server are returned as *Error, carrying the status and the invalid fields.
+*/
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lorenzougolini/wimf-app/service/models"
+)
+
+type Client struct {
+	httpClient *http.Client
+	baseURL    string
+}
+
+// New returns a client of the server at `baseURL`, e.g. http://localhost:3001.
+func New(baseURL string) *Client {
+	return &Client{
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		baseURL:    strings.TrimSuffix(baseURL, "/") + "/api/v1",
+	}
+}
+
+// Error is an error replied by the server.
+type Error struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+	// Fields tells what's wrong with each invalid field of the request, when Status is 422
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+func (e *Error) Error() string {
+	if len(e.Fields) == 0 {
+		return fmt.Sprintf("%d: %s", e.Status, e.Message)
+	}
+	fields := make([]string, 0, len(e.Fields))
+	for _, field := range slices.Sorted(maps.Keys(e.Fields)) {
+		fields = append(fields, field+" "+e.Fields[field])
+	}
+	return fmt.Sprintf("%d: %s (%s)", e.Status, e.Message, strings.Join(fields, ", "))
+}
+
+// IsNotFound reports if `err` is the server replying that the requested product or lot doesn't exist.
+func IsNotFound(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Status == http.StatusNotFound
+}
+
+type Location struct {
+	Name    string `json:"name"`
+	Default bool   `json:"default"`
+}
+
+// Product is a product in stock, with the totals of its lots. Amount sums the measured lots in Unit, grams or
+// millilitres, and is zero when there are none. Lots are only set by GetProduct and UpdateProduct.
+type Product struct {
+	Barcode        string        `json:"barcode"`
+	Name           string        `json:"name"`
+	Brand          string        `json:"brand"`
+	Category       string        `json:"category"`
+	Tags           []string      `json:"tags"`
+	Quantity       int           `json:"quantity"`
+	Amount         float64       `json:"amount,omitempty"`
+	Unit           string        `json:"unit,omitempty"`
+	NextExpiration *time.Time    `json:"next_expiration"`
+	Lots           []models.Item `json:"lots,omitempty"`
+}
+
+type ProductPage struct {
+	Products []Product `json:"products"`
+	// Next is the cursor of the next page, empty on the last one
+	Next string `json:"next"`
+}
+
+// LotRequest is a new lot. Dates are YYYY-MM-DD or RFC 3339 timestamps; Quantity defaults to 1, AdditionDate to now
+// and Location to the default location. Measured lots have a Unit and the PackageAmount of a package in it. The units
+// are added to an identical lot in stock unless Separate is set.
+type LotRequest struct {
+	Barcode        string  `json:"barcode"`
+	Name           string  `json:"name"`
+	Brand          string  `json:"brand,omitempty"`
+	Quantity       int     `json:"quantity,omitempty"`
+	Unit           string  `json:"unit,omitempty"`
+	PackageAmount  float64 `json:"package_amount,omitempty"`
+	ExpirationDate string  `json:"expiration_date"`
+	AdditionDate   string  `json:"added_at,omitempty"`
+	Location       string  `json:"location,omitempty"`
+	Note           string  `json:"note,omitempty"`
+	Label          string  `json:"label,omitempty"`
+	Separate       bool    `json:"separate,omitempty"`
+}
+
+// LotUpdate changes the fields of a lot that are not nil.
+type LotUpdate struct {
+	Name           *string `json:"name,omitempty"`
+	Brand          *string `json:"brand,omitempty"`
+	Quantity       *int    `json:"quantity,omitempty"`
+	ExpirationDate *string `json:"expiration_date,omitempty"`
+	AdditionDate   *string `json:"added_at,omitempty"`
+	Location       *string `json:"location,omitempty"`
+	Note           *string `json:"note,omitempty"`
+	Label          *string `json:"label,omitempty"`
+}
+
+// LotSplit moves Quantity units of a lot to a new lot; the other fields are copied from the split lot when empty.
+type LotSplit struct {
+	Quantity       int    `json:"quantity"`
+	ExpirationDate string `json:"expiration_date,omitempty"`
+	Location       string `json:"location,omitempty"`
+	Note           string `json:"note,omitempty"`
+	Label          string `json:"label,omitempty"`
+}
+
+func (c *Client) ListLocations() ([]Location, error) {
+	var locations []Location
+	return locations, c.do(http.MethodGet, "/locations", nil, &locations)
+}
+
+// ListProducts returns a page of the products matching `filter`: `cursor` is empty for the first page, and then the
+// Next cursor of the previous one. A `limit` of zero uses the largest page size.
+func (c *Client) ListProducts(filter models.FridgeFilter, cursor string, limit int) (ProductPage, error) {
+	query := filter.Values()
+	if cursor != "" {
+		query.Set("cursor", cursor)
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	var page ProductPage
+	return page, c.do(http.MethodGet, "/products?"+query.Encode(), nil, &page)
+}
+
+// AllProducts returns every product matching `filter`, reading all the pages.
+func (c *Client) AllProducts(filter models.FridgeFilter) ([]Product, error) {
+	var products []Product
+	cursor := ""
+	for {
+		page, err := c.ListProducts(filter, cursor, 0)
+		if err != nil {
+			return nil, err
+		}
+		products = append(products, page.Products...)
+		if page.Next == "" {
+			return products, nil
+		}
+		cursor = page.Next
+	}
+}
+
+func (c *Client) GetProduct(barcode string) (Product, error) {
+	var product Product
+	return product, c.do(http.MethodGet, "/products/"+url.PathEscape(barcode), nil, &product)
+}
+
+// UpdateProduct sets the category and the tags of a product.
+func (c *Client) UpdateProduct(barcode string, category string, tags []string) (Product, error) {
+	body := struct {
+		Category string   `json:"category"`
+		Tags     []string `json:"tags"`
+	}{category, tags}
+	var product Product
+	return product, c.do(http.MethodPut, "/products/"+url.PathEscape(barcode), body, &product)
+}
+
+// ListLots returns all the lots, or only those of the product `barcode` if it's not empty.
+func (c *Client) ListLots(barcode string) ([]models.Item, error) {
+	path := "/lots"
+	if barcode != "" {
+		path += "?" + url.Values{"barcode": {barcode}}.Encode()
+	}
+	var lots []models.Item
+	return lots, c.do(http.MethodGet, path, nil, &lots)
+}
+
+func (c *Client) GetLot(id string) (models.Item, error) {
+	var lot models.Item
+	return lot, c.do(http.MethodGet, "/lots/"+url.PathEscape(id), nil, &lot)
+}
+
+func (c *Client) CreateLot(lot LotRequest) (models.Item, error) {
+	var created models.Item
+	return created, c.do(http.MethodPost, "/lots", lot, &created)
+}
+
+func (c *Client) UpdateLot(id string, update LotUpdate) (models.Item, error) {
+	var lot models.Item
+	return lot, c.do(http.MethodPut, "/lots/"+url.PathEscape(id), update, &lot)
+}
+
+// SplitLot moves some units of the lot `id` to a new lot, and returns the new one.
+func (c *Client) SplitLot(id string, split LotSplit) (models.Item, error) {
+	var lot models.Item
+	return lot, c.do(http.MethodPost, "/lots/"+url.PathEscape(id)+"/split", split, &lot)
+}
+
+// DeleteLot moves a lot to the trash.
+func (c *Client) DeleteLot(id string) error {
+	return c.do(http.MethodDelete, "/lots/"+url.PathEscape(id), nil, nil)
+}
+
+// do sends a request with `body` encoded as JSON (if not nil), decoding the reply in `reply` (if not nil).
+func (c *Client) do(method string, path string, body any, reply any) error {
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, c.baseURL+path, reqBody)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("content-type", "application/json")
+	}
+	req.Header.Set("accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		apiErr := &Error{Status: resp.StatusCode}
+		if err := json.NewDecoder(resp.Body).Decode(apiErr); err != nil || apiErr.Message == "" {
+			apiErr.Message = http.StatusText(resp.StatusCode)
+		}
+		return apiErr
+	}
+	if reply == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(reply); err != nil {
+		return fmt.Errorf("invalid reply from %s %s: %w", method, path, err)
+	}
+	return nil
+}
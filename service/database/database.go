@@ -36,34 +36,97 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/lorenzougolini/wimf-app/service/events"
 	"github.com/lorenzougolini/wimf-app/service/models"
 )
 
 // AppDatabase is the high level interface for the DB
 type AppDatabase interface {
 	CheckIdExistence(barcode string) (bool, error)
-	AddItem(productInfo models.ProductInfo, expiration time.Time, addition time.Time) error
+	AddItem(productInfo models.ProductInfo, lot models.Item, separate bool) (string, error)
 	GetItemsByBarcode(barcode string) (bool, []models.Item, error)
 	GetItemById(id string) (models.Item, error)
 	GetNItemsBy(limit int, orderBy string) ([]models.Item, error)
 
-	GetFridge() ([]models.Item, error)
+	GetFridge(filter models.FridgeFilter, cursor string, limit int) ([]models.Item, string, error)
+	GetCategorySummary(filter models.FridgeFilter) ([]models.CategorySummary, error)
+
+	Search(query string, limit int) ([]models.SearchHit, error)
+
+	GetProduct(barcode string) (models.Product, error)
+	GetProductById(id int64) (models.Product, error)
+	UpdateProduct(product models.Product) error
 
 	DeleteItem(id string) error
-	UpdateItem(id string, name string, brand string, date time.Time) error
+	RestoreItem(id string) error
+	GetTrash() ([]models.Item, error)
+	PurgeTrash(before time.Time) (int64, error)
+	UpdateItem(item models.Item) error
+	SplitItem(id string, part models.Item) (string, error)
+
+	AdjustItemQuantity(id string, delta int) (models.Item, error)
+	ConsumeByBarcode(barcode string, quantity int) ([]models.Item, error)
+	ConsumeItem(id string, quantity int) (models.Item, int64, error)
+	ConsumeAmount(id string, amount float64, unit string) (models.Item, error)
+	UndoConsume(id string, entry int64) error
+
+	GetAllItems() ([]models.Item, error)
+	ImportItems(lots []models.Item, mode string, dryRun bool) (models.ImportResult, error)
+	MergeDuplicateLots(dryRun bool) (int, error)
+	CookItems(leftover models.ProductInfo, ingredients []models.Ingredient, expiration time.Time) (string, error)
+	GetItemSources(id string) ([]models.ItemSource, error)
+
+	GetConsumptionStats(since time.Time) (map[string]models.ConsumptionStats, error)
+
+	// The bulk add draft, a row per barcode scanned after the groceries
+	ScanDraftItem(barcode string) (bool, error)
+	GetDraft() ([]models.DraftItem, error)
+	GetDraftItem(id string) (models.DraftItem, error)
+	ResolveDraftItem(id string, product models.ProductInfo, status string) error
+	UpdateDraftItem(item models.DraftItem) error
+	SetDraftExpirations(dates map[string]time.Time) error
+	DeleteDraftItem(id string) error
+	ClearDraft() error
+	CommitDraft() (int, error)
+
+	// The stocktake of a location, counting its lots to reconcile them with the database
+	StartStocktake(location string) error
+	GetStocktake() (models.Stocktake, error)
+	CountStocktake(barcode string) (models.StocktakeLine, error)
+	SetStocktakeCount(id string, counted int) error
+	CancelStocktake() error
+	ApplyStocktake(lines []models.StocktakeLine) (int, error)
 
-	IncreaseItemQuantity(barcode string, quantity int) error
+	// NotifyExpired publishes ItemExpired for the lots in stock expired in [since, until)
+	NotifyExpired(since, until time.Time) (int, error)
+
+	// WithSource returns a copy of the database recording `source` in the audit log of its changes
+	WithSource(source models.AuditSource) AppDatabase
+	GetAudit(filter models.AuditFilter, before int64, limit int) ([]models.AuditEntry, error)
+
+	// Backup writes a consistent snapshot of the database to the new file `path`
+	Backup(path string) error
 
 	Ping() error
 }
 
 type appdbimpl struct {
 	c *sql.DB
+
+	// fts is set when the full-text search index is available
+	fts bool
+
+	// source is recorded in the audit log of every change
+	source models.AuditSource
+
+	// events publishes the committed changes, nil when nobody listens
+	events events.Publisher
 }
 
-// New returns a new instance of AppDatabase based on the SQLite connection `db`.
+// New returns a new instance of AppDatabase based on the SQLite connection `db`, publishing its changes on `publisher`
+// (optional, nil to publish nothing).
 // `db` is required - an error will be returned if `db` is `nil`.
-func New(db *sql.DB) (AppDatabase, error) {
+func New(db *sql.DB, publisher events.Publisher) (AppDatabase, error) {
 	if db == nil {
 		return nil, errors.New("database is required when building a AppDatabase")
 	}
@@ -90,11 +153,197 @@ func New(db *sql.DB) (AppDatabase, error) {
 		}
 	}
 
+	if err := migrate(db); err != nil {
+		return nil, err
+	}
+	fts, err := setupSearch(db)
+	if err != nil {
+		return nil, err
+	}
+
 	return &appdbimpl{
-		c: db,
+		c:      db,
+		fts:    fts,
+		events: publisher,
 	}, nil
 }
 
+// migrations are the schema changes applied on top of the base items table. The position of a statement in the slice
+// (plus one) is the schema version it brings the database to, tracked in SQLite's `user_version` pragma.
+// Append only: never edit or reorder a statement that has already been released.
+var migrations = []string{
+	// 1: lineage of leftovers created by cooking other lots
+	`CREATE TABLE IF NOT EXISTS item_sources (
+		item_id TEXT NOT NULL,
+		source_id TEXT NOT NULL,
+		barcode TEXT NOT NULL,
+		name TEXT NOT NULL,
+		brand TEXT NOT NULL,
+		quantity INTEGER NOT NULL,
+		PRIMARY KEY (item_id, source_id)
+	);`,
+	// 2: where each lot is stored
+	`ALTER TABLE items ADD COLUMN location TEXT NOT NULL DEFAULT 'Frigo';`,
+	// 3: history of additions and consumptions, seeded with the lots already stored
+	`CREATE TABLE IF NOT EXISTS movements (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		item_id TEXT NOT NULL,
+		barcode TEXT NOT NULL,
+		delta INTEGER NOT NULL,
+		moved_at TEXT NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS movements_barcode ON movements (barcode, moved_at);
+	INSERT INTO movements (item_id, barcode, delta, moved_at)
+	SELECT id, barcode, quantity, COALESCE(added_at, CURRENT_TIMESTAMP) FROM items;`,
+	// 4: product categories and tags
+	`CREATE TABLE IF NOT EXISTS products (
+		barcode TEXT NOT NULL PRIMARY KEY,
+		category TEXT NOT NULL DEFAULT ''
+	);
+	CREATE TABLE IF NOT EXISTS product_tags (
+		barcode TEXT NOT NULL,
+		tag TEXT NOT NULL,
+		PRIMARY KEY (barcode, tag)
+	);
+	CREATE INDEX IF NOT EXISTS product_tags_tag ON product_tags (tag);
+	INSERT OR IGNORE INTO products (barcode) SELECT DISTINCT barcode FROM items;
+	UPDATE products SET category = 'leftovers' WHERE barcode LIKE 'LO-%';`,
+	// 5: free-text note and colour label of each lot
+	`ALTER TABLE items ADD COLUMN note TEXT NOT NULL DEFAULT '';
+	ALTER TABLE items ADD COLUMN label TEXT NOT NULL DEFAULT '';`,
+	// 6: soft delete, lots stay in the trash until purged
+	`ALTER TABLE items ADD COLUMN deleted_at TEXT;
+	CREATE INDEX IF NOT EXISTS items_deleted_at ON items (deleted_at);`,
+	// 7: append-only audit log of the inventory changes
+	`CREATE TABLE IF NOT EXISTS audit_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		at TEXT NOT NULL,
+		actor TEXT NOT NULL DEFAULT '',
+		endpoint TEXT NOT NULL DEFAULT '',
+		action TEXT NOT NULL,
+		entity TEXT NOT NULL,
+		entity_id TEXT NOT NULL,
+		barcode TEXT NOT NULL DEFAULT '',
+		name TEXT NOT NULL DEFAULT '',
+		before TEXT,
+		after TEXT
+	);
+	CREATE INDEX IF NOT EXISTS audit_log_at ON audit_log (at);
+	CREATE INDEX IF NOT EXISTS audit_log_barcode ON audit_log (barcode);
+	CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+	BEGIN
+		SELECT RAISE(ABORT, 'the audit log is append-only');
+	END;
+	CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+	BEGIN
+		SELECT RAISE(ABORT, 'the audit log is append-only');
+	END;`,
+	// 8: stable numeric ids of the products (a VACUUM may renumber implicit rowids)
+	`CREATE TABLE products_new (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		barcode TEXT NOT NULL UNIQUE,
+		category TEXT NOT NULL DEFAULT ''
+	);
+	INSERT INTO products_new (barcode, category) SELECT barcode, category FROM products ORDER BY rowid;
+	DROP TABLE products;
+	ALTER TABLE products_new RENAME TO products;`,
+	// 9: draft of the bulk add session, a row per scanned barcode
+	`CREATE TABLE IF NOT EXISTS draft_items (
+		id TEXT NOT NULL PRIMARY KEY,
+		barcode TEXT NOT NULL UNIQUE,
+		name TEXT NOT NULL DEFAULT '',
+		brand TEXT NOT NULL DEFAULT '',
+		category TEXT NOT NULL DEFAULT '',
+		quantity INTEGER NOT NULL DEFAULT 1,
+		expiration_date TEXT,
+		location TEXT NOT NULL DEFAULT 'Frigo',
+		status TEXT NOT NULL DEFAULT 'pending',
+		scanned_at TEXT NOT NULL
+	);`,
+	// 10: stocktake in progress, with the snapshot of the lots of its location and the counted units
+	`CREATE TABLE IF NOT EXISTS stocktake (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		location TEXT NOT NULL,
+		started_at TEXT NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS stocktake_lines (
+		id TEXT NOT NULL PRIMARY KEY,
+		item_id TEXT,
+		barcode TEXT NOT NULL,
+		name TEXT NOT NULL DEFAULT '',
+		brand TEXT NOT NULL DEFAULT '',
+		expiration_date TEXT,
+		expected INTEGER NOT NULL DEFAULT 0,
+		counted INTEGER NOT NULL DEFAULT 0
+	);`,
+	// 11: measured lots, with their unit, the amount left and the amount of a full package
+	`ALTER TABLE items ADD COLUMN unit TEXT NOT NULL DEFAULT '';
+	ALTER TABLE items ADD COLUMN amount REAL NOT NULL DEFAULT 0;
+	ALTER TABLE items ADD COLUMN package_amount REAL NOT NULL DEFAULT 0;`,
+	// 12: draft rows of the same barcode with different dates or locations, and the package size of measured products
+	`CREATE TABLE draft_items_new (
+		id TEXT NOT NULL PRIMARY KEY,
+		barcode TEXT NOT NULL,
+		name TEXT NOT NULL DEFAULT '',
+		brand TEXT NOT NULL DEFAULT '',
+		category TEXT NOT NULL DEFAULT '',
+		quantity INTEGER NOT NULL DEFAULT 1,
+		unit TEXT NOT NULL DEFAULT '',
+		package_amount REAL NOT NULL DEFAULT 0,
+		expiration_date TEXT,
+		location TEXT NOT NULL DEFAULT 'Frigo',
+		status TEXT NOT NULL DEFAULT 'pending',
+		scanned_at TEXT NOT NULL
+	);
+	INSERT INTO draft_items_new (id, barcode, name, brand, category, quantity, expiration_date, location, status,
+		scanned_at)
+	SELECT id, barcode, name, brand, category, quantity, expiration_date, location, status, scanned_at
+	FROM draft_items;
+	DROP TABLE draft_items;
+	ALTER TABLE draft_items_new RENAME TO draft_items;
+	CREATE INDEX IF NOT EXISTS draft_items_barcode ON draft_items (barcode);`,
+	// 13: kind of each movement, so that only the consumptions count as consumed. Of the older ones, the lots still in
+	// the trash are known to be discarded; the other decreases can't be told apart and stay consumptions.
+	`ALTER TABLE movements ADD COLUMN kind TEXT NOT NULL DEFAULT '';
+	UPDATE movements SET kind = CASE
+		WHEN delta > 0 THEN 'add'
+		WHEN EXISTS (SELECT 1 FROM items WHERE items.id = movements.item_id AND items.deleted_at = movements.moved_at)
+			THEN 'discard'
+		ELSE 'consume'
+	END;`,
+	// 14: amount of the measured lots used to cook a leftover
+	`ALTER TABLE item_sources ADD COLUMN amount REAL NOT NULL DEFAULT 0;
+	ALTER TABLE item_sources ADD COLUMN unit TEXT NOT NULL DEFAULT '';`,
+}
+
+// migrate brings the schema to the latest version, applying each missing migration in its own transaction.
+func migrate(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version;`).Scan(&version); err != nil {
+		return fmt.Errorf("error reading schema version: %w", err)
+	}
+
+	for ; version < len(migrations); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("error starting migration %d: %w", version+1, err)
+		}
+		if _, err := tx.Exec(migrations[version]); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("error applying migration %d: %w", version+1, err)
+		}
+		// PRAGMA does not accept bound parameters
+		if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d;`, version+1)); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("error updating schema version to %d: %w", version+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("error committing migration %d: %w", version+1, err)
+		}
+	}
+	return nil
+}
+
 func (db *appdbimpl) Ping() error {
 	return db.c.Ping()
 }
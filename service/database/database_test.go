@@ -0,0 +1,24 @@
+package database
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// newTestDB returns an empty database in a temporary file, closed at the end of the test.
+func newTestDB(t *testing.T) AppDatabase {
+	t.Helper()
+	conn, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "fridge.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	db, err := New(conn, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
@@ -0,0 +1,209 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lorenzougolini/wimf-app/service/events"
+	"github.com/lorenzougolini/wimf-app/service/models"
+)
+
+// rowQuerier is implemented by both *sql.DB and *sql.Tx.
+type rowQuerier interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// lotState is the audited state of a lot, stored as JSON in the before/after columns of the audit log.
+type lotState struct {
+	Name           string  `json:"name"`
+	Brand          string  `json:"brand"`
+	Quantity       int     `json:"quantity"`
+	Unit           string  `json:"unit,omitempty"`
+	Amount         float64 `json:"amount,omitempty"`
+	PackageAmount  float64 `json:"package_amount,omitempty"`
+	ExpirationDate string  `json:"expiration_date"`
+	AdditionDate   string  `json:"added_at"`
+	Location       string  `json:"location"`
+	Note           string  `json:"note,omitempty"`
+	Label          string  `json:"label,omitempty"`
+	DeletedAt      string  `json:"deleted_at,omitempty"`
+}
+
+// productState is the audited state of a product.
+type productState struct {
+	Category string   `json:"category"`
+	Tags     []string `json:"tags"`
+}
+
+func (db *appdbimpl) WithSource(source models.AuditSource) AppDatabase {
+	c := *db
+	c.source = source
+	return &c
+}
+
+// snapshotLot reads the lot `id`, in the trash or not, to audit its changes. It returns nil if the lot doesn't exist.
+func snapshotLot(q rowQuerier, id string) (*models.Item, error) {
+	var lot lotScan
+	var deletedAt sql.NullString
+	err := q.QueryRow(`SELECT `+lotColumns+`, deleted_at FROM items WHERE id=?;`, id).
+		Scan(append(lot.dest(), &deletedAt)...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading item %s: %w", id, err)
+	}
+	item := lot.item()
+	if deletedAt.Valid {
+		item.DeletedAt, _ = time.Parse(models.DbTimeLayout, deletedAt.String)
+	}
+	return &item, nil
+}
+
+func stateOfLot(item *models.Item) any {
+	if item == nil {
+		return nil
+	}
+	s := lotState{
+		Name:           item.Name,
+		Brand:          item.Brand,
+		Quantity:       item.Quantity,
+		Unit:           item.Unit,
+		Amount:         item.Amount,
+		PackageAmount:  item.PackageAmount,
+		ExpirationDate: item.ExpirationDate.Format(models.DbTimeLayout),
+		AdditionDate:   item.AdditionDate.Format(models.DbTimeLayout),
+		Location:       item.Location,
+		Note:           item.Note,
+		Label:          item.Label,
+	}
+	if !item.DeletedAt.IsZero() {
+		s.DeletedAt = item.DeletedAt.Format(models.DbTimeLayout)
+	}
+	return s
+}
+
+// auditLot records a change of a lot, and queues its event on `tx`; `before` or `after` is nil when the lot didn't exist
+// before or after it.
+func (db *appdbimpl) auditLot(tx *changeTx, action string, before, after *models.Item) error {
+	lot := after
+	if lot == nil {
+		lot = before
+	}
+	if lot == nil {
+		return nil
+	}
+	if event := db.lotEvent(action, before, after); event != nil {
+		tx.pending = append(tx.pending, event)
+	}
+	entry := models.AuditEntry{
+		Action:   action,
+		Entity:   models.AuditItem,
+		EntityId: lot.Id.String(),
+		Barcode:  lot.Barcode,
+		Name:     lot.Name,
+	}
+	return db.audit(tx, entry, stateOfLot(before), stateOfLot(after))
+}
+
+// auditProduct records a change of the category or tags of a product, named `name` in the log, and queues its event on
+// `tx`.
+func (db *appdbimpl) auditProduct(tx *changeTx, name string, before, after models.Product) error {
+	tx.pending = append(tx.pending, events.ProductUpdated{Source: db.source, Before: before, After: after})
+	entry := models.AuditEntry{
+		Action:   models.AuditUpdate,
+		Entity:   models.AuditProduct,
+		EntityId: after.Barcode,
+		Barcode:  after.Barcode,
+		Name:     name,
+	}
+	return db.audit(tx, entry,
+		productState{Category: before.Category, Tags: before.Tags},
+		productState{Category: after.Category, Tags: after.Tags})
+}
+
+// audit appends `entry` to the audit log with the source of the database, within the transaction of the change.
+func (db *appdbimpl) audit(e execer, entry models.AuditEntry, before, after any) error {
+	encode := func(state any) (sql.NullString, error) {
+		if state == nil {
+			return sql.NullString{}, nil
+		}
+		b, err := json.Marshal(state)
+		return sql.NullString{String: string(b), Valid: true}, err
+	}
+	b, err := encode(before)
+	if err != nil {
+		return err
+	}
+	a, err := encode(after)
+	if err != nil {
+		return err
+	}
+
+	_, err = e.Exec(`
+		INSERT INTO audit_log (at, actor, endpoint, action, entity, entity_id, barcode, name, before, after)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`,
+		time.Now().Format(models.DbTimeLayout), db.source.Actor, db.source.Endpoint,
+		entry.Action, entry.Entity, entry.EntityId, entry.Barcode, entry.Name, b, a)
+	if err != nil {
+		return fmt.Errorf("error auditing %s of %s: %w", entry.Action, entry.EntityId, err)
+	}
+	return nil
+}
+
+// GetAudit returns the audit entries matching `filter` older than the entry `before` (all when zero), newest first.
+func (db *appdbimpl) GetAudit(filter models.AuditFilter, before int64, limit int) ([]models.AuditEntry, error) {
+	conditions := []string{"1=1"}
+	var args []any
+	if before > 0 {
+		conditions = append(conditions, "id < ?")
+		args = append(args, before)
+	}
+	if filter.Action != "" {
+		conditions = append(conditions, "action = ?")
+		args = append(args, filter.Action)
+	}
+	if filter.Query != "" {
+		like := "%" + filter.Query + "%"
+		conditions = append(conditions, "(barcode LIKE ? OR name LIKE ?)")
+		args = append(args, like, like)
+	}
+	if !filter.From.IsZero() {
+		conditions = append(conditions, "at >= ?")
+		args = append(args, filter.From.Format(models.DbTimeLayout))
+	}
+	if !filter.To.IsZero() {
+		conditions = append(conditions, "at < ?")
+		args = append(args, filter.To.AddDate(0, 0, 1).Format(models.DbTimeLayout))
+	}
+	args = append(args, limit)
+
+	rows, err := db.c.Query(`
+		SELECT id, at, actor, endpoint, action, entity, entity_id, barcode, name,
+			COALESCE(before, ''), COALESCE(after, '')
+		FROM audit_log
+		WHERE `+strings.Join(conditions, " AND ")+`
+		ORDER BY id DESC
+		LIMIT ?;`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []models.AuditEntry
+	for rows.Next() {
+		var e models.AuditEntry
+		var at string
+		err := rows.Scan(&e.Id, &at, &e.Actor, &e.Endpoint, &e.Action, &e.Entity, &e.EntityId, &e.Barcode, &e.Name,
+			&e.Before, &e.After)
+		if err != nil {
+			return nil, err
+		}
+		e.At, _ = time.Parse(models.DbTimeLayout, at)
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
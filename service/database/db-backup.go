@@ -0,0 +1,83 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+func (db *appdbimpl) Backup(path string) error {
+	return Backup(db.c, path)
+}
+
+// Backup writes a consistent snapshot of the database `conn` to the new file `path` with VACUUM INTO. It's safe to run
+// while the web API is using the same database.
+func Backup(conn *sql.DB, path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("backup file %s already exists", path)
+	}
+	if _, err := conn.Exec(`VACUUM INTO ?;`, path); err != nil {
+		return fmt.Errorf("error writing backup %s: %w", path, err)
+	}
+	return nil
+}
+
+// Restore replaces the content of the database `conn` with the backup file `path`, using the SQLite online backup API
+// so that the copy is atomic for the other connections. The backup is checked for integrity first; New migrates the
+// restored database to the current schema.
+func Restore(conn *sql.DB, path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("can't read backup %s: %w", path, err)
+	}
+	src, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return fmt.Errorf("error opening backup %s: %w", path, err)
+	}
+	defer func() { _ = src.Close() }()
+
+	var check string
+	if err := src.QueryRow(`PRAGMA integrity_check;`).Scan(&check); err != nil {
+		return fmt.Errorf("error checking backup %s: %w", path, err)
+	} else if check != "ok" {
+		return fmt.Errorf("backup %s is corrupted: %s", path, check)
+	}
+
+	ctx := context.Background()
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = srcConn.Close() }()
+	dstConn, err := conn.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = dstConn.Close() }()
+
+	err = dstConn.Raw(func(dst any) error {
+		return srcConn.Raw(func(src any) error {
+			dstSQLite, ok := dst.(*sqlite3.SQLiteConn)
+			srcSQLite, ok2 := src.(*sqlite3.SQLiteConn)
+			if !ok || !ok2 {
+				return errors.New("restore needs SQLite connections")
+			}
+			b, err := dstSQLite.Backup("main", srcSQLite, "main")
+			if err != nil {
+				return err
+			}
+			if _, err := b.Step(-1); err != nil {
+				_ = b.Finish()
+				return err
+			}
+			return b.Finish()
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("error restoring backup %s: %w", path, err)
+	}
+	return nil
+}
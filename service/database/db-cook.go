@@ -0,0 +1,157 @@
+package database
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/lorenzougolini/wimf-app/service/models"
+)
+
+func (db *appdbimpl) GetAllItems() ([]models.Item, error) {
+	query := `
+		SELECT ` + lotColumns + `
+		FROM items
+		WHERE deleted_at IS NULL
+		ORDER BY name ASC, expiration_date ASC;
+	`
+	rows, err := db.c.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []models.Item
+	for rows.Next() {
+		i, err := scanLot(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	return items, rows.Err()
+}
+
+// CookItems consumes the given quantities of the ingredient lots and creates a new leftover lot linked to them, all in
+// one transaction. The leftover gets a generated internal code in place of the barcode, which is returned. It fails
+// with ErrItemNotFound if an ingredient is missing, with ErrNotEnoughStock if it has less than required and with
+// ErrIncompatibleUnit if its amount is in a unit that doesn't fit the lot.
+func (db *appdbimpl) CookItems(leftover models.ProductInfo, ingredients []models.Ingredient, expiration time.Time) (string, error) {
+	if len(ingredients) == 0 {
+		return "", errors.New("at least one ingredient is required")
+	}
+
+	id, err := uuid.NewV7()
+	if err != nil {
+		return "", err
+	}
+	newId := id.String()
+
+	code, err := newLeftoverCode()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	tx, err := db.begin()
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for _, ing := range ingredients {
+		if ing.Quantity <= 0 && ing.Amount <= 0 {
+			return "", fmt.Errorf("invalid quantity %d for item %s", ing.Quantity, ing.ItemId)
+		}
+
+		before, err := snapshotLot(tx, ing.ItemId)
+		if err != nil {
+			return "", err
+		}
+		if before == nil || !before.DeletedAt.IsZero() {
+			return "", fmt.Errorf("item %s: %w", ing.ItemId, ErrItemNotFound)
+		}
+		source := models.ItemSource{
+			SourceId: before.Id.String(),
+			Barcode:  before.Barcode,
+			Name:     before.Name,
+			Brand:    before.Brand,
+		}
+		// measured lots are used by amount, like from their detail modal, and the amount is kept in their unit
+		if ing.Amount > 0 {
+			if _, _, err := db.consumeAmount(tx, ing.ItemId, ing.Amount, ing.Unit, now); err != nil {
+				return "", err
+			}
+			source.Amount, _ = models.ConvertAmount(ing.Amount, ing.Unit, before.Unit)
+			source.Unit = before.Unit
+		} else {
+			if err := db.consumeLot(tx, ing.ItemId, ing.Quantity, now); err != nil {
+				return "", err
+			}
+			source.Quantity = ing.Quantity
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO item_sources (item_id, source_id, barcode, name, brand, quantity, amount, unit)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (item_id, source_id) DO UPDATE
+			SET quantity = quantity + excluded.quantity, amount = amount + excluded.amount;`,
+			newId, source.SourceId, source.Barcode, source.Name, source.Brand, source.Quantity, source.Amount,
+			source.Unit)
+		if err != nil {
+			return "", fmt.Errorf("error linking item %s: %w", ing.ItemId, err)
+		}
+	}
+
+	lot := models.Item{
+		Id:             id,
+		Barcode:        code,
+		Name:           leftover.Name,
+		Brand:          leftover.Brand,
+		Quantity:       1,
+		ExpirationDate: expiration,
+		AdditionDate:   now,
+		Location:       models.DefaultLocation,
+	}
+	if _, err := db.insertLot(tx, lot, models.LeftoversCategory, models.AuditAdd); err != nil {
+		return "", err
+	}
+
+	return code, tx.Commit()
+}
+
+func (db *appdbimpl) GetItemSources(id string) ([]models.ItemSource, error) {
+	rows, err := db.c.Query(`
+		SELECT source_id, barcode, name, brand, quantity, amount, unit
+		FROM item_sources
+		WHERE item_id=?
+		ORDER BY name ASC;`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sources []models.ItemSource
+	for rows.Next() {
+		var s models.ItemSource
+		if err := rows.Scan(&s.SourceId, &s.Barcode, &s.Name, &s.Brand, &s.Quantity, &s.Amount, &s.Unit); err != nil {
+			return nil, err
+		}
+		sources = append(sources, s)
+	}
+	return sources, rows.Err()
+}
+
+// newLeftoverCode generates the internal code of a leftover lot, e.g. LO-20240131-9F2C41AB. The random part is wide
+// enough not to repeat among the leftovers of a day.
+func newLeftoverCode() (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return models.LeftoverPrefix + time.Now().Format("20060102") + "-" + strings.ToUpper(hex.EncodeToString(b)), nil
+}
@@ -0,0 +1,121 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/lorenzougolini/wimf-app/service/models"
+)
+
+func TestCookItems(t *testing.T) {
+	db := newTestDB(t)
+	id := addMeasuredLot(t, db, 2, 500)
+
+	ingredients := []models.Ingredient{{ItemId: id, Quantity: 1}}
+	code, err := db.CookItems(models.ProductInfo{Name: "Torta"}, ingredients, time.Now().AddDate(0, 0, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// like any consumption, the opened package goes first
+	lot, err := db.GetItemById(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lot.Quantity != 1 || lot.Amount != 1000 {
+		t.Errorf("ingredient after cooking = %d packages, %v g; want 1, 1000", lot.Quantity, lot.Amount)
+	}
+
+	_, leftovers, err := db.GetItemsByBarcode(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leftovers) != 1 || leftovers[0].Quantity != 1 || leftovers[0].Location != models.DefaultLocation {
+		t.Fatalf("leftovers = %+v; want one lot in %s", leftovers, models.DefaultLocation)
+	}
+	product, err := db.GetProduct(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if product.Category != models.LeftoversCategory {
+		t.Errorf("leftover category = %q, want %q", product.Category, models.LeftoversCategory)
+	}
+	sources, err := db.GetItemSources(leftovers[0].Id.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sources) != 1 || sources[0].SourceId != id || sources[0].Quantity != 1 {
+		t.Errorf("leftover sources = %+v; want 1 unit of %s", sources, id)
+	}
+}
+
+func TestCookMissingIngredients(t *testing.T) {
+	db := newTestDB(t)
+	id := addMeasuredLot(t, db, 2, 0)
+	expiration := time.Now().AddDate(0, 0, 3)
+
+	_, err := db.CookItems(models.ProductInfo{Name: "Torta"}, []models.Ingredient{{ItemId: id, Quantity: 3}}, expiration)
+	if !errors.Is(err, ErrNotEnoughStock) {
+		t.Errorf("cooking 3 of 2 packages: %v, want ErrNotEnoughStock", err)
+	}
+	missing := uuid.Must(uuid.NewV7()).String()
+	ingredients := []models.Ingredient{{ItemId: id, Quantity: 1}, {ItemId: missing, Quantity: 1}}
+	_, err = db.CookItems(models.ProductInfo{Name: "Torta"}, ingredients, expiration)
+	if !errors.Is(err, ErrItemNotFound) {
+		t.Errorf("cooking a missing lot: %v, want ErrItemNotFound", err)
+	}
+
+	// nothing is consumed when cooking fails
+	lot, err := db.GetItemById(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lot.Quantity != 2 {
+		t.Errorf("ingredient after failing to cook = %d packages, want 2", lot.Quantity)
+	}
+}
+
+// TestCookAmount cooks with part of a measured lot, given in another unit: its amount is used like from the detail
+// modal, and recorded among the sources in the unit of the lot.
+func TestCookAmount(t *testing.T) {
+	db := newTestDB(t)
+	id := addMeasuredLot(t, db, 2, 500)
+	expiration := time.Now().AddDate(0, 0, 3)
+
+	ingredients := []models.Ingredient{{ItemId: id, Amount: 0.7, Unit: models.UnitKilogram}}
+	code, err := db.CookItems(models.ProductInfo{Name: "Pane"}, ingredients, expiration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lot, err := db.GetItemById(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lot.Quantity != 1 || lot.Amount != 800 {
+		t.Errorf("ingredient after cooking = %d packages, %v g; want 1, 800", lot.Quantity, lot.Amount)
+	}
+
+	_, leftovers, err := db.GetItemsByBarcode(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sources, err := db.GetItemSources(leftovers[0].Id.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sources) != 1 || sources[0].Amount != 700 || sources[0].Unit != models.UnitGram || sources[0].Quantity != 0 {
+		t.Errorf("leftover sources = %+v; want 700 g of %s", sources, id)
+	}
+
+	for _, ing := range []models.Ingredient{
+		{ItemId: id, Amount: 900, Unit: models.UnitGram},
+		{ItemId: id, Amount: 100, Unit: models.UnitMillilitre},
+	} {
+		_, err = db.CookItems(models.ProductInfo{Name: "Pane"}, []models.Ingredient{ing}, expiration)
+		if !errors.Is(err, ErrNotEnoughStock) && !errors.Is(err, ErrIncompatibleUnit) {
+			t.Errorf("cooking %v %s of 800 g: %v, want it refused", ing.Amount, ing.Unit, err)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/lorenzougolini/wimf-app/service/models"
+)
+
+// ErrDraftIncomplete is returned by CommitDraft when an item of the draft has no name or no expiration date.
+var ErrDraftIncomplete = errors.New("draft items without name or expiration date")
+
+const draftColumns = `id, barcode, name, brand, category, quantity, unit, package_amount, expiration_date, location,
+	status, scanned_at`
+
+func scanDraftItem(row scanner) (models.DraftItem, error) {
+	var item models.DraftItem
+	var exp sql.NullString
+	var scanned string
+	err := row.Scan(&item.Id, &item.Barcode, &item.Name, &item.Brand, &item.Category, &item.Quantity, &item.Unit,
+		&item.PackageAmount, &exp, &item.Location, &item.Status, &scanned)
+	if err != nil {
+		return item, err
+	}
+	if exp.Valid {
+		item.ExpirationDate, _ = time.Parse(models.DbTimeLayout, exp.String)
+	}
+	item.ScannedAt, _ = time.Parse(models.DbTimeLayout, scanned)
+	return item, nil
+}
+
+// draftDate is the stored value of a draft expiration date, NULL when it's not set.
+func draftDate(t time.Time) sql.NullString {
+	if t.IsZero() {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: t.Format(models.DbTimeLayout), Valid: true}
+}
+
+// ScanDraftItem adds a unit of `barcode` to the bulk add draft, returning true when the barcode is new and must be
+// looked up. The unit goes to the row of the barcode still without an expiration date: once the date is set, a new
+// scan may be a package with another date, so it starts a row of its own with what the lookup found for the barcode.
+func (db *appdbimpl) ScanDraftItem(barcode string) (bool, error) {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := tx.Exec(`
+		UPDATE draft_items SET quantity = quantity + 1
+		WHERE id = (SELECT id FROM draft_items WHERE barcode=? AND expiration_date IS NULL
+			ORDER BY scanned_at DESC, id DESC LIMIT 1);`, barcode)
+	if err != nil {
+		return false, fmt.Errorf("error adding %s to the draft: %w", barcode, err)
+	}
+	if added, err := res.RowsAffected(); err != nil {
+		return false, err
+	} else if added > 0 {
+		return false, tx.Commit()
+	}
+
+	id, err := uuid.NewV7()
+	if err != nil {
+		return false, err
+	}
+	now := time.Now().Format(models.DbTimeLayout)
+	res, err = tx.Exec(`
+		INSERT INTO draft_items (id, barcode, name, brand, category, unit, package_amount, location, status, scanned_at)
+		SELECT ?, barcode, name, brand, category, unit, package_amount, location, status, ?
+		FROM draft_items WHERE barcode=? ORDER BY scanned_at DESC, id DESC LIMIT 1;`, id.String(), now, barcode)
+	if err != nil {
+		return false, fmt.Errorf("error adding %s to the draft: %w", barcode, err)
+	}
+	if copied, err := res.RowsAffected(); err != nil {
+		return false, err
+	} else if copied > 0 {
+		return false, tx.Commit()
+	}
+
+	_, err = tx.Exec(`INSERT INTO draft_items (id, barcode, location, status, scanned_at) VALUES (?, ?, ?, ?, ?);`,
+		id.String(), barcode, models.DefaultLocation, models.DraftPending, now)
+	if err != nil {
+		return false, fmt.Errorf("error adding %s to the draft: %w", barcode, err)
+	}
+	return true, tx.Commit()
+}
+
+// GetDraft returns the items of the bulk add draft, in scanning order.
+func (db *appdbimpl) GetDraft() ([]models.DraftItem, error) {
+	rows, err := db.c.Query(`SELECT ` + draftColumns + ` FROM draft_items ORDER BY scanned_at ASC, id ASC;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var draft []models.DraftItem
+	for rows.Next() {
+		item, err := scanDraftItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		draft = append(draft, item)
+	}
+	return draft, rows.Err()
+}
+
+// GetDraftItem returns the draft item `id`, or ErrItemNotFound.
+func (db *appdbimpl) GetDraftItem(id string) (models.DraftItem, error) {
+	item, err := scanDraftItem(db.c.QueryRow(`SELECT `+draftColumns+` FROM draft_items WHERE id=?;`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return item, fmt.Errorf("draft item %s: %w", id, ErrItemNotFound)
+	}
+	return item, err
+}
+
+// ResolveDraftItem stores the outcome of the lookup of a draft item, and of the other rows of its barcode still waiting
+// for it: the name and the brand of `product` are only used when the user hasn't typed them yet.
+func (db *appdbimpl) ResolveDraftItem(id string, product models.ProductInfo, status string) error {
+	unit, packageAmount := product.Unit, models.RoundAmount(product.PackageAmount)
+	if !models.ValidUnit(unit) || packageAmount <= 0 {
+		unit, packageAmount = "", 0
+	}
+	_, err := db.c.Exec(`
+		UPDATE draft_items
+		SET name = CASE WHEN name = '' THEN ? ELSE name END,
+			brand = CASE WHEN brand = '' THEN ? ELSE brand END,
+			category = ?, unit = ?, package_amount = ?, status = ?
+		WHERE id=? OR (status=? AND barcode = (SELECT barcode FROM draft_items WHERE id=?));`,
+		product.Name, product.Brand, product.Category, unit, packageAmount, status, id, models.DraftPending, id)
+	return err
+}
+
+// UpdateDraftItem saves the fields of a draft item edited in the review table: name, brand, quantity, expiration date
+// and location. An item left identical to another one is merged into it.
+func (db *appdbimpl) UpdateDraftItem(item models.DraftItem) error {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.Exec(`
+		UPDATE draft_items SET name=?, brand=?, quantity=?, expiration_date=?, location=? WHERE id=?;`,
+		item.Name, item.Brand, item.Quantity, draftDate(item.ExpirationDate), item.Location, item.Id)
+	if err != nil {
+		return err
+	}
+	if err = mergeDraftItem(tx, item.Id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetDraftExpirations sets the expiration date of the draft items in `dates`, by id, in one go. The items left
+// identical to another one are merged into it.
+func (db *appdbimpl) SetDraftExpirations(dates map[string]time.Time) error {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for id, date := range dates {
+		if _, err := tx.Exec(`UPDATE draft_items SET expiration_date=? WHERE id=?;`, draftDate(date), id); err != nil {
+			return err
+		}
+	}
+	for id := range dates {
+		if err := mergeDraftItem(tx, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// mergeDraftItem adds the units of the draft item `id` to the first one identical to it in all but the quantity, if
+// any, and removes it: the draft has a row per distinct lot, while the same product may have rows with different
+// dates or locations.
+func mergeDraftItem(tx *sql.Tx, id string) error {
+	var into string
+	err := tx.QueryRow(`
+		SELECT o.id FROM draft_items o JOIN draft_items d ON d.id=?
+		WHERE o.id != d.id AND o.barcode = d.barcode AND o.name = d.name AND o.brand = d.brand AND o.unit = d.unit
+			AND o.package_amount = d.package_amount AND o.expiration_date IS d.expiration_date
+			AND o.location = d.location
+		ORDER BY o.scanned_at ASC, o.id ASC LIMIT 1;`, id).Scan(&into)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE draft_items SET quantity = quantity + (SELECT quantity FROM draft_items WHERE id=?)
+		WHERE id=?;`, id, into)
+	if err != nil {
+		return fmt.Errorf("error merging draft item %s into %s: %w", id, into, err)
+	}
+	_, err = tx.Exec(`DELETE FROM draft_items WHERE id=?;`, id)
+	return err
+}
+
+func (db *appdbimpl) DeleteDraftItem(id string) error {
+	_, err := db.c.Exec(`DELETE FROM draft_items WHERE id=?;`, id)
+	return err
+}
+
+// ClearDraft discards the whole bulk add draft.
+func (db *appdbimpl) ClearDraft() error {
+	_, err := db.c.Exec(`DELETE FROM draft_items;`)
+	return err
+}
+
+// CommitDraft stores every item of the bulk add draft as a new lot, or adds it to an identical lot in stock, and empties
+// the draft, all in one transaction. It returns how many items were added, or ErrDraftIncomplete without adding
+// anything.
+func (db *appdbimpl) CommitDraft() (int, error) {
+	tx, err := db.begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	rows, err := tx.Query(`SELECT ` + draftColumns + ` FROM draft_items ORDER BY scanned_at ASC, id ASC;`)
+	if err != nil {
+		return 0, err
+	}
+	var draft []models.DraftItem
+	for rows.Next() {
+		item, err := scanDraftItem(rows)
+		if err != nil {
+			_ = rows.Close()
+			return 0, err
+		}
+		draft = append(draft, item)
+	}
+	_ = rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	for _, item := range draft {
+		if item.Name == "" || item.ExpirationDate.IsZero() {
+			return 0, ErrDraftIncomplete
+		}
+		lot := models.Item{
+			Barcode:        item.Barcode,
+			Name:           item.Name,
+			Brand:          item.Brand,
+			Quantity:       max(item.Quantity, 1),
+			Unit:           item.Unit,
+			PackageAmount:  item.PackageAmount,
+			ExpirationDate: item.ExpirationDate,
+			AdditionDate:   now,
+			Location:       item.Location,
+		}
+		if _, err := db.addLot(tx, lot, item.Category, false, models.AuditAdd); err != nil {
+			return 0, err
+		}
+	}
+	if _, err = tx.Exec(`DELETE FROM draft_items;`); err != nil {
+		return 0, err
+	}
+	return len(draft), tx.Commit()
+}
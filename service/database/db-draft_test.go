@@ -0,0 +1,83 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lorenzougolini/wimf-app/service/models"
+)
+
+// TestDraftRowsByDate scans a product again after its row got an expiration date: the new packages get a row of their
+// own, merged back only when it's given the same date.
+func TestDraftRowsByDate(t *testing.T) {
+	db := newTestDB(t)
+	scan := func(wantNew bool) {
+		t.Helper()
+		if added, err := db.ScanDraftItem("8001234"); err != nil {
+			t.Fatal(err)
+		} else if added != wantNew {
+			t.Errorf("scan: new barcode %t, want %t", added, wantNew)
+		}
+	}
+	draft := func() []models.DraftItem {
+		t.Helper()
+		items, err := db.GetDraft()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return items
+	}
+
+	scan(true)
+	scan(false)
+	first := draft()[0]
+	err := db.ResolveDraftItem(first.Id, models.ProductInfo{Name: "Farina", Unit: models.UnitGram, PackageAmount: 1000},
+		models.DraftFound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first = draft()[0]
+	expiration := time.Now().AddDate(0, 6, 0).Truncate(24 * time.Hour)
+	first.ExpirationDate = expiration
+	if err = db.UpdateDraftItem(first); err != nil {
+		t.Fatal(err)
+	}
+
+	scan(false)
+	items := draft()
+	if len(items) != 2 {
+		t.Fatalf("%d rows, want 2", len(items))
+	}
+	second := items[1]
+	if second.Quantity != 1 || !second.ExpirationDate.IsZero() || second.Name != "Farina" ||
+		second.Unit != models.UnitGram || second.PackageAmount != 1000 || second.Status != models.DraftFound {
+		t.Errorf("new row = %+v, want 1 package of Farina, 1000 g, without date", second)
+	}
+
+	// another date keeps the rows apart, the same one merges them
+	second.ExpirationDate = expiration.AddDate(0, 1, 0)
+	if err = db.UpdateDraftItem(second); err != nil {
+		t.Fatal(err)
+	}
+	if items = draft(); len(items) != 2 {
+		t.Fatalf("%d rows with different dates, want 2", len(items))
+	}
+	if err = db.SetDraftExpirations(map[string]time.Time{second.Id: expiration}); err != nil {
+		t.Fatal(err)
+	}
+	if items = draft(); len(items) != 1 || items[0].Id != first.Id || items[0].Quantity != 3 {
+		t.Fatalf("draft = %+v, want the first row with 3 packages", items)
+	}
+
+	if n, err := db.CommitDraft(); err != nil || n != 1 {
+		t.Fatalf("commit = %d, %v; want 1 item", n, err)
+	}
+	_, lots, err := db.GetItemsByBarcode("8001234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lots) != 1 || lots[0].Quantity != 3 || lots[0].Unit != models.UnitGram || lots[0].PackageAmount != 1000 ||
+		lots[0].Amount != 3000 {
+		t.Errorf("lots = %+v, want one of 3 packages of 1000 g", lots)
+	}
+}
@@ -0,0 +1,109 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lorenzougolini/wimf-app/service/events"
+	"github.com/lorenzougolini/wimf-app/service/models"
+)
+
+// changeTx is a transaction changing the inventory. It collects the events of the changes audited in it, published
+// only once it's committed.
+type changeTx struct {
+	*sql.Tx
+	db      *appdbimpl
+	pending []events.Event
+}
+
+// begin starts a transaction changing the inventory.
+func (db *appdbimpl) begin() (*changeTx, error) {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &changeTx{Tx: tx, db: db}, nil
+}
+
+// Commit commits the transaction, then publishes its events.
+func (tx *changeTx) Commit() error {
+	if err := tx.Tx.Commit(); err != nil {
+		return err
+	}
+	if tx.db.events != nil && len(tx.pending) > 0 {
+		tx.db.events.Publish(tx.pending...)
+	}
+	return nil
+}
+
+// lotEvent returns the event of a change of a lot audited as `action`, nil for the changes nobody is notified of.
+func (db *appdbimpl) lotEvent(action string, before, after *models.Item) events.Event {
+	switch {
+	case action == models.AuditAdd && before != nil && after != nil:
+		// units added to an identical lot
+		return events.ItemUpdated{Source: db.source, Before: *before, After: *after}
+	case action == models.AuditAdd && after != nil:
+		return events.ItemAdded{Source: db.source, Item: *after}
+	case action == models.AuditRestore && before != nil && before.DeletedAt.IsZero() && after != nil:
+		// units of an undone consumption given back to a lot still in stock
+		return events.ItemUpdated{Source: db.source, Before: *before, After: *after}
+	case action == models.AuditRestore && after != nil:
+		return events.ItemAdded{Source: db.source, Item: *after, Restored: true}
+	case action == models.AuditUpdate && before != nil && after != nil:
+		return events.ItemUpdated{Source: db.source, Before: *before, After: *after}
+	case action == models.AuditConsume && before != nil:
+		consumed := before.Quantity
+		if after != nil {
+			consumed -= after.Quantity
+		}
+		return events.ItemConsumed{Source: db.source, Item: *before, Quantity: consumed}
+	case action == models.AuditDelete && before != nil:
+		return events.ItemRemoved{Source: db.source, Item: *before}
+	case action == models.AuditStocktake && before == nil && after != nil:
+		return events.ItemAdded{Source: db.source, Item: *after}
+	case action == models.AuditStocktake && before != nil && (after == nil || !after.DeletedAt.IsZero()):
+		return events.ItemRemoved{Source: db.source, Item: *before}
+	case action == models.AuditStocktake && before != nil:
+		return events.ItemUpdated{Source: db.source, Before: *before, After: *after}
+	case action == models.AuditMerge && before != nil && after == nil:
+		return events.ItemRemoved{Source: db.source, Item: *before}
+	case action == models.AuditMerge && before != nil:
+		return events.ItemUpdated{Source: db.source, Before: *before, After: *after}
+	case action == models.AuditSplit && before == nil && after != nil:
+		return events.ItemAdded{Source: db.source, Item: *after}
+	case action == models.AuditSplit && before != nil && after != nil:
+		return events.ItemUpdated{Source: db.source, Before: *before, After: *after}
+	}
+	return nil
+}
+
+// NotifyExpired publishes ItemExpired for the lots in stock that expired at or after `since` and before `until`,
+// returning how many expired.
+func (db *appdbimpl) NotifyExpired(since, until time.Time) (int, error) {
+	rows, err := db.c.Query(`
+		SELECT `+lotColumns+`
+		FROM items
+		WHERE deleted_at IS NULL AND expiration_date >= ? AND expiration_date < ?
+		ORDER BY expiration_date ASC;`,
+		since.Format(models.DbTimeLayout), until.Format(models.DbTimeLayout))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var expired []events.Event
+	for rows.Next() {
+		lot, err := scanLot(rows)
+		if err != nil {
+			return 0, err
+		}
+		expired = append(expired, events.ItemExpired{Source: db.source, Item: lot})
+	}
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	if db.events != nil && len(expired) > 0 {
+		db.events.Publish(expired...)
+	}
+	return len(expired), nil
+}
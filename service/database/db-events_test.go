@@ -0,0 +1,117 @@
+package database
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/lorenzougolini/wimf-app/service/events"
+	"github.com/lorenzougolini/wimf-app/service/models"
+)
+
+// recorder is a publisher keeping the published events.
+type recorder struct {
+	published []events.Event
+}
+
+func (r *recorder) Publish(e ...events.Event) {
+	r.published = append(r.published, e...)
+}
+
+// newRecordedDB is newTestDB publishing its events to the returned recorder.
+func newRecordedDB(t *testing.T) (AppDatabase, *recorder) {
+	t.Helper()
+	conn, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "fridge.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	bus := &recorder{}
+	db, err := New(conn, bus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, bus
+}
+
+// TestChangesPublished adds, refills, consumes and removes a lot: each change must be published once committed, with
+// the source of the database making it, while a change that fails publishes nothing.
+func TestChangesPublished(t *testing.T) {
+	db, bus := newRecordedDB(t)
+	source := models.AuditSource{Endpoint: "test"}
+	db = db.WithSource(source)
+
+	id, err := db.AddItem(models.ProductInfo{Barcode: "8005678", Name: "Yogurt"}, models.Item{
+		ExpirationDate: time.Now().AddDate(0, 0, 10),
+		AdditionDate:   time.Now(),
+		Location:       models.DefaultLocation,
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.AdjustItemQuantity(id, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.ConsumeByBarcode("8005678", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.DeleteItem(id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.AdjustItemQuantity(uuid.Must(uuid.NewV7()).String(), 1); err == nil {
+		t.Error("refilling a missing lot: no error")
+	}
+
+	if len(bus.published) != 4 {
+		t.Fatalf("published %d events, want 4: %+v", len(bus.published), bus.published)
+	}
+	if e, ok := bus.published[0].(events.ItemAdded); !ok || e.Item.Id.String() != id || e.Item.Quantity != 1 {
+		t.Errorf("first event = %+v, want 1 unit of the lot added", bus.published[0])
+	}
+	if e, ok := bus.published[1].(events.ItemUpdated); !ok || e.Before.Quantity != 1 || e.After.Quantity != 2 {
+		t.Errorf("second event = %+v, want the lot from 1 to 2 units", bus.published[1])
+	}
+	if e, ok := bus.published[2].(events.ItemConsumed); !ok || e.Item.Quantity != 2 || e.Quantity != 1 {
+		t.Errorf("third event = %+v, want 1 of 2 units consumed", bus.published[2])
+	}
+	if e, ok := bus.published[3].(events.ItemRemoved); !ok || e.Item.Quantity != 1 {
+		t.Errorf("fourth event = %+v, want the lot of 1 unit removed", bus.published[3])
+	}
+	for _, e := range bus.published {
+		if e.EventSource() != source {
+			t.Errorf("%T made by %+v, want %+v", e, e.EventSource(), source)
+		}
+	}
+}
+
+// TestUndoConsumePublished undoes two consumptions: the units given back to a lot still in stock update it, while the
+// lot used up is added again.
+func TestUndoConsumePublished(t *testing.T) {
+	db, bus := newRecordedDB(t)
+	id := addLot(t, db, 2)
+
+	undo := func(quantity int) events.Event {
+		t.Helper()
+		_, at, err := db.ConsumeItem(id, quantity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bus.published = nil
+		if err = db.UndoConsume(id, at); err != nil {
+			t.Fatal(err)
+		}
+		if len(bus.published) != 1 {
+			t.Fatalf("undoing: published %+v, want one event", bus.published)
+		}
+		return bus.published[0]
+	}
+
+	if e, ok := undo(1).(events.ItemUpdated); !ok || e.Before.Quantity != 1 || e.After.Quantity != 2 {
+		t.Errorf("undoing a consumption of 1 of 2 units: %+v, want the lot from 1 to 2 units", e)
+	}
+	if e, ok := undo(2).(events.ItemAdded); !ok || !e.Restored || e.Item.Id.String() != id || e.Item.Quantity != 2 {
+		t.Errorf("undoing the consumption of the whole lot: %+v, want the lot of 2 units restored", e)
+	}
+}
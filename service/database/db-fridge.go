@@ -2,30 +2,99 @@ package database
 
 import (
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
 	"time"
 
 	"github.com/lorenzougolini/wimf-app/service/models"
 )
 
-func (db *appdbimpl) GetFridge() ([]models.Item, error) {
-	query := `
-		SELECT barcode, name, brand, SUM(quantity) as tot_quantity, MIN(expiration_date) as next_exp, MAX(added_at) as latest_add
-		FROM items
-		GROUP BY barcode
-		ORDER BY next_exp ASC
-	`
-	rows, err := db.c.Query(query)
+// fridgeSortKeys are the expressions the fridge can be sorted by, over the columns of the grouped products, if their
+// order is descending and if they're numbers rather than strings. Ties are always broken by barcode, so the order is
+// total and usable for keyset pagination.
+var fridgeSortKeys = map[string]struct {
+	expr    string
+	desc    bool
+	numeric bool
+}{
+	models.SortExpiry:   {"COALESCE(next_exp, '9999')", false, false},
+	models.SortName:     {"LOWER(name)", false, false},
+	models.SortAdded:    {"COALESCE(latest_add, '')", true, false},
+	models.SortQuantity: {"tot_quantity", true, true},
+}
+
+// GetFridge returns the products matching `filter` aggregating their lots, in the order requested by the filter.
+// Results are paginated with a keyset: `cursor` is empty for the first page, and the returned cursor is the one of
+// the next page (empty on the last one). A `limit` of zero or less returns all the products at once.
+func (db *appdbimpl) GetFridge(filter models.FridgeFilter, cursor string, limit int) ([]models.Item, string, error) {
+	sortKey, ok := fridgeSortKeys[filter.Sort]
+	if !ok {
+		sortKey = fridgeSortKeys[models.SortExpiry]
+	}
+	direction, comparison := "ASC", ">"
+	if sortKey.desc {
+		direction, comparison = "DESC", "<"
+	}
+
+	where, args := db.fridgeConditions(filter)
+	page := "1=1"
+	if cursor != "" {
+		key, barcode, err := decodeCursor(cursor, sortKey.numeric)
+		if err != nil {
+			return nil, "", err
+		}
+		page = fmt.Sprintf("(sort_key %s ? OR (sort_key = ? AND barcode > ?))", comparison)
+		args = append(args, key, key, barcode)
+	}
+	if limit <= 0 {
+		limit = -1
+	}
+	args = append(args, limit)
+
+	query := fmt.Sprintf(`
+		WITH grouped AS (
+			SELECT i.barcode as barcode, i.name as name, i.brand as brand, SUM(i.quantity) as tot_quantity,
+				MIN(i.expiration_date) as next_exp, MAX(i.added_at) as latest_add,
+				COALESCE(NULLIF(p.category, ''), '%s') as category,
+				COALESCE((SELECT GROUP_CONCAT(t.tag, ',') FROM product_tags t WHERE t.barcode = i.barcode), '') as tags,
+				COALESCE(GROUP_CONCAT(NULLIF(i.note, ''), ' · '), '') as notes, MAX(i.label) as label,
+				SUM(CASE i.unit WHEN 'kg' THEN i.amount * 1000 WHEN 'g' THEN i.amount ELSE 0 END) as grams,
+				SUM(CASE i.unit WHEN 'l' THEN i.amount * 1000 WHEN 'ml' THEN i.amount ELSE 0 END) as millilitres
+			FROM items i
+			LEFT JOIN products p ON p.barcode = i.barcode
+			WHERE %s
+			GROUP BY i.barcode
+		), sorted AS (
+			SELECT *, %s as sort_key FROM grouped
+		)
+		SELECT barcode, name, brand, tot_quantity, next_exp, latest_add, category, tags, notes, label, grams, millilitres,
+			sort_key
+		FROM sorted
+		WHERE %s
+		ORDER BY sort_key %s, barcode ASC
+		LIMIT ?
+	`, models.OtherCategory, where, sortKey.expr, page, direction)
+	rows, err := db.c.Query(query, args...)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer rows.Close()
 
 	var result []models.Item
+	var lastKey any
 	for rows.Next() {
 		var i models.Item
 		var nextExp, latestAdd sql.NullString
-		if err := rows.Scan(&i.Barcode, &i.Name, &i.Brand, &i.Quantity, &nextExp, &latestAdd); err != nil {
-			return nil, err
+		var tags string
+		var grams, millilitres float64
+		err := rows.Scan(&i.Barcode, &i.Name, &i.Brand, &i.Quantity, &nextExp, &latestAdd, &i.Category, &tags, &i.Note,
+			&i.Label, &grams, &millilitres, &lastKey)
+		if err != nil {
+			return nil, "", err
 		}
 
 		if nextExp.Valid {
@@ -34,8 +103,97 @@ func (db *appdbimpl) GetFridge() ([]models.Item, error) {
 		if latestAdd.Valid {
 			i.AdditionDate, _ = time.Parse(models.DbTimeLayout, latestAdd.String)
 		}
+		if tags != "" {
+			i.Tags = strings.Split(tags, ",")
+		}
+		// the lots measured in a unit are summed up, unless the product mixes weights and volumes
+		switch {
+		case grams > 0 && millilitres == 0:
+			i.Amount, i.Unit = models.RoundAmount(grams), models.UnitGram
+		case millilitres > 0 && grams == 0:
+			i.Amount, i.Unit = models.RoundAmount(millilitres), models.UnitMillilitre
+		}
 
 		result = append(result, i)
 	}
+	if err = rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	var next string
+	if limit > 0 && len(result) == limit {
+		next, err = encodeCursor(lastKey, result[len(result)-1].Barcode)
+	}
+	return result, next, err
+}
+
+// encodeCursor builds the opaque keyset cursor pointing after the product with the given sort key and barcode.
+func encodeCursor(key any, barcode string) (string, error) {
+	b, err := json.Marshal([]any{key, barcode})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// ErrInvalidCursor is returned for a pagination cursor not built by GetFridge.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// decodeCursor reads a cursor built by encodeCursor for a sort key that is a number if `numeric`, a string otherwise.
+func decodeCursor(cursor string, numeric bool) (any, string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %w", ErrInvalidCursor, err)
+	}
+	var parts []any
+	if err := json.Unmarshal(b, &parts); err != nil || len(parts) != 2 {
+		return nil, "", ErrInvalidCursor
+	}
+	barcode, ok := parts[1].(string)
+	if !ok {
+		return nil, "", ErrInvalidCursor
+	}
+	// JSON numbers decode as float64, quantities are integers in the database
+	if n, ok := parts[0].(float64); ok && numeric && n == math.Trunc(n) {
+		return int64(n), barcode, nil
+	}
+	if key, ok := parts[0].(string); ok && !numeric {
+		return key, barcode, nil
+	}
+	return nil, "", ErrInvalidCursor
+}
+
+// GetCategorySummary aggregates the products matching `filter` by category.
+func (db *appdbimpl) GetCategorySummary(filter models.FridgeFilter) ([]models.CategorySummary, error) {
+	where, args := db.fridgeConditions(filter)
+	query := fmt.Sprintf(`
+		SELECT COALESCE(NULLIF(p.category, ''), '%s') as category, COUNT(DISTINCT i.barcode), SUM(i.quantity),
+			SUM(CASE WHEN i.expiration_date < ? THEN i.quantity ELSE 0 END), MIN(i.expiration_date)
+		FROM items i
+		LEFT JOIN products p ON p.barcode = i.barcode
+		WHERE %s
+		GROUP BY 1
+		ORDER BY 1
+	`, models.OtherCategory, where)
+	args = append([]any{time.Now().Format(models.DbTimeLayout)}, args...)
+
+	rows, err := db.c.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []models.CategorySummary
+	for rows.Next() {
+		var c models.CategorySummary
+		var nextExp sql.NullString
+		if err := rows.Scan(&c.Category, &c.Products, &c.Quantity, &c.Expired, &nextExp); err != nil {
+			return nil, err
+		}
+		if nextExp.Valid {
+			c.NextExpiration, _ = time.Parse(models.DbTimeLayout, nextExp.String)
+		}
+		result = append(result, c)
+	}
 	return result, rows.Err()
 }
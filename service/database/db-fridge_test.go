@@ -0,0 +1,44 @@
+package database
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/lorenzougolini/wimf-app/service/models"
+)
+
+// TestFridgeInvalidCursor pages the fridge with cursors not built by GetFridge for its order, which must all be
+// refused as invalid rather than reach the query.
+func TestFridgeInvalidCursor(t *testing.T) {
+	db := newTestDB(t)
+	addLot(t, db, 2)
+
+	byQuantity := models.FridgeFilter{Sort: models.SortQuantity}
+	byName := models.FridgeFilter{Sort: models.SortName}
+	encode := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	tests := []struct {
+		filter models.FridgeFilter
+		cursor string
+	}{
+		{byName, "not base64!"},
+		{byName, encode(`{"key": "yogurt"}`)},
+		{byName, encode(`["yogurt"]`)},
+		{byName, encode(`["yogurt", 8005678]`)},
+		{byName, encode(`[{"a": 1}, "8005678"]`)},
+		{byName, encode(`[null, "8005678"]`)},
+		{byName, encode(`[2, "8005678"]`)},
+		{byQuantity, encode(`["yogurt", "8005678"]`)},
+		{byQuantity, encode(`[1.5, "8005678"]`)},
+		{byQuantity, encode(`[[2], "8005678"]`)},
+	}
+	for _, tt := range tests {
+		if _, _, err := db.GetFridge(tt.filter, tt.cursor, 1); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("cursor %q sorting by %s: %v, want ErrInvalidCursor", tt.cursor, tt.filter.Sort, err)
+		}
+	}
+
+	if _, _, err := db.GetFridge(byQuantity, encode(`[3, "8005678"]`), 1); err != nil {
+		t.Errorf("cursor by quantity: %v", err)
+	}
+}
@@ -2,8 +2,10 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"time"
 
 	"github.com/gofrs/uuid"
@@ -12,36 +14,153 @@ import (
 
 func (db *appdbimpl) CheckIdExistence(barcode string) (bool, error) {
 	var exists bool
-	err := db.c.QueryRow("SELECT EXISTS(SELECT 1 FROM items WHERE barcode=?)", barcode).Scan(&exists)
+	err := db.c.QueryRow("SELECT EXISTS(SELECT 1 FROM items WHERE barcode=? AND deleted_at IS NULL)", barcode).Scan(&exists)
 	if err != nil {
 		return false, fmt.Errorf("Check existence error: %w", err)
 	}
 	return exists, nil
 }
 
-func (db *appdbimpl) AddItem(product models.ProductInfo, expiration time.Time, addition time.Time) error {
-	id, err := uuid.NewV7()
+// ErrItemNotFound is returned when a lot doesn't exist or is in the trash.
+var ErrItemNotFound = errors.New("item not found")
+
+// AddItem stores a new lot of `product`, returning its id. The quantity defaults to 1. Unless `separate` is set, the
+// units are added to an identical lot in stock instead, whose id is returned (see addLot).
+func (db *appdbimpl) AddItem(product models.ProductInfo, lot models.Item, separate bool) (string, error) {
+	tx, err := db.begin()
 	if err != nil {
-		return err
+		return "", err
 	}
-	newId := id.String()
+	defer func() { _ = tx.Rollback() }()
 
-	query := `
-		INSERT INTO items (id, barcode, name, brand, quantity, expiration_date, added_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?);
-	`
+	lot.Barcode = product.Barcode
+	lot.Name = product.Name
+	lot.Brand = product.Brand
+	if lot.Quantity < 1 {
+		lot.Quantity = 1
+	}
+	id, err := db.addLot(tx, lot, product.Category, separate, models.AuditAdd)
+	if err != nil {
+		return "", err
+	}
+	return id, tx.Commit()
+}
+
+// identicalLotCondition matches the lots in stock a lot can be merged with: same barcode, expiration date and location,
+// same package size and the same note and label, so that nothing the user wrote is lost. They may differ in the
+// addition date only.
+const identicalLotCondition = `barcode=? AND expiration_date=? AND location=? AND unit=? AND package_amount=? AND
+	note=? AND label=? AND deleted_at IS NULL`
+
+// addLot stores `lot` like insertLot, unless `separate` is false and an identical lot is in stock with room for its
+// units (see models.MaxLotQuantity): then the units of `lot` are added to it, audited as `action` on the existing lot,
+// and its id is returned.
+func (db *appdbimpl) addLot(tx *changeTx, lot models.Item, category string, separate bool, action string) (string, error) {
+	if separate {
+		return db.insertLot(tx, lot, category, action)
+	}
+	if !lot.Measured() {
+		lot.PackageAmount = 0
+	}
+	var id string
+	err := tx.QueryRow(`
+		SELECT id FROM items WHERE `+identicalLotCondition+` AND quantity + ? <= ?
+		ORDER BY added_at ASC LIMIT 1;`,
+		lot.Barcode, lot.ExpirationDate.Format(models.DbTimeLayout), lot.Location, lot.Unit, lot.PackageAmount,
+		lot.Note, lot.Label, lot.Quantity, models.MaxLotQuantity).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return db.insertLot(tx, lot, category, action)
+	} else if err != nil {
+		return "", err
+	}
+
+	before, err := snapshotLot(tx, id)
+	if err != nil {
+		return "", err
+	}
+	_, err = tx.Exec(`UPDATE items SET quantity = quantity + ?, amount = amount + ? * package_amount WHERE id=?;`,
+		lot.Quantity, lot.Quantity, id)
+	if err != nil {
+		return "", fmt.Errorf("error merging into item %s: %w", id, err)
+	}
+	if err = seedProduct(tx, lot.Barcode, category); err != nil {
+		return "", err
+	}
+	if err = recordMovement(tx, id, lot.Barcode, lot.Quantity, movementAdd, lot.AdditionDate); err != nil {
+		return "", err
+	}
+	after, err := snapshotLot(tx, id)
+	if err != nil {
+		return "", err
+	}
+	if err = db.auditLot(tx, action, before, after); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// insertLot stores `lot` with its id, or a new one when it has none, and returns the id. The product is seeded with
+// `category`, and the addition is recorded in the movements and in the audit log as `action`.
+func (db *appdbimpl) insertLot(tx *changeTx, lot models.Item, category string, action string) (string, error) {
+	if lot.Id == uuid.Nil {
+		id, err := uuid.NewV7()
+		if err != nil {
+			return "", err
+		}
+		lot.Id = id
+	}
+	newId := lot.Id.String()
+
+	// a measured lot starts with all its packages full, unless its amount is known, as in imported files
+	if lot.Measured() {
+		if lot.Amount <= 0 {
+			lot.Amount = models.RoundAmount(float64(lot.Quantity) * lot.PackageAmount)
+		}
+	} else {
+		lot.Amount, lot.PackageAmount = 0, 0
+	}
+
+	if err := storeLot(tx, lot); err != nil {
+		return "", err
+	}
+	if err := seedProduct(tx, lot.Barcode, category); err != nil {
+		return "", err
+	}
+	if err := recordMovement(tx, newId, lot.Barcode, lot.Quantity, movementAdd, lot.AdditionDate); err != nil {
+		return "", err
+	}
+	after, err := snapshotLot(tx, newId)
+	if err != nil {
+		return "", err
+	}
+	if err = db.auditLot(tx, action, nil, after); err != nil {
+		return "", err
+	}
+	return newId, nil
+}
 
-	_, err = db.c.Exec(query,
-		newId,
-		product.Barcode,
-		product.Name,
-		product.Brand,
-		1,
-		expiration.Format(models.DbTimeLayout),
-		addition.Format(models.DbTimeLayout),
+// storeLot writes the row of `lot`, with its id, as it is.
+func storeLot(tx *changeTx, lot models.Item) error {
+	_, err := tx.Exec(`
+		INSERT INTO items (id, barcode, name, brand, quantity, unit, amount, package_amount, expiration_date, added_at,
+			location, note, label)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`,
+		lot.Id.String(),
+		lot.Barcode,
+		lot.Name,
+		lot.Brand,
+		lot.Quantity,
+		lot.Unit,
+		lot.Amount,
+		lot.PackageAmount,
+		lot.ExpirationDate.Format(models.DbTimeLayout),
+		lot.AdditionDate.Format(models.DbTimeLayout),
+		lot.Location,
+		lot.Note,
+		lot.Label,
 	)
 	if err != nil {
-		return fmt.Errorf("error inserting item %s: %w", product.Barcode, err)
+		return fmt.Errorf("error inserting item %s: %w", lot.Barcode, err)
 	}
 	return nil
 }
@@ -50,9 +169,9 @@ func (db *appdbimpl) GetItemsByBarcode(barcode string) (bool, []models.Item, err
 	var items []models.Item
 
 	query := `
-		SELECT id, barcode, name, brand, quantity, expiration_date, added_at
+		SELECT ` + lotColumns + `
 		FROM items
-		WHERE barcode=?
+		WHERE barcode=? AND deleted_at IS NULL
 		ORDER BY expiration_date ASC;
 	`
 
@@ -63,27 +182,10 @@ func (db *appdbimpl) GetItemsByBarcode(barcode string) (bool, []models.Item, err
 	defer rows.Close()
 
 	for rows.Next() {
-		var i models.Item
-		var exp, add sql.NullString
-		if err := rows.Scan(
-			&i.Id,
-			&i.Barcode,
-			&i.Name,
-			&i.Brand,
-			&i.Quantity,
-			&exp,
-			&add,
-		); err != nil {
-			return false, []models.Item{}, nil
-		}
-
-		if exp.Valid {
-			i.ExpirationDate, _ = time.Parse(models.DbTimeLayout, exp.String)
-		}
-		if add.Valid {
-			i.AdditionDate, _ = time.Parse(models.DbTimeLayout, add.String)
+		i, err := scanLot(rows)
+		if err != nil {
+			return false, nil, err
 		}
-
 		items = append(items, i)
 	}
 	if err = rows.Err(); err != nil {
@@ -109,6 +211,7 @@ func (db *appdbimpl) GetNItemsBy(limit int, orderBy string) ([]models.Item, erro
 	query := fmt.Sprintf(`
 		SELECT barcode, name, brand, SUM(quantity) as tot_quantity, MIN(expiration_date) as next_expiration_date, MAX(added_at) as latest_date
 		FROM items
+		WHERE deleted_at IS NULL
 		GROUP BY barcode
 		ORDER BY %s
 		LIMIT ?;`,
@@ -158,65 +261,527 @@ func (db *appdbimpl) GetNItemsBy(limit int, orderBy string) ([]models.Item, erro
 }
 
 func (db *appdbimpl) GetItemById(id string) (models.Item, error) {
-	var item models.Item
-	var exp, add sql.NullString
-
 	query := `
-		SELECT id, barcode, name, brand, quantity, expiration_date, added_at
+		SELECT ` + lotColumns + `
 		FROM items
-		WHERE id=?;
+		WHERE id=? AND deleted_at IS NULL;
 	`
 
-	err := db.c.QueryRow(query, id).Scan(
-		&item.Id,
-		&item.Barcode,
-		&item.Name,
-		&item.Brand,
-		&item.Quantity,
-		&exp,
-		&add,
-	)
+	item, err := scanLot(db.c.QueryRow(query, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return models.Item{}, fmt.Errorf("item with id %s not found", id)
+			return models.Item{}, fmt.Errorf("item with id %s: %w", id, ErrItemNotFound)
 		}
 		return models.Item{}, err
 	}
 
-	if exp.Valid {
-		item.ExpirationDate, _ = time.Parse(models.DbTimeLayout, exp.String)
+	return item, nil
+}
+
+// AdjustItemQuantity adds `delta` units to the lot `id`, or takes them away when it's negative, in one transaction.
+// Taking more units than the lot has fails with ErrNotEnoughStock; the units taken are recorded as consumed, and a lot
+// brought to zero is used up and removed. It returns the lot after the change, with no units when it was used up.
+func (db *appdbimpl) AdjustItemQuantity(id string, delta int) (models.Item, error) {
+	tx, err := db.begin()
+	if err != nil {
+		return models.Item{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	before, err := snapshotLot(tx, id)
+	if err != nil {
+		return models.Item{}, err
 	}
-	if add.Valid {
-		item.AdditionDate, _ = time.Parse(models.DbTimeLayout, add.String)
+	if before == nil || !before.DeletedAt.IsZero() {
+		return models.Item{}, fmt.Errorf("item %s: %w", id, ErrItemNotFound)
 	}
 
-	return item, nil
+	now := time.Now()
+	switch {
+	case delta < 0:
+		if err = db.consumeLot(tx, id, -delta, now); err != nil {
+			return models.Item{}, err
+		}
+	case delta > 0:
+		_, err = tx.Exec(`UPDATE items SET quantity = quantity + ?, amount = amount + ? * package_amount WHERE id=?;`,
+			delta, delta, id)
+		if err != nil {
+			return models.Item{}, fmt.Errorf("error updating quantity: %w", err)
+		}
+		if err = recordMovement(tx, id, before.Barcode, delta, movementAdd, now); err != nil {
+			return models.Item{}, err
+		}
+		after, err := snapshotLot(tx, id)
+		if err != nil {
+			return models.Item{}, err
+		}
+		if err = db.auditLot(tx, models.AuditUpdate, before, after); err != nil {
+			return models.Item{}, err
+		}
+	}
+
+	after, err := snapshotLot(tx, id)
+	if err != nil {
+		return models.Item{}, err
+	}
+	if after == nil {
+		after = before
+		after.Quantity = 0
+	}
+	return *after, tx.Commit()
 }
 
-func (db *appdbimpl) IncreaseItemQuantity(barcode string, quantity int) error {
-	res, err := db.c.Exec(`
-		UPDATE items 
-		SET quantity = quantity + ? 
-		WHERE id = ?;`,
-		quantity, barcode)
+// ErrNotEnoughStock is returned when consuming more units of a product than the stored ones.
+var ErrNotEnoughStock = errors.New("not enough stock")
+
+// ConsumeByBarcode consumes `quantity` units of a product first-expired, first-out: the lots expiring first are used up
+// before the others. It returns the lots it took from, with the consumed units as Quantity, or ErrNotEnoughStock
+// without consuming anything.
+func (db *appdbimpl) ConsumeByBarcode(barcode string, quantity int) ([]models.Item, error) {
+	if quantity <= 0 {
+		return nil, fmt.Errorf("invalid quantity %d", quantity)
+	}
+
+	tx, err := db.begin()
 	if err != nil {
-		return fmt.Errorf("error updating quantity: %w", err)
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	rows, err := tx.Query(`
+		SELECT `+lotColumns+`
+		FROM items
+		WHERE barcode=? AND deleted_at IS NULL
+		ORDER BY expiration_date ASC, added_at ASC;`, barcode)
+	if err != nil {
+		return nil, err
+	}
+	var lots []models.Item
+	for rows.Next() {
+		lot, err := scanLot(rows)
+		if err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		lots = append(lots, lot)
 	}
-	affected, err := res.RowsAffected()
-	if err != nil || affected == 0 {
-		return fmt.Errorf("error checking affected rows: %w", err)
+	_ = rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
 	}
 
-	return nil
+	now := time.Now()
+	var consumed []models.Item
+	for _, lot := range lots {
+		if quantity == 0 {
+			break
+		}
+		used := min(quantity, lot.Quantity)
+		if err := db.consumeLot(tx, lot.Id.String(), used, now); err != nil {
+			return nil, err
+		}
+
+		lot.Quantity = used
+		consumed = append(consumed, lot)
+		quantity -= used
+	}
+	if quantity > 0 {
+		return nil, ErrNotEnoughStock
+	}
+	return consumed, tx.Commit()
 }
 
+// consumeLot takes `used` units from the lot `id` at `now`, removing the lot when it's used up, and records the
+// consumption in the movements and in the audit log.
+func (db *appdbimpl) consumeLot(tx *changeTx, id string, used int, now time.Time) error {
+	before, err := snapshotLot(tx, id)
+	if err != nil {
+		return err
+	}
+	if before == nil || !before.DeletedAt.IsZero() {
+		return fmt.Errorf("item %s: %w", id, ErrItemNotFound)
+	}
+	if before.Quantity < used {
+		return ErrNotEnoughStock
+	}
+	// the packages of a measured lot are used up starting from the opened one
+	_, err = tx.Exec(`UPDATE items SET quantity = quantity - ?, amount = ? WHERE id=?;`,
+		used, before.AmountFor(before.Quantity-used), id)
+	if err != nil {
+		return fmt.Errorf("error consuming item %s: %w", id, err)
+	}
+	if _, err := tx.Exec(`DELETE FROM items WHERE id=? AND quantity <= 0;`, id); err != nil {
+		return fmt.Errorf("error removing consumed item %s: %w", id, err)
+	}
+	if err := recordMovement(tx, id, before.Barcode, -used, movementConsume, now); err != nil {
+		return err
+	}
+	after, err := snapshotLot(tx, id)
+	if err != nil {
+		return err
+	}
+	return db.auditLot(tx, models.AuditConsume, before, after)
+}
+
+// ConsumeItem consumes `quantity` units of the lot `id`, removing it when it's used up. It returns the lot as it was
+// before and the id of the audit entry of the consumption, which UndoConsume needs to revert it.
+func (db *appdbimpl) ConsumeItem(id string, quantity int) (models.Item, int64, error) {
+	if quantity <= 0 {
+		return models.Item{}, 0, fmt.Errorf("invalid quantity %d", quantity)
+	}
+	tx, err := db.begin()
+	if err != nil {
+		return models.Item{}, 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	before, err := snapshotLot(tx, id)
+	if err != nil {
+		return models.Item{}, 0, err
+	}
+	if before == nil || !before.DeletedAt.IsZero() {
+		return models.Item{}, 0, fmt.Errorf("item %s: %w", id, ErrItemNotFound)
+	}
+	if err = db.consumeLot(tx, id, quantity, time.Now()); err != nil {
+		return models.Item{}, 0, err
+	}
+	entry, err := lastLotAudit(tx, id)
+	if err != nil {
+		return models.Item{}, 0, err
+	}
+	return *before, entry, tx.Commit()
+}
+
+// lastLotAudit returns the id of the latest audit entry of the lot `id`, zero if it has none.
+func lastLotAudit(q rowQuerier, id string) (int64, error) {
+	var entry sql.NullInt64
+	err := q.QueryRow(`SELECT MAX(id) FROM audit_log WHERE entity=? AND entity_id=?;`, models.AuditItem, id).Scan(&entry)
+	if err != nil {
+		return 0, fmt.Errorf("error reading the audit log of item %s: %w", id, err)
+	}
+	return entry.Int64, nil
+}
+
+// ErrIncompatibleUnit is returned when an amount is given in a unit that can't be converted to the one of the lot, or
+// the lot is counted in pieces.
+var ErrIncompatibleUnit = errors.New("incompatible unit")
+
+// ConsumeAmount consumes `amount` `unit` of the measured lot `id`, e.g. 200 g of a 1 kg bag. The packages emptied are
+// recorded as consumed, and the lot is removed when nothing is left; consuming more than what's left fails with
+// ErrNotEnoughStock. It returns the lot after the change, with no units when it was used up.
+func (db *appdbimpl) ConsumeAmount(id string, amount float64, unit string) (models.Item, error) {
+	tx, err := db.begin()
+	if err != nil {
+		return models.Item{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	before, after, err := db.consumeAmount(tx, id, amount, unit, time.Now())
+	if err != nil {
+		return models.Item{}, err
+	}
+	if after == nil {
+		after = before
+		after.Quantity, after.Amount = 0, 0
+	}
+	return *after, tx.Commit()
+}
+
+// consumeAmount consumes `amount` `unit` of the measured lot `id` in `tx`, like ConsumeAmount. It returns the lot before
+// and after the change, nil when it was used up.
+func (db *appdbimpl) consumeAmount(tx *changeTx, id string, amount float64, unit string, now time.Time) (before, after *models.Item, err error) {
+	before, err = snapshotLot(tx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if before == nil || !before.DeletedAt.IsZero() {
+		return nil, nil, fmt.Errorf("item %s: %w", id, ErrItemNotFound)
+	}
+	used, ok := models.ConvertAmount(amount, unit, before.Unit)
+	if !ok {
+		return nil, nil, ErrIncompatibleUnit
+	}
+	if used <= 0 || math.IsNaN(used) {
+		return nil, nil, fmt.Errorf("invalid amount %v", amount)
+	}
+	if used > before.Amount {
+		return nil, nil, ErrNotEnoughStock
+	}
+
+	left := models.RoundAmount(before.Amount - used)
+	packages := models.PackagesOf(left, before.PackageAmount)
+	if _, err = tx.Exec(`UPDATE items SET quantity=?, amount=? WHERE id=?;`, packages, left, id); err != nil {
+		return nil, nil, fmt.Errorf("error consuming item %s: %w", id, err)
+	}
+	if _, err = tx.Exec(`DELETE FROM items WHERE id=? AND quantity <= 0;`, id); err != nil {
+		return nil, nil, fmt.Errorf("error removing consumed item %s: %w", id, err)
+	}
+	if emptied := before.Quantity - packages; emptied > 0 {
+		if err = recordMovement(tx, id, before.Barcode, -emptied, movementConsume, now); err != nil {
+			return nil, nil, err
+		}
+	}
+	after, err = snapshotLot(tx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = db.auditLot(tx, models.AuditConsume, before, after); err != nil {
+		return nil, nil, err
+	}
+	return before, after, nil
+}
+
+// ErrNothingToUndo is returned by UndoConsume when the consumption doesn't exist.
+var ErrNothingToUndo = errors.New("nothing to undo")
+
+// ErrUndoConflict is returned by UndoConsume when the lot changed after the consumption, or it was already undone.
+var ErrUndoConflict = errors.New("the item changed after the consumption")
+
+// UndoConsume gives back the units of the lot `id` consumed by ConsumeItem, whose audit entry is `entry`, recreating
+// the lot from the audit log when it was used up. The consumption is dropped from the movements, as if it never
+// happened. Only the latest change of the lot can be undone: a later one fails with ErrUndoConflict.
+func (db *appdbimpl) UndoConsume(id string, entry int64) error {
+	tx, err := db.begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// the consumption tells what the lot was, and how much of a measured lot was taken
+	var stateBefore, stateAfter sql.NullString
+	err = tx.QueryRow(`
+		SELECT before, after FROM audit_log WHERE id=? AND entity=? AND entity_id=? AND action=?;`,
+		entry, models.AuditItem, id, models.AuditConsume).Scan(&stateBefore, &stateAfter)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !stateBefore.Valid) {
+		return ErrNothingToUndo
+	} else if err != nil {
+		return err
+	}
+	last, err := lastLotAudit(tx, id)
+	if err != nil {
+		return err
+	}
+	if last != entry {
+		return ErrUndoConflict
+	}
+	var consumed, left lotState
+	if err = json.Unmarshal([]byte(stateBefore.String), &consumed); err != nil {
+		return fmt.Errorf("error reading the consumed item %s: %w", id, err)
+	}
+	if stateAfter.Valid {
+		if err = json.Unmarshal([]byte(stateAfter.String), &left); err != nil {
+			return fmt.Errorf("error reading the consumed item %s: %w", id, err)
+		}
+	}
+
+	// with no change after it, the consumption is the latest movement of the lot
+	delta := left.Quantity - consumed.Quantity
+	var movementId int64
+	var barcode string
+	err = tx.QueryRow(`
+		SELECT id, barcode FROM movements
+		WHERE item_id=? AND delta=? AND kind=? AND id=(SELECT MAX(id) FROM movements WHERE item_id=?);`,
+		id, delta, movementConsume, id).Scan(&movementId, &barcode)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrUndoConflict
+	} else if err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`DELETE FROM movements WHERE id=?;`, movementId); err != nil {
+		return err
+	}
+
+	before, err := snapshotLot(tx, id)
+	if err != nil {
+		return err
+	}
+	switch {
+	case before == nil:
+		// the lot was used up: recreate it as it was before the consumption
+		_, err = tx.Exec(`
+			INSERT INTO items (id, barcode, name, brand, quantity, unit, amount, package_amount, expiration_date,
+				added_at, location, note, label)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`,
+			id, barcode, consumed.Name, consumed.Brand, -delta, consumed.Unit, consumed.Amount, consumed.PackageAmount,
+			consumed.ExpirationDate, consumed.AdditionDate, consumed.Location, consumed.Note, consumed.Label)
+		if err != nil {
+			return fmt.Errorf("error recreating item %s: %w", id, err)
+		}
+	case before.DeletedAt.IsZero():
+		_, err = tx.Exec(`UPDATE items SET quantity = quantity + ?, amount = amount + ? WHERE id=?;`,
+			-delta, models.RoundAmount(max(consumed.Amount-left.Amount, 0)), id)
+		if err != nil {
+			return err
+		}
+	default:
+		// moved to the trash after the consumption, restoring it is up to the trash
+		return ErrNothingToUndo
+	}
+
+	after, err := snapshotLot(tx, id)
+	if err != nil {
+		return err
+	}
+	if err = db.auditLot(tx, models.AuditRestore, before, after); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteItem moves a lot to the trash, recording its remaining quantity as consumed. RestoreItem undoes it until the
+// lot is purged. It fails with ErrItemNotFound if the lot doesn't exist or is already in the trash.
 func (db *appdbimpl) DeleteItem(id string) error {
-	_, err := db.c.Exec("DELETE FROM items WHERE id=?;", id)
-	return err
+	tx, err := db.begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	before, err := snapshotLot(tx, id)
+	if err != nil {
+		return err
+	}
+	if before == nil || !before.DeletedAt.IsZero() {
+		return fmt.Errorf("item %s: %w", id, ErrItemNotFound)
+	}
+	if err = db.trashLot(tx, before, time.Now(), models.AuditDelete); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// trashLot moves the lot `before` to the trash at `now`, recording its remaining quantity as discarded and the change
+// in the audit log as `action`.
+func (db *appdbimpl) trashLot(tx *changeTx, before *models.Item, now time.Time, action string) error {
+	id := before.Id.String()
+	if _, err := tx.Exec("UPDATE items SET deleted_at=? WHERE id=?;", now.Format(models.DbTimeLayout), id); err != nil {
+		return err
+	}
+	if err := recordMovement(tx, id, before.Barcode, -before.Quantity, movementDiscard, now); err != nil {
+		return err
+	}
+	after, err := snapshotLot(tx, id)
+	if err != nil {
+		return err
+	}
+	return db.auditLot(tx, action, before, after)
 }
 
-func (db *appdbimpl) UpdateItem(id string, name string, brand string, date time.Time) error {
-	query := "UPDATE items SET name=?, brand=?, expiration_date=? WHERE id=?;"
-	_, err := db.c.Exec(query, name, brand, date.Format(models.DbTimeLayout), id)
-	return err
+// UpdateItem saves the editable fields of the lot `item.Id`: name, brand, quantity, location, expiration date,
+// addition date, note and label. A zero quantity or addition date keeps the stored one. A change of quantity is
+// recorded in the movements like a correction of the stocktake, and changes what's left of a measured lot by whole
+// packages. It fails with ErrItemNotFound if the lot doesn't exist or is in the trash.
+func (db *appdbimpl) UpdateItem(item models.Item) error {
+	tx, err := db.begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	before, err := snapshotLot(tx, item.Id.String())
+	if err != nil {
+		return err
+	}
+	if before == nil || !before.DeletedAt.IsZero() {
+		return fmt.Errorf("item %s: %w", item.Id, ErrItemNotFound)
+	}
+	if item.Quantity < 1 {
+		item.Quantity = before.Quantity
+	}
+	if item.AdditionDate.IsZero() {
+		item.AdditionDate = before.AdditionDate
+	}
+
+	query := `
+		UPDATE items SET name=?, brand=?, quantity=?, amount=?, location=?, expiration_date=?, added_at=?, note=?, label=?
+		WHERE id=?;`
+	_, err = tx.Exec(query,
+		item.Name,
+		item.Brand,
+		item.Quantity,
+		before.AmountFor(item.Quantity),
+		item.Location,
+		item.ExpirationDate.Format(models.DbTimeLayout),
+		item.AdditionDate.Format(models.DbTimeLayout),
+		item.Note,
+		item.Label,
+		item.Id.String(),
+	)
+	if err != nil {
+		return err
+	}
+	if delta := item.Quantity - before.Quantity; delta != 0 {
+		if err = recordMovement(tx, item.Id.String(), before.Barcode, delta, movementCorrection,
+			time.Now()); err != nil {
+			return err
+		}
+	}
+	after, err := snapshotLot(tx, item.Id.String())
+	if err != nil {
+		return err
+	}
+	if err = db.auditLot(tx, models.AuditUpdate, before, after); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SplitItem moves `part.Quantity` units of the lot `id` to a new lot, with the expiration date, location, note and
+// label of `part`, and returns its id. At least one unit must stay in the lot, or it fails with ErrNotEnoughStock; of a
+// measured lot, full packages are moved and the opened one stays. The units only change lot, so no movement is recorded.
+func (db *appdbimpl) SplitItem(id string, part models.Item) (string, error) {
+	tx, err := db.begin()
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	before, err := snapshotLot(tx, id)
+	if err != nil {
+		return "", err
+	}
+	if before == nil || !before.DeletedAt.IsZero() {
+		return "", fmt.Errorf("item %s: %w", id, ErrItemNotFound)
+	}
+	if part.Quantity < 1 || part.Quantity >= before.Quantity {
+		return "", ErrNotEnoughStock
+	}
+
+	newId, err := uuid.NewV7()
+	if err != nil {
+		return "", err
+	}
+	lot := *before
+	lot.Id = newId
+	lot.Quantity = part.Quantity
+	lot.Amount = models.RoundAmount(float64(part.Quantity) * lot.PackageAmount)
+	lot.ExpirationDate = part.ExpirationDate
+	lot.Location = part.Location
+	lot.Note = part.Note
+	lot.Label = part.Label
+
+	_, err = tx.Exec(`UPDATE items SET quantity = quantity - ?, amount = MAX(amount - ?, 0) WHERE id=?;`,
+		lot.Quantity, lot.Amount, id)
+	if err != nil {
+		return "", fmt.Errorf("error splitting item %s: %w", id, err)
+	}
+	if err = storeLot(tx, lot); err != nil {
+		return "", err
+	}
+
+	after, err := snapshotLot(tx, id)
+	if err != nil {
+		return "", err
+	}
+	if err = db.auditLot(tx, models.AuditSplit, before, after); err != nil {
+		return "", err
+	}
+	split, err := snapshotLot(tx, newId.String())
+	if err != nil {
+		return "", err
+	}
+	if err = db.auditLot(tx, models.AuditSplit, nil, split); err != nil {
+		return "", err
+	}
+	return newId.String(), tx.Commit()
 }
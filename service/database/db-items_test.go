@@ -0,0 +1,223 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/lorenzougolini/wimf-app/service/models"
+)
+
+// addLot stores a lot of `quantity` pieces.
+func addLot(t *testing.T, db AppDatabase, quantity int) string {
+	t.Helper()
+	lot := models.Item{
+		Quantity:       quantity,
+		ExpirationDate: time.Now().AddDate(0, 1, 0).Truncate(24 * time.Hour),
+		AdditionDate:   time.Now().Truncate(time.Second),
+		Location:       models.DefaultLocation,
+	}
+	id, err := db.AddItem(models.ProductInfo{Barcode: "8005678", Name: "Yogurt"}, lot, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// TestAdjustToZero takes every unit of a lot away, which uses it up, then adjusts it again.
+func TestAdjustToZero(t *testing.T) {
+	db := newTestDB(t)
+	id := addLot(t, db, 2)
+
+	if _, err := db.AdjustItemQuantity(id, -3); !errors.Is(err, ErrNotEnoughStock) {
+		t.Errorf("taking 3 of 2 units: %v, want ErrNotEnoughStock", err)
+	}
+	lot, err := db.AdjustItemQuantity(id, -2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lot.Quantity != 0 {
+		t.Errorf("lot after taking all of it = %d units, want 0", lot.Quantity)
+	}
+	if _, err = db.GetItemById(id); !errors.Is(err, ErrItemNotFound) {
+		t.Errorf("lot used up: %v, want ErrItemNotFound", err)
+	}
+	if _, err = db.AdjustItemQuantity(id, 1); !errors.Is(err, ErrItemNotFound) {
+		t.Errorf("adjusting the used up lot: %v, want ErrItemNotFound", err)
+	}
+}
+
+// addMeasuredLot stores `packages` packages of 1000 g and takes `used` grams of them.
+func addMeasuredLot(t *testing.T, db AppDatabase, packages int, used float64) string {
+	t.Helper()
+	lot := models.Item{
+		Quantity:       packages,
+		Unit:           models.UnitGram,
+		PackageAmount:  1000,
+		ExpirationDate: time.Now().AddDate(0, 1, 0).Truncate(24 * time.Hour),
+		AdditionDate:   time.Now().Truncate(time.Second),
+		Location:       models.DefaultLocation,
+	}
+	id, err := db.AddItem(models.ProductInfo{Barcode: "8001234", Name: "Farina"}, lot, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used > 0 {
+		if _, err = db.ConsumeAmount(id, used, models.UnitGram); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return id
+}
+
+func TestConsumePartlyUsedMeasuredLot(t *testing.T) {
+	db := newTestDB(t)
+	id := addMeasuredLot(t, db, 2, 500)
+
+	// the opened package goes first, the sealed one is left
+	lot, _, err := db.ConsumeItem(id, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lot.Quantity != 2 || lot.Amount != 1500 {
+		t.Fatalf("lot before the consumption = %d packages, %v g; want 2, 1500", lot.Quantity, lot.Amount)
+	}
+	lot, err = db.GetItemById(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lot.Quantity != 1 || lot.Amount != 1000 {
+		t.Errorf("lot after the consumption = %d packages, %v g; want 1, 1000", lot.Quantity, lot.Amount)
+	}
+}
+
+func TestAdjustPartlyUsedMeasuredLot(t *testing.T) {
+	db := newTestDB(t)
+	id := addMeasuredLot(t, db, 3, 300)
+
+	lot, err := db.AdjustItemQuantity(id, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lot.Quantity != 2 || lot.Amount != 2000 {
+		t.Errorf("lot taken a package = %d packages, %v g; want 2, 2000", lot.Quantity, lot.Amount)
+	}
+	lot, err = db.AdjustItemQuantity(id, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lot.Quantity != 3 || lot.Amount != 3000 {
+		t.Errorf("lot given a package = %d packages, %v g; want 3, 3000", lot.Quantity, lot.Amount)
+	}
+}
+
+func TestUndoConsumePartlyUsedMeasuredLot(t *testing.T) {
+	db := newTestDB(t)
+	id := addMeasuredLot(t, db, 2, 500)
+
+	_, at, err := db.ConsumeItem(id, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.UndoConsume(id, at); err != nil {
+		t.Fatal(err)
+	}
+	lot, err := db.GetItemById(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lot.Quantity != 2 || lot.Amount != 1500 {
+		t.Errorf("lot after the undo = %d packages, %v g; want 2, 1500", lot.Quantity, lot.Amount)
+	}
+}
+
+func TestUndoOneOfTwoConsumptions(t *testing.T) {
+	db := newTestDB(t)
+	id := addLot(t, db, 3)
+
+	_, first, err := db.ConsumeItem(id, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, second, err := db.ConsumeItem(id, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Fatalf("both consumptions have the audit entry %d", first)
+	}
+
+	// the first consumption is no longer the latest change of the lot
+	if err = db.UndoConsume(id, first); !errors.Is(err, ErrUndoConflict) {
+		t.Errorf("undoing the first consumption: %v, want ErrUndoConflict", err)
+	}
+	if err = db.UndoConsume(id, second); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.UndoConsume(id, second); !errors.Is(err, ErrUndoConflict) {
+		t.Errorf("undoing the second consumption again: %v, want ErrUndoConflict", err)
+	}
+	if err = db.UndoConsume(id, 0); !errors.Is(err, ErrNothingToUndo) {
+		t.Errorf("undoing an unknown consumption: %v, want ErrNothingToUndo", err)
+	}
+
+	lot, err := db.GetItemById(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lot.Quantity != 2 {
+		t.Errorf("lot = %d units, want 2", lot.Quantity)
+	}
+	stats, err := db.GetConsumptionStats(time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if consumed := stats["8005678"].Consumed; consumed != 1 {
+		t.Errorf("%d units consumed, want 1", consumed)
+	}
+}
+
+func TestUndoConsumeUsedUpLot(t *testing.T) {
+	db := newTestDB(t)
+	id := addLot(t, db, 1)
+
+	_, entry, err := db.ConsumeItem(id, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.GetItemById(id); !errors.Is(err, ErrItemNotFound) {
+		t.Fatalf("used up lot: %v, want ErrItemNotFound", err)
+	}
+	if err = db.UndoConsume(id, entry); err != nil {
+		t.Fatal(err)
+	}
+	lot, err := db.GetItemById(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lot.Quantity != 1 || lot.Name != "Yogurt" {
+		t.Errorf("recreated lot = %+v", lot)
+	}
+}
+
+func TestUpdateMissingLot(t *testing.T) {
+	db := newTestDB(t)
+	id := addLot(t, db, 2)
+	lot, err := db.GetItemById(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	missing := lot
+	missing.Id = uuid.Must(uuid.NewV7())
+	if err = db.UpdateItem(missing); !errors.Is(err, ErrItemNotFound) {
+		t.Errorf("updating a missing lot: %v, want ErrItemNotFound", err)
+	}
+	if err = db.DeleteItem(id); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.UpdateItem(lot); !errors.Is(err, ErrItemNotFound) {
+		t.Errorf("updating a lot in the trash: %v, want ErrItemNotFound", err)
+	}
+}
@@ -0,0 +1,70 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/lorenzougolini/wimf-app/service/models"
+)
+
+// lotColumns are the columns of a single lot in the `items` table, in the order expected by scanLot.
+const lotColumns = `id, barcode, name, brand, quantity, unit, amount, package_amount, expiration_date, added_at, location,
+	note, label`
+
+// lotColumnsOf returns lotColumns qualified with a table alias, for queries joining other tables.
+func lotColumnsOf(alias string) string {
+	columns := strings.Split(lotColumns, ",")
+	for i, c := range columns {
+		columns[i] = alias + "." + strings.TrimSpace(c)
+	}
+	return strings.Join(columns, ", ")
+}
+
+// scanner is implemented by both *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// lotScan holds the scan destinations of lotColumns, so that queries can select more columns after them.
+type lotScan struct {
+	i        models.Item
+	exp, add sql.NullString
+}
+
+func (l *lotScan) dest() []any {
+	return []any{
+		&l.i.Id,
+		&l.i.Barcode,
+		&l.i.Name,
+		&l.i.Brand,
+		&l.i.Quantity,
+		&l.i.Unit,
+		&l.i.Amount,
+		&l.i.PackageAmount,
+		&l.exp,
+		&l.add,
+		&l.i.Location,
+		&l.i.Note,
+		&l.i.Label,
+	}
+}
+
+func (l *lotScan) item() models.Item {
+	if l.exp.Valid {
+		l.i.ExpirationDate, _ = time.Parse(models.DbTimeLayout, l.exp.String)
+	}
+	if l.add.Valid {
+		l.i.AdditionDate, _ = time.Parse(models.DbTimeLayout, l.add.String)
+	}
+	return l.i
+}
+
+// scanLot reads a lot selected with lotColumns.
+func scanLot(row scanner) (models.Item, error) {
+	var l lotScan
+	if err := row.Scan(l.dest()...); err != nil {
+		return models.Item{}, err
+	}
+	return l.item(), nil
+}
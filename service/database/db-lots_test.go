@@ -0,0 +1,19 @@
+package database
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestLotColumnsOf checks that every column is qualified, wherever lotColumns wraps.
+func TestLotColumnsOf(t *testing.T) {
+	columns := strings.Split(lotColumnsOf("i"), ", ")
+	if len(columns) != strings.Count(lotColumns, ",")+1 {
+		t.Fatalf("columns = %q", columns)
+	}
+	for _, c := range columns {
+		if !strings.HasPrefix(c, "i.") || strings.ContainsAny(c, " \t\n") {
+			t.Errorf("column %q not qualified", c)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package database
+
+import (
+	"fmt"
+
+	"github.com/lorenzougolini/wimf-app/service/models"
+)
+
+// MergeDuplicateLots folds the lots in stock that are identical to an older one (see identicalLotCondition) into it,
+// all in one transaction: the oldest lot gets the units, the movements and the ingredients of the others, which are
+// removed and audited as merged. A lot whose units don't fit in the oldest one (see models.MaxLotQuantity) is kept, and
+// the next identical lots are merged into it. It refuses to run during a stocktake, whose snapshot refers to the lots.
+// With `dryRun` the transaction is rolled back, so the result only tells how many lots would be merged.
+func (db *appdbimpl) MergeDuplicateLots(dryRun bool) (int, error) {
+	tx, err := db.begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var stocktakes int
+	if err = tx.QueryRow(`SELECT COUNT(*) FROM stocktake;`).Scan(&stocktakes); err != nil {
+		return 0, err
+	} else if stocktakes > 0 {
+		return 0, ErrStocktakeInProgress
+	}
+
+	rows, err := tx.Query(`
+		SELECT ` + lotColumns + `
+		FROM items
+		WHERE deleted_at IS NULL
+		ORDER BY barcode, expiration_date, location, unit, package_amount, note, label, added_at ASC, id ASC;`)
+	if err != nil {
+		return 0, err
+	}
+	var lots []models.Item
+	for rows.Next() {
+		lot, err := scanLot(rows)
+		if err != nil {
+			_ = rows.Close()
+			return 0, err
+		}
+		lots = append(lots, lot)
+	}
+	_ = rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	merged := 0
+	var keep models.Item
+	for i, lot := range lots {
+		if i == 0 || !identicalLots(keep, lot) || keep.Quantity+lot.Quantity > models.MaxLotQuantity {
+			keep = lot
+			continue
+		}
+		if err = db.mergeLot(tx, keep.Id.String(), lot.Id.String()); err != nil {
+			return 0, err
+		}
+		keep.Quantity += lot.Quantity
+		merged++
+	}
+
+	if dryRun {
+		return merged, nil
+	}
+	return merged, tx.Commit()
+}
+
+// identicalLots tells if two lots in stock could be a single one, like identicalLotCondition.
+func identicalLots(a, b models.Item) bool {
+	return a.Barcode == b.Barcode && a.ExpirationDate.Equal(b.ExpirationDate) && a.Location == b.Location &&
+		a.Unit == b.Unit && a.PackageAmount == b.PackageAmount && a.Note == b.Note && a.Label == b.Label
+}
+
+// mergeLot moves the units, the movements and the ingredients of the lot `id` to the lot `into`, then removes it.
+func (db *appdbimpl) mergeLot(tx *changeTx, into string, id string) error {
+	before, err := snapshotLot(tx, into)
+	if err != nil {
+		return err
+	}
+	merged, err := snapshotLot(tx, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE items SET quantity = quantity + ?, amount = amount + ? WHERE id=?;`,
+		merged.Quantity, merged.Amount, into)
+	if err != nil {
+		return fmt.Errorf("error merging item %s into %s: %w", id, into, err)
+	}
+	if _, err = tx.Exec(`UPDATE movements SET item_id=? WHERE item_id=?;`, into, id); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO item_sources (item_id, source_id, barcode, name, brand, quantity, amount, unit)
+		SELECT ?, source_id, barcode, name, brand, quantity, amount, unit FROM item_sources WHERE item_id=?
+		ON CONFLICT (item_id, source_id) DO UPDATE
+		SET quantity = quantity + excluded.quantity, amount = amount + excluded.amount;`, into, id)
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`DELETE FROM item_sources WHERE item_id=?;`, id); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`DELETE FROM items WHERE id=?;`, id); err != nil {
+		return fmt.Errorf("error removing merged item %s: %w", id, err)
+	}
+
+	after, err := snapshotLot(tx, into)
+	if err != nil {
+		return err
+	}
+	if err = db.auditLot(tx, models.AuditMerge, merged, nil); err != nil {
+		return err
+	}
+	return db.auditLot(tx, models.AuditMerge, before, after)
+}
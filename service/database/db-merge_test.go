@@ -0,0 +1,128 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lorenzougolini/wimf-app/service/models"
+)
+
+// TestMergeIdenticalLots scans the same yogurt several times: the units go to one lot unless kept separate, and the
+// duplicates left are merged by MergeDuplicateLots, which keeps the lots stored elsewhere apart.
+func TestMergeIdenticalLots(t *testing.T) {
+	db := newTestDB(t)
+	add := func(quantity int, location string, separate bool) string {
+		t.Helper()
+		lot := models.Item{
+			Quantity:       quantity,
+			ExpirationDate: time.Now().AddDate(0, 1, 0).Truncate(24 * time.Hour),
+			AdditionDate:   time.Now().Truncate(time.Second),
+			Location:       location,
+		}
+		id, err := db.AddItem(models.ProductInfo{Barcode: "8005678", Name: "Yogurt"}, lot, separate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	stock := func() map[string]int {
+		t.Helper()
+		_, lots, err := db.GetItemsByBarcode("8005678")
+		if err != nil {
+			t.Fatal(err)
+		}
+		units := map[string]int{}
+		for _, lot := range lots {
+			units[lot.Id.String()] = lot.Quantity
+		}
+		return units
+	}
+
+	first := add(2, models.DefaultLocation, false)
+	if id := add(3, models.DefaultLocation, false); id != first {
+		t.Errorf("identical lot added as %s, want it merged into %s", id, first)
+	}
+	duplicate := add(1, models.DefaultLocation, true)
+	frozen := add(4, "Freezer", false)
+	if duplicate == first || frozen == first {
+		t.Fatal("lot kept separate or stored elsewhere merged into the first one")
+	}
+
+	if err := db.StartStocktake(models.DefaultLocation); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.MergeDuplicateLots(false); !errors.Is(err, ErrStocktakeInProgress) {
+		t.Errorf("merging during a stocktake: %v, want ErrStocktakeInProgress", err)
+	}
+	if err := db.CancelStocktake(); err != nil {
+		t.Fatal(err)
+	}
+
+	if merged, err := db.MergeDuplicateLots(true); err != nil || merged != 1 {
+		t.Fatalf("dry run = %d, %v; want 1 lot to merge", merged, err)
+	}
+	if units := stock(); len(units) != 3 {
+		t.Errorf("lots after the dry run = %v, want the 3 lots as before", units)
+	}
+	if merged, err := db.MergeDuplicateLots(false); err != nil || merged != 1 {
+		t.Fatalf("merge = %d, %v; want 1 lot merged", merged, err)
+	}
+	want := map[string]int{first: 6, frozen: 4}
+	if units := stock(); len(units) != len(want) || units[first] != want[first] || units[frozen] != want[frozen] {
+		t.Errorf("lots = %v, want %v", units, want)
+	}
+
+	audit, err := db.GetAudit(models.AuditFilter{Action: models.AuditMerge}, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(audit) != 2 {
+		t.Errorf("%d merge entries audited, want the lot removed and the one getting its units", len(audit))
+	}
+}
+
+// TestMergeIdenticalLotsOverflow adds identical lots whose units together exceed models.MaxLotQuantity: they're kept
+// apart, both when adding and by MergeDuplicateLots, so that no lot grows beyond what the forms can save.
+func TestMergeIdenticalLotsOverflow(t *testing.T) {
+	db := newTestDB(t)
+	add := func(quantity int, separate bool) string {
+		t.Helper()
+		lot := models.Item{
+			Quantity:       quantity,
+			ExpirationDate: time.Now().AddDate(0, 1, 0).Truncate(24 * time.Hour),
+			AdditionDate:   time.Now().Truncate(time.Second),
+			Location:       models.DefaultLocation,
+		}
+		id, err := db.AddItem(models.ProductInfo{Barcode: "8005678", Name: "Yogurt"}, lot, separate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	full := add(models.MaxLotQuantity, false)
+	if id := add(models.MaxLotQuantity, false); id == full {
+		t.Errorf("identical lot of %d units merged into one of %d", models.MaxLotQuantity, models.MaxLotQuantity)
+	}
+	small := add(2, true)
+	add(3, true)
+
+	if merged, err := db.MergeDuplicateLots(false); err != nil || merged != 1 {
+		t.Fatalf("merge = %d, %v; want only the 2 small lots merged", merged, err)
+	}
+	_, lots, err := db.GetItemsByBarcode("8005678")
+	if err != nil {
+		t.Fatal(err)
+	}
+	units := map[string]int{}
+	for _, lot := range lots {
+		if lot.Quantity > models.MaxLotQuantity {
+			t.Errorf("lot %s has %d units, more than %d", lot.Id, lot.Quantity, models.MaxLotQuantity)
+		}
+		units[lot.Id.String()] = lot.Quantity
+	}
+	if len(units) != 3 || units[full] != models.MaxLotQuantity || units[small] != 5 {
+		t.Errorf("lots = %v, want the 2 full lots and %s with 5 units", units, small)
+	}
+}
@@ -0,0 +1,62 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lorenzougolini/wimf-app/service/models"
+)
+
+// execer is implemented by both *sql.DB and *sql.Tx, so helpers can run inside or outside a transaction.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// Kinds of the movements, telling the consumptions apart from the other changes of quantity.
+const (
+	movementAdd        = "add"
+	movementConsume    = "consume"
+	movementDiscard    = "discard"    // lots deleted or found missing by a stocktake, moved to the trash
+	movementCorrection = "correction" // quantities fixed by an edit, a stocktake or an import
+)
+
+// recordMovement appends a quantity change of a lot to the history, of one of the movement kinds. Only the movements
+// of kind movementConsume count as consumed.
+func recordMovement(e execer, itemId string, barcode string, delta int, kind string, at time.Time) error {
+	_, err := e.Exec(`INSERT INTO movements (item_id, barcode, delta, kind, moved_at) VALUES (?, ?, ?, ?, ?);`,
+		itemId, barcode, delta, kind, at.Format(models.DbTimeLayout))
+	if err != nil {
+		return fmt.Errorf("error recording movement of item %s: %w", itemId, err)
+	}
+	return nil
+}
+
+// GetConsumptionStats returns, for each barcode with a history, the units consumed since `since` and the date of its
+// first recorded movement. Discarded lots and corrections of the quantity aren't consumptions.
+func (db *appdbimpl) GetConsumptionStats(since time.Time) (map[string]models.ConsumptionStats, error) {
+	query := `
+		SELECT barcode,
+			COALESCE(-SUM(CASE WHEN kind = ? AND moved_at >= ? THEN delta END), 0) as consumed,
+			MIN(moved_at) as first_movement
+		FROM movements
+		GROUP BY barcode;
+	`
+	rows, err := db.c.Query(query, movementConsume, since.Format(models.DbTimeLayout))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := make(map[string]models.ConsumptionStats)
+	for rows.Next() {
+		var s models.ConsumptionStats
+		var first string
+		if err := rows.Scan(&s.Barcode, &s.Consumed, &first); err != nil {
+			return nil, err
+		}
+		s.FirstMovement, _ = time.Parse(models.DbTimeLayout, first)
+		stats[s.Barcode] = s
+	}
+	return stats, rows.Err()
+}
@@ -0,0 +1,46 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+// TestConsumptionStatsCountsConsumptionsOnly changes the quantity of a lot in every way: only the units used count as
+// consumed, not the ones fixed by an edit or thrown away with the lot.
+func TestConsumptionStatsCountsConsumptionsOnly(t *testing.T) {
+	db := newTestDB(t)
+	id := addLot(t, db, 6)
+	consumed := func(want int) {
+		t.Helper()
+		stats, err := db.GetConsumptionStats(time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := stats["8005678"].Consumed; got != want {
+			t.Errorf("%d units consumed, want %d", got, want)
+		}
+	}
+
+	if _, _, err := db.ConsumeItem(id, 2); err != nil {
+		t.Fatal(err)
+	}
+	consumed(2)
+	if _, err := db.AdjustItemQuantity(id, -1); err != nil {
+		t.Fatal(err)
+	}
+	consumed(3)
+
+	lot, err := db.GetItemById(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lot.Quantity = 2
+	if err = db.UpdateItem(lot); err != nil {
+		t.Fatal(err)
+	}
+	consumed(3)
+	if err = db.DeleteItem(id); err != nil {
+		t.Fatal(err)
+	}
+	consumed(3)
+}
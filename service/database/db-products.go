@@ -0,0 +1,154 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lorenzougolini/wimf-app/service/models"
+)
+
+// seedProduct makes sure the product of a barcode exists. The category is only used when the product has none yet, so
+// categories chosen by the user are never overwritten by the ones coming from Open Food Facts.
+func seedProduct(e execer, barcode string, category string) error {
+	_, err := e.Exec(`
+		INSERT INTO products (barcode, category) VALUES (?, ?)
+		ON CONFLICT (barcode) DO UPDATE SET category = excluded.category WHERE products.category = '';`,
+		barcode, category)
+	if err != nil {
+		return fmt.Errorf("error seeding product %s: %w", barcode, err)
+	}
+	return nil
+}
+
+// productCategory returns the category of the product `barcode`, empty when it's not known.
+func productCategory(q rowQuerier, barcode string) (string, error) {
+	var category string
+	err := q.QueryRow(`SELECT category FROM products WHERE barcode=?;`, barcode).Scan(&category)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("error reading the category of product %s: %w", barcode, err)
+	}
+	return category, nil
+}
+
+// ErrProductNotFound is returned when looking up a product id that doesn't exist.
+var ErrProductNotFound = errors.New("product not found")
+
+func (db *appdbimpl) GetProduct(barcode string) (models.Product, error) {
+	product := models.Product{Barcode: barcode}
+	err := db.c.QueryRow(`SELECT id, category FROM products WHERE barcode=?;`, barcode).
+		Scan(&product.Id, &product.Category)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return product, err
+	}
+
+	rows, err := db.c.Query(`SELECT tag FROM product_tags WHERE barcode=? ORDER BY tag ASC;`, barcode)
+	if err != nil {
+		return product, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return product, err
+		}
+		product.Tags = append(product.Tags, tag)
+	}
+	return product, rows.Err()
+}
+
+// GetProductById returns the product with the numeric `id`, or ErrProductNotFound.
+func (db *appdbimpl) GetProductById(id int64) (models.Product, error) {
+	var barcode string
+	err := db.c.QueryRow(`SELECT barcode FROM products WHERE id=?;`, id).Scan(&barcode)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Product{}, ErrProductNotFound
+	} else if err != nil {
+		return models.Product{}, err
+	}
+	return db.GetProduct(barcode)
+}
+
+// UpdateProduct overrides the category of a product and replaces its tags.
+func (db *appdbimpl) UpdateProduct(product models.Product) error {
+	before, err := db.GetProduct(product.Barcode)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.Exec(`
+		INSERT INTO products (barcode, category) VALUES (?, ?)
+		ON CONFLICT (barcode) DO UPDATE SET category = excluded.category;`,
+		product.Barcode, product.Category)
+	if err != nil {
+		return fmt.Errorf("error updating product %s: %w", product.Barcode, err)
+	}
+
+	if _, err = tx.Exec(`DELETE FROM product_tags WHERE barcode=?;`, product.Barcode); err != nil {
+		return fmt.Errorf("error clearing tags of %s: %w", product.Barcode, err)
+	}
+	for _, tag := range product.Tags {
+		_, err = tx.Exec(`INSERT OR IGNORE INTO product_tags (barcode, tag) VALUES (?, ?);`, product.Barcode, tag)
+		if err != nil {
+			return fmt.Errorf("error tagging %s: %w", product.Barcode, err)
+		}
+	}
+	var name string
+	err = tx.QueryRow(`SELECT COALESCE((SELECT name FROM items WHERE barcode=? LIMIT 1), '');`, product.Barcode).Scan(&name)
+	if err != nil {
+		return err
+	}
+	if err = db.auditProduct(tx, name, before, product); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// fridgeConditions translates a models.FridgeFilter to the WHERE conditions (and their arguments) of a query on the
+// `items` table aliased as `i`, joined with `products` aliased as `p`.
+func (db *appdbimpl) fridgeConditions(filter models.FridgeFilter) (string, []any) {
+	conditions := []string{"i.deleted_at IS NULL"}
+	var args []any
+
+	if filter.Category == models.OtherCategory {
+		conditions = append(conditions, "COALESCE(p.category, '') IN ('', ?)")
+		args = append(args, models.OtherCategory)
+	} else if filter.Category != "" {
+		conditions = append(conditions, "p.category = ?")
+		args = append(args, filter.Category)
+	}
+	if filter.Location != "" {
+		conditions = append(conditions, "i.location = ?")
+		args = append(args, filter.Location)
+	}
+	if filter.Expired {
+		conditions = append(conditions, "i.expiration_date < ?")
+		args = append(args, time.Now().Format(models.DbTimeLayout))
+	} else if filter.Within > 0 {
+		conditions = append(conditions, "i.expiration_date < ?")
+		args = append(args, time.Now().AddDate(0, 0, filter.Within).Format(models.DbTimeLayout))
+	}
+	if match := ftsQuery(filter.Query); db.fts && match != "" {
+		conditions = append(conditions, "i.id IN (SELECT item_id FROM search_index WHERE search_index MATCH ?)")
+		args = append(args, match)
+	} else if filter.Query != "" {
+		conditions = append(conditions, "(i.name LIKE ? OR i.brand LIKE ? OR i.note LIKE ?)")
+		like := "%" + filter.Query + "%"
+		args = append(args, like, like, like)
+	}
+	for _, tag := range filter.Tags {
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM product_tags t WHERE t.barcode = i.barcode AND t.tag = ?)")
+		args = append(args, tag)
+	}
+
+	return strings.Join(conditions, " AND "), args
+}
@@ -0,0 +1,80 @@
+package database
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/lorenzougolini/wimf-app/service/models"
+)
+
+// TestProductCategoryAndTags changes the category and the tags of a product: the category chosen by the user must
+// survive the next lots coming with the one of Open Food Facts, and both must drive the fridge filters and groups.
+func TestProductCategoryAndTags(t *testing.T) {
+	db := newTestDB(t)
+	lot := models.Item{
+		Quantity:       1,
+		ExpirationDate: time.Now().AddDate(0, 1, 0).Truncate(24 * time.Hour),
+		AdditionDate:   time.Now().Truncate(time.Second),
+		Location:       models.DefaultLocation,
+	}
+	add := func(info models.ProductInfo) {
+		t.Helper()
+		if _, err := db.AddItem(info, lot, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	yogurt := models.ProductInfo{Barcode: "8005678", Name: "Yogurt", Category: "dairy"}
+	add(yogurt)
+	add(models.ProductInfo{Barcode: "8001234", Name: "Farina"})
+
+	if product, err := db.GetProduct("8005678"); err != nil {
+		t.Fatal(err)
+	} else if product.Category != "dairy" || len(product.Tags) != 0 {
+		t.Errorf("product = %+v, want dairy without tags", product)
+	}
+
+	err := db.UpdateProduct(models.Product{Barcode: "8005678", Category: "snacks", Tags: []string{"colazione", "bio"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	add(yogurt)
+	product, err := db.GetProduct("8005678")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if product.Category != "snacks" || !slices.Equal(product.Tags, []string{"bio", "colazione"}) {
+		t.Errorf("product = %+v, want snacks tagged bio and colazione", product)
+	}
+
+	for _, tt := range []struct {
+		filter models.FridgeFilter
+		want   []string
+	}{
+		{models.FridgeFilter{Tags: []string{"bio"}}, []string{"8005678"}},
+		{models.FridgeFilter{Tags: []string{"bio", "vegano"}}, nil},
+		{models.FridgeFilter{Category: "snacks"}, []string{"8005678"}},
+		{models.FridgeFilter{Category: models.OtherCategory}, []string{"8001234"}},
+	} {
+		items, _, err := db.GetFridge(tt.filter, "", 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var barcodes []string
+		for _, item := range items {
+			barcodes = append(barcodes, item.Barcode)
+		}
+		if !slices.Equal(barcodes, tt.want) {
+			t.Errorf("fridge filtered by %+v = %v, want %v", tt.filter, barcodes, tt.want)
+		}
+	}
+
+	summary, err := db.GetCategorySummary(models.FridgeFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summary) != 2 || summary[0].Category != models.OtherCategory || summary[0].Quantity != 1 ||
+		summary[1].Category != "snacks" || summary[1].Products != 1 || summary[1].Quantity != 2 {
+		t.Errorf("summary = %+v, want 1 unit in other and 2 of one product in snacks", summary)
+	}
+}
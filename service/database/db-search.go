@@ -0,0 +1,210 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/lorenzougolini/wimf-app/service/models"
+)
+
+// searchSchema rebuilds the FTS5 index over the lots and the triggers keeping it in sync. Tags belong to products, so
+// they are copied to every lot of the barcode. Diacritics are removed so that "caffe" also finds "caffè".
+const searchSchema = `
+	DROP TABLE IF EXISTS search_index;
+	CREATE VIRTUAL TABLE search_index USING fts5(
+		item_id UNINDEXED, barcode UNINDEXED, name, brand, note, tags,
+		tokenize = 'unicode61 remove_diacritics 2', prefix = '2 3'
+	);
+	CREATE TRIGGER search_items_insert AFTER INSERT ON items BEGIN
+		INSERT INTO search_index (item_id, barcode, name, brand, note, tags) VALUES (NEW.id, NEW.barcode, NEW.name,
+			NEW.brand, NEW.note, COALESCE((SELECT GROUP_CONCAT(tag, ' ') FROM product_tags WHERE barcode = NEW.barcode), ''));
+	END;
+	CREATE TRIGGER search_items_update AFTER UPDATE OF name, brand, note ON items BEGIN
+		UPDATE search_index SET name = NEW.name, brand = NEW.brand, note = NEW.note WHERE item_id = NEW.id;
+	END;
+	CREATE TRIGGER search_items_delete AFTER DELETE ON items BEGIN
+		DELETE FROM search_index WHERE item_id = OLD.id;
+	END;
+	CREATE TRIGGER search_tags_insert AFTER INSERT ON product_tags BEGIN
+		UPDATE search_index SET tags = (SELECT GROUP_CONCAT(tag, ' ') FROM product_tags WHERE barcode = NEW.barcode)
+		WHERE barcode = NEW.barcode;
+	END;
+	CREATE TRIGGER search_tags_delete AFTER DELETE ON product_tags BEGIN
+		UPDATE search_index SET tags = COALESCE((SELECT GROUP_CONCAT(tag, ' ') FROM product_tags WHERE barcode = OLD.barcode), '')
+		WHERE barcode = OLD.barcode;
+	END;
+	INSERT INTO search_index (item_id, barcode, name, brand, note, tags)
+	SELECT i.id, i.barcode, i.name, i.brand, i.note,
+		COALESCE((SELECT GROUP_CONCAT(tag, ' ') FROM product_tags t WHERE t.barcode = i.barcode), '')
+	FROM items i;`
+
+// searchTriggers are the triggers created by searchSchema.
+var searchTriggers = []string{
+	"search_items_insert", "search_items_update", "search_items_delete", "search_tags_insert", "search_tags_delete",
+}
+
+// setupSearch makes sure the full-text index is available and in sync. It reports false when SQLite was built without
+// FTS5 (i.e., without the `sqlite_fts5` build tag): search then falls back to plain LIKE matching, and the triggers are
+// dropped so that writes keep working. The index is rebuilt on the next start with FTS5.
+func setupSearch(db *sql.DB) (bool, error) {
+	_, err := db.Exec(`CREATE VIRTUAL TABLE temp.fts5_probe USING fts5(x); DROP TABLE temp.fts5_probe;`)
+	if err != nil && !strings.Contains(err.Error(), "no such module: fts5") {
+		return false, fmt.Errorf("error checking full-text search support: %w", err)
+	} else if err != nil {
+		for _, trigger := range searchTriggers {
+			if _, err := db.Exec(`DROP TRIGGER IF EXISTS ` + trigger + `;`); err != nil {
+				return false, fmt.Errorf("error disabling search trigger %s: %w", trigger, err)
+			}
+		}
+		return false, nil
+	}
+
+	var triggers int
+	err = db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type='trigger' AND name LIKE 'search_%';`).Scan(&triggers)
+	if err != nil {
+		return false, err
+	}
+	if triggers == len(searchTriggers) {
+		return true, nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for _, trigger := range searchTriggers {
+		if _, err := tx.Exec(`DROP TRIGGER IF EXISTS ` + trigger + `;`); err != nil {
+			return false, err
+		}
+	}
+	if _, err = tx.Exec(searchSchema); err != nil {
+		return false, fmt.Errorf("error creating search index: %w", err)
+	}
+	return true, tx.Commit()
+}
+
+// ftsQuery turns the user input into an FTS5 query where every word is matched as a prefix, e.g. `lat gran` becomes
+// `"lat"* "gran"*`. It returns an empty string when there is nothing to search.
+func ftsQuery(input string) string {
+	words := strings.FieldsFunc(input, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, w := range words {
+		words[i] = `"` + w + `"*`
+	}
+	return strings.Join(words, " ")
+}
+
+// Search returns the lots matching `input` in their name, brand, note or tags, best matches first.
+func (db *appdbimpl) Search(input string, limit int) ([]models.SearchHit, error) {
+	if !db.fts {
+		return db.searchLike(input, limit)
+	}
+
+	match := ftsQuery(input)
+	if match == "" {
+		return nil, nil
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s,
+			highlight(search_index, 2, ?, ?), highlight(search_index, 3, ?, ?),
+			highlight(search_index, 4, ?, ?), highlight(search_index, 5, ?, ?)
+		FROM search_index s
+		JOIN items i ON i.id = s.item_id
+		WHERE search_index MATCH ? AND i.deleted_at IS NULL
+		ORDER BY rank
+		LIMIT ?;`, lotColumnsOf("i"))
+	args := []any{}
+	for c := 0; c < 4; c++ {
+		args = append(args, models.HighlightStart, models.HighlightEnd)
+	}
+	args = append(args, match, limit)
+
+	rows, err := db.c.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hits []models.SearchHit
+	for rows.Next() {
+		var h models.SearchHit
+		var lot lotScan
+		dest := append(lot.dest(), &h.Name, &h.Brand, &h.Note, &h.Tags)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		h.Item = lot.item()
+		hits = append(hits, h)
+	}
+	return hits, rows.Err()
+}
+
+// searchLike is the fallback of Search without FTS5: every word of `input` must be in the name, brand, note or tags
+// of a lot, ignoring case and diacritics like the full-text index, without highlighting and by name. The lots are
+// matched in Go, as SQLite's LIKE only ignores the case of ASCII letters.
+func (db *appdbimpl) searchLike(input string, limit int) ([]models.SearchHit, error) {
+	words := strings.FieldsFunc(foldText(input), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) == 0 {
+		return nil, nil
+	}
+
+	rows, err := db.c.Query(fmt.Sprintf(`
+		SELECT %s, COALESCE((SELECT GROUP_CONCAT(tag, ' ') FROM product_tags t WHERE t.barcode = i.barcode), '')
+		FROM items i
+		WHERE i.deleted_at IS NULL
+		ORDER BY i.name ASC;`, lotColumnsOf("i")))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hits []models.SearchHit
+	for rows.Next() && len(hits) < limit {
+		var h models.SearchHit
+		var lot lotScan
+		if err := rows.Scan(append(lot.dest(), &h.Tags)...); err != nil {
+			return nil, err
+		}
+		h.Item = lot.item()
+		h.Name, h.Brand, h.Note = h.Item.Name, h.Item.Brand, h.Item.Note
+		text := foldText(strings.Join([]string{h.Name, h.Brand, h.Note, h.Tags}, " "))
+		if !slices.ContainsFunc(words, func(w string) bool { return !strings.Contains(text, w) }) {
+			hits = append(hits, h)
+		}
+	}
+	return hits, rows.Err()
+}
+
+// foldedLetters maps the accented Latin letters to the plain ones, like the remove_diacritics option of the index.
+var foldedLetters = func() map[rune]rune {
+	m := make(map[rune]rune)
+	for plain, accented := range map[rune]string{
+		'a': "àáâãäåā", 'c': "çćč", 'e': "èéêëēė", 'i': "ìíîïī", 'n': "ñń", 'o': "òóôõöøō", 's': "śš", 'u': "ùúûüū",
+		'y': "ýÿ", 'z': "źżž",
+	} {
+		for _, r := range accented {
+			m[r] = plain
+		}
+	}
+	return m
+}()
+
+// foldText lowercases `s` and removes the diacritics of its letters, so that "Caffè" and "caffe" compare equal.
+func foldText(s string) string {
+	return strings.Map(func(r rune) rune {
+		r = unicode.ToLower(r)
+		if plain, ok := foldedLetters[r]; ok {
+			return plain
+		}
+		return r
+	}, s)
+}
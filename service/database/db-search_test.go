@@ -0,0 +1,42 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lorenzougolini/wimf-app/service/models"
+)
+
+// TestSearchIgnoresDiacritics searches words typed without accents or in another case, with the full-text index when
+// available and with the fallback without FTS5: both must find the lot.
+func TestSearchIgnoresDiacritics(t *testing.T) {
+	db := newTestDB(t)
+	lot := models.Item{
+		Quantity:       1,
+		ExpirationDate: time.Now().AddDate(0, 6, 0).Truncate(24 * time.Hour),
+		AdditionDate:   time.Now(),
+		Location:       models.DefaultLocation,
+		Note:           "Macinato per moka",
+	}
+	if _, err := db.AddItem(models.ProductInfo{Barcode: "8001234", Name: "Caffè", Brand: "Lavazza"}, lot, false); err != nil {
+		t.Fatal(err)
+	}
+	addLot(t, db, 1)
+
+	impl := db.(*appdbimpl)
+	search := map[string]func(string, int) ([]models.SearchHit, error){"Search": db.Search, "searchLike": impl.searchLike}
+	for name, fn := range search {
+		for _, input := range []string{"caffe", "CAFFÈ lav", "macin moka", "LAVAZZA"} {
+			hits, err := fn(input, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(hits) != 1 || hits[0].Item.Barcode != "8001234" {
+				t.Errorf("%s(%q) = %d hits, want the coffee", name, input, len(hits))
+			}
+		}
+		if hits, err := fn("caffe yogurt", 10); err != nil || len(hits) != 0 {
+			t.Errorf("%s with words of different lots = %d hits, %v; want none", name, len(hits), err)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/lorenzougolini/wimf-app/service/models"
+)
+
+// ErrNoStocktake is returned when there's no stocktake in progress.
+var ErrNoStocktake = errors.New("no stocktake in progress")
+
+// ErrStocktakeInProgress is returned by StartStocktake when a stocktake is already in progress.
+var ErrStocktakeInProgress = errors.New("stocktake already in progress")
+
+const stocktakeLineColumns = `id, item_id, barcode, name, brand, expiration_date, expected, counted`
+
+func scanStocktakeLine(row scanner) (models.StocktakeLine, error) {
+	var line models.StocktakeLine
+	var itemId, exp sql.NullString
+	err := row.Scan(&line.Id, &itemId, &line.Barcode, &line.Name, &line.Brand, &exp, &line.Expected, &line.Counted)
+	if err != nil {
+		return line, err
+	}
+	line.ItemId = itemId.String
+	if exp.Valid {
+		line.ExpirationDate, _ = time.Parse(models.DbTimeLayout, exp.String)
+	}
+	return line, nil
+}
+
+// StartStocktake starts the stocktake of `location`, taking a snapshot of its lots with nothing counted yet.
+func (db *appdbimpl) StartStocktake(location string) error {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := tx.Exec(`INSERT INTO stocktake (id, location, started_at) VALUES (1, ?, ?) ON CONFLICT (id) DO NOTHING;`,
+		location, time.Now().Format(models.DbTimeLayout))
+	if err != nil {
+		return fmt.Errorf("error starting the stocktake: %w", err)
+	}
+	if started, err := res.RowsAffected(); err != nil {
+		return err
+	} else if started == 0 {
+		return ErrStocktakeInProgress
+	}
+
+	rows, err := tx.Query(`
+		SELECT `+lotColumns+`
+		FROM items
+		WHERE location=? AND deleted_at IS NULL
+		ORDER BY name ASC, expiration_date ASC;`, location)
+	if err != nil {
+		return err
+	}
+	var lots []models.Item
+	for rows.Next() {
+		lot, err := scanLot(rows)
+		if err != nil {
+			_ = rows.Close()
+			return err
+		}
+		lots = append(lots, lot)
+	}
+	_ = rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, lot := range lots {
+		id, err := uuid.NewV7()
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+			INSERT INTO stocktake_lines (id, item_id, barcode, name, brand, expiration_date, expected)
+			VALUES (?, ?, ?, ?, ?, ?, ?);`,
+			id.String(), lot.Id.String(), lot.Barcode, lot.Name, lot.Brand,
+			lot.ExpirationDate.Format(models.DbTimeLayout), lot.Quantity)
+		if err != nil {
+			return fmt.Errorf("error taking the snapshot of item %s: %w", lot.Id, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// GetStocktake returns the stocktake in progress, with the lots of the snapshot first, or ErrNoStocktake.
+func (db *appdbimpl) GetStocktake() (models.Stocktake, error) {
+	var stocktake models.Stocktake
+	var started string
+	err := db.c.QueryRow(`SELECT location, started_at FROM stocktake WHERE id = 1;`).
+		Scan(&stocktake.Location, &started)
+	if errors.Is(err, sql.ErrNoRows) {
+		return stocktake, ErrNoStocktake
+	} else if err != nil {
+		return stocktake, err
+	}
+	stocktake.StartedAt, _ = time.Parse(models.DbTimeLayout, started)
+
+	rows, err := db.c.Query(`
+		SELECT ` + stocktakeLineColumns + `
+		FROM stocktake_lines
+		ORDER BY item_id IS NULL, name ASC, expiration_date ASC, id ASC;`)
+	if err != nil {
+		return stocktake, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		line, err := scanStocktakeLine(rows)
+		if err != nil {
+			return stocktake, err
+		}
+		stocktake.Lines = append(stocktake.Lines, line)
+	}
+	return stocktake, rows.Err()
+}
+
+// CountStocktake counts a unit of `barcode`, on the lot of the snapshot that expires first among the ones not fully
+// counted yet. Barcodes without such a lot are counted as products found but not recorded, named after their latest
+// lot if any. It returns the counted line.
+func (db *appdbimpl) CountStocktake(barcode string) (models.StocktakeLine, error) {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return models.StocktakeLine{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var started int
+	if err = tx.QueryRow(`SELECT COUNT(*) FROM stocktake;`).Scan(&started); err != nil {
+		return models.StocktakeLine{}, err
+	} else if started == 0 {
+		return models.StocktakeLine{}, ErrNoStocktake
+	}
+
+	var id string
+	err = tx.QueryRow(`
+		SELECT id FROM stocktake_lines
+		WHERE barcode=? AND (item_id IS NULL OR counted < expected)
+		ORDER BY item_id IS NULL, expiration_date ASC
+		LIMIT 1;`, barcode).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		newId, err := uuid.NewV7()
+		if err != nil {
+			return models.StocktakeLine{}, err
+		}
+		id = newId.String()
+		var name, brand string
+		err = tx.QueryRow(`SELECT name, brand FROM items WHERE barcode=? ORDER BY added_at DESC LIMIT 1;`, barcode).
+			Scan(&name, &brand)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return models.StocktakeLine{}, err
+		}
+		_, err = tx.Exec(`INSERT INTO stocktake_lines (id, barcode, name, brand) VALUES (?, ?, ?, ?);`,
+			id, barcode, name, brand)
+		if err != nil {
+			return models.StocktakeLine{}, fmt.Errorf("error counting %s: %w", barcode, err)
+		}
+	} else if err != nil {
+		return models.StocktakeLine{}, err
+	}
+
+	if _, err = tx.Exec(`UPDATE stocktake_lines SET counted = counted + 1 WHERE id=?;`, id); err != nil {
+		return models.StocktakeLine{}, fmt.Errorf("error counting %s: %w", barcode, err)
+	}
+	line, err := scanStocktakeLine(tx.QueryRow(`SELECT `+stocktakeLineColumns+` FROM stocktake_lines WHERE id=?;`, id))
+	if err != nil {
+		return line, err
+	}
+	return line, tx.Commit()
+}
+
+// SetStocktakeCount sets the units counted on the line `id`, ticked by hand.
+func (db *appdbimpl) SetStocktakeCount(id string, counted int) error {
+	res, err := db.c.Exec(`UPDATE stocktake_lines SET counted=? WHERE id=?;`, max(counted, 0), id)
+	if err != nil {
+		return err
+	}
+	if updated, err := res.RowsAffected(); err != nil {
+		return err
+	} else if updated == 0 {
+		return fmt.Errorf("stocktake line %s: %w", id, ErrItemNotFound)
+	}
+	return nil
+}
+
+// CancelStocktake drops the stocktake in progress without changing the lots.
+func (db *appdbimpl) CancelStocktake() error {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err = tx.Exec(`DELETE FROM stocktake_lines;`); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`DELETE FROM stocktake;`); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ApplyStocktake reconciles the lots with the lines of the stocktake in progress the user confirmed, then closes it,
+// all in one transaction. The lots of the snapshot are brought to the counted units, moving to the trash the ones not
+// found at all; the products found but not recorded are added to the location like any new lot, with the name and the
+// expiration date of their line, so that their units go to an identical lot in stock. Lots changed since the snapshot
+// are left alone. Every change is audited as a stocktake adjustment; it returns how many lots were changed.
+func (db *appdbimpl) ApplyStocktake(lines []models.StocktakeLine) (int, error) {
+	tx, err := db.begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var location string
+	err = tx.QueryRow(`SELECT location FROM stocktake WHERE id = 1;`).Scan(&location)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNoStocktake
+	} else if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	changed := 0
+	for _, line := range lines {
+		if !line.Recorded() {
+			if line.Counted <= 0 {
+				continue
+			}
+			lot := models.Item{
+				Barcode:        line.Barcode,
+				Name:           line.Name,
+				Brand:          line.Brand,
+				Quantity:       line.Counted,
+				ExpirationDate: line.ExpirationDate,
+				AdditionDate:   now,
+				Location:       location,
+			}
+			// like when adding it, the units go to an identical lot in stock and the product keeps its category
+			category, err := productCategory(tx, line.Barcode)
+			if err != nil {
+				return 0, err
+			}
+			if _, err = db.addLot(tx, lot, category, false, models.AuditStocktake); err != nil {
+				return 0, err
+			}
+			changed++
+			continue
+		}
+
+		before, err := snapshotLot(tx, line.ItemId)
+		if err != nil {
+			return 0, err
+		}
+		if before == nil || !before.DeletedAt.IsZero() || before.Quantity != line.Expected ||
+			before.Location != location {
+			continue
+		}
+		if line.Counted <= 0 {
+			if err = db.trashLot(tx, before, now, models.AuditStocktake); err != nil {
+				return 0, err
+			}
+			changed++
+			continue
+		}
+		if line.Counted == before.Quantity {
+			continue
+		}
+		_, err = tx.Exec(`UPDATE items SET quantity = ?, amount = ? WHERE id=?;`,
+			line.Counted, before.AmountFor(line.Counted), line.ItemId)
+		if err != nil {
+			return 0, fmt.Errorf("error adjusting item %s: %w", line.ItemId, err)
+		}
+		if err = recordMovement(tx, line.ItemId, before.Barcode, line.Counted-before.Quantity, movementCorrection,
+			now); err != nil {
+			return 0, err
+		}
+		after, err := snapshotLot(tx, line.ItemId)
+		if err != nil {
+			return 0, err
+		}
+		if err = db.auditLot(tx, models.AuditStocktake, before, after); err != nil {
+			return 0, err
+		}
+		changed++
+	}
+
+	if _, err = tx.Exec(`DELETE FROM stocktake_lines;`); err != nil {
+		return 0, err
+	}
+	if _, err = tx.Exec(`DELETE FROM stocktake;`); err != nil {
+		return 0, err
+	}
+	return changed, tx.Commit()
+}
@@ -0,0 +1,131 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lorenzougolini/wimf-app/service/models"
+)
+
+// TestStocktake counts the fridge, finding fewer yogurts than recorded, no flour and a product never stored: applying
+// the count must bring the lots to what was found, leaving the other locations alone, and close the stocktake.
+func TestStocktake(t *testing.T) {
+	db := newTestDB(t)
+	expiration := time.Now().AddDate(0, 1, 0).Truncate(24 * time.Hour)
+	add := func(barcode, name string, quantity int, location string) string {
+		t.Helper()
+		lot := models.Item{Quantity: quantity, ExpirationDate: expiration, AdditionDate: time.Now().Truncate(time.Second),
+			Location: location}
+		id, err := db.AddItem(models.ProductInfo{Barcode: barcode, Name: name}, lot, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	yogurt := add("8005678", "Yogurt", 3, models.DefaultLocation)
+	flour := add("8001234", "Farina", 1, models.DefaultLocation)
+	frozen := add("8005678", "Yogurt", 2, "Freezer")
+
+	if err := db.StartStocktake(models.DefaultLocation); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.StartStocktake("Freezer"); !errors.Is(err, ErrStocktakeInProgress) {
+		t.Errorf("starting another stocktake: %v, want ErrStocktakeInProgress", err)
+	}
+	for _, barcode := range []string{"8005678", "8005678", "8009999", "8009999"} {
+		if _, err := db.CountStocktake(barcode); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stocktake, err := db.GetStocktake()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stocktake.Lines) != 3 {
+		t.Fatalf("%d lines, want the 2 lots of the fridge and the product found", len(stocktake.Lines))
+	}
+	found := &stocktake.Lines[2]
+	if found.Recorded() || found.Barcode != "8009999" || found.Counted != 2 {
+		t.Fatalf("last line = %+v, want 2 units found of 8009999", *found)
+	}
+	found.Name, found.ExpirationDate = "Burro", expiration
+
+	if changed, err := db.ApplyStocktake(stocktake.Lines); err != nil || changed != 3 {
+		t.Fatalf("apply = %d, %v; want 3 lots changed", changed, err)
+	}
+	if _, err = db.GetStocktake(); !errors.Is(err, ErrNoStocktake) {
+		t.Errorf("stocktake after applying it: %v, want ErrNoStocktake", err)
+	}
+
+	quantity := func(id string) int {
+		t.Helper()
+		lot, err := db.GetItemById(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return lot.Quantity
+	}
+	if q := quantity(yogurt); q != 2 {
+		t.Errorf("yogurt in the fridge = %d, want 2", q)
+	}
+	if q := quantity(frozen); q != 2 {
+		t.Errorf("yogurt in the freezer = %d, want 2 as before", q)
+	}
+	if _, err = db.GetItemById(flour); !errors.Is(err, ErrItemNotFound) {
+		t.Errorf("flour not found: %v, want it in the trash", err)
+	}
+	_, lots, err := db.GetItemsByBarcode("8009999")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lots) != 1 || lots[0].Name != "Burro" || lots[0].Quantity != 2 || lots[0].Location != models.DefaultLocation {
+		t.Errorf("lots of the product found = %+v, want 2 units of Burro in the fridge", lots)
+	}
+
+	audit, err := db.GetAudit(models.AuditFilter{Action: models.AuditStocktake}, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(audit) != 3 {
+		t.Errorf("%d stocktake adjustments audited, want 3", len(audit))
+	}
+}
+
+// TestStocktakeFoundIdenticalLot finds a product stored while counting: its units are added to the identical lot in
+// stock, like when adding it, rather than to a second lot.
+func TestStocktakeFoundIdenticalLot(t *testing.T) {
+	db := newTestDB(t)
+	expiration := time.Now().AddDate(0, 1, 0).Truncate(24 * time.Hour)
+	if err := db.StartStocktake(models.DefaultLocation); err != nil {
+		t.Fatal(err)
+	}
+	lot := models.Item{Quantity: 1, ExpirationDate: expiration, AdditionDate: time.Now().Truncate(time.Second),
+		Location: models.DefaultLocation}
+	yogurt, err := db.AddItem(models.ProductInfo{Barcode: "8005678", Name: "Yogurt", Category: models.OtherCategory},
+		lot, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	line, err := db.CountStocktake("8005678")
+	if err != nil {
+		t.Fatal(err)
+	}
+	line.Name, line.ExpirationDate = "Yogurt", expiration
+	if _, err = db.ApplyStocktake([]models.StocktakeLine{line}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, lots, err := db.GetItemsByBarcode("8005678")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lots) != 1 || lots[0].Id.String() != yogurt || lots[0].Quantity != 2 {
+		t.Errorf("lots after the stocktake = %+v, want 2 units in %s", lots, yogurt)
+	}
+	if product, err := db.GetProduct("8005678"); err != nil || product.Category != models.OtherCategory {
+		t.Errorf("product after the stocktake = %+v, %v; want category %q", product, err, models.OtherCategory)
+	}
+}
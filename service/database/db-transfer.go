@@ -0,0 +1,165 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/lorenzougolini/wimf-app/service/models"
+)
+
+// ImportItems stores the lots of an imported file in one transaction. Lots with the id of a stored lot (even in the
+// trash) update it, the others are added. In models.ImportReplace mode the stored lots missing from `lots` are moved to
+// the trash. With `dryRun` the transaction is rolled back, so the result only tells what the import would do.
+func (db *appdbimpl) ImportItems(lots []models.Item, mode string, dryRun bool) (models.ImportResult, error) {
+	var result models.ImportResult
+	if mode != models.ImportMerge && mode != models.ImportReplace {
+		return result, fmt.Errorf("unsupported import mode: %s", mode)
+	}
+
+	tx, err := db.begin()
+	if err != nil {
+		return result, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	now := time.Now()
+	imported := make(map[string]bool, len(lots))
+	for _, lot := range lots {
+		var before *models.Item
+		if lot.Id != uuid.Nil {
+			if before, err = snapshotLot(tx, lot.Id.String()); err != nil {
+				return result, err
+			}
+		}
+		if before == nil {
+			id, err := db.insertLot(tx, lot, "", models.AuditAdd)
+			if err != nil {
+				return result, err
+			}
+			imported[id] = true
+			result.Added++
+			continue
+		}
+
+		imported[lot.Id.String()] = true
+		updated, err := db.importLot(tx, before, lot, now)
+		if err != nil {
+			return result, err
+		}
+		if updated {
+			result.Updated++
+		}
+	}
+
+	if mode == models.ImportReplace {
+		rows, err := tx.Query(`SELECT id FROM items WHERE deleted_at IS NULL;`)
+		if err != nil {
+			return result, err
+		}
+		var missing []string
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				_ = rows.Close()
+				return result, err
+			}
+			if !imported[id] {
+				missing = append(missing, id)
+			}
+		}
+		_ = rows.Close()
+		if err = rows.Err(); err != nil {
+			return result, err
+		}
+
+		for _, id := range missing {
+			before, err := snapshotLot(tx, id)
+			if err != nil {
+				return result, err
+			}
+			if err = db.trashLot(tx, before, now, models.AuditDelete); err != nil {
+				return result, err
+			}
+			result.Removed++
+		}
+	}
+
+	if dryRun {
+		return result, nil
+	}
+	return result, tx.Commit()
+}
+
+// importLot overwrites the stored lot `before` with the imported `lot`, bringing it back from the trash if needed. It
+// reports false when nothing changed.
+func (db *appdbimpl) importLot(tx *changeTx, before *models.Item, lot models.Item, now time.Time) (bool, error) {
+	// the files may not have the time of the dates
+	if sameDay(before.ExpirationDate, lot.ExpirationDate) {
+		lot.ExpirationDate = before.ExpirationDate
+	}
+	if sameDay(before.AdditionDate, lot.AdditionDate) {
+		lot.AdditionDate = before.AdditionDate
+	}
+	// files without the unit keep the one of the stored lot, and a missing amount follows the quantity like in UpdateItem
+	if !lot.Measured() {
+		lot.Unit, lot.PackageAmount = before.Unit, before.PackageAmount
+	}
+	switch {
+	case !lot.Measured():
+		lot.Amount, lot.PackageAmount = 0, 0
+	case lot.Amount > 0:
+	case lot.Unit == before.Unit && lot.PackageAmount == before.PackageAmount:
+		lot.Amount = before.AmountFor(lot.Quantity)
+	default:
+		lot.Amount = models.RoundAmount(float64(lot.Quantity) * lot.PackageAmount)
+	}
+	if before.DeletedAt.IsZero() && sameLot(*before, lot) {
+		return false, nil
+	}
+
+	id := lot.Id.String()
+	_, err := tx.Exec(`
+		UPDATE items SET barcode=?, name=?, brand=?, quantity=?, unit=?, amount=?, package_amount=?, expiration_date=?,
+			added_at=?, location=?, note=?, label=?, deleted_at=NULL
+		WHERE id=?;`,
+		lot.Barcode, lot.Name, lot.Brand, lot.Quantity, lot.Unit, lot.Amount, lot.PackageAmount,
+		lot.ExpirationDate.Format(models.DbTimeLayout), lot.AdditionDate.Format(models.DbTimeLayout),
+		lot.Location, lot.Note, lot.Label, id)
+	if err != nil {
+		return false, fmt.Errorf("error importing item %s: %w", id, err)
+	}
+	if err = seedProduct(tx, lot.Barcode, ""); err != nil {
+		return false, err
+	}
+
+	// a lot in the trash had its quantity already recorded as consumed
+	delta := lot.Quantity - before.Quantity
+	if !before.DeletedAt.IsZero() {
+		delta = lot.Quantity
+	}
+	if delta != 0 {
+		if err = recordMovement(tx, id, lot.Barcode, delta, movementCorrection, now); err != nil {
+			return false, err
+		}
+	}
+
+	after, err := snapshotLot(tx, id)
+	if err != nil {
+		return false, err
+	}
+	return true, db.auditLot(tx, models.AuditUpdate, before, after)
+}
+
+// sameLot reports if the imported `lot` has the same values of the stored one.
+func sameLot(stored, lot models.Item) bool {
+	return stored.Barcode == lot.Barcode && stored.Name == lot.Name && stored.Brand == lot.Brand &&
+		stored.Quantity == lot.Quantity && stored.Unit == lot.Unit && stored.Amount == lot.Amount &&
+		stored.PackageAmount == lot.PackageAmount && stored.Location == lot.Location && stored.Note == lot.Note &&
+		stored.Label == lot.Label && stored.ExpirationDate.Equal(lot.ExpirationDate) &&
+		stored.AdditionDate.Equal(lot.AdditionDate)
+}
+
+func sameDay(a, b time.Time) bool {
+	return a.Format("2006-01-02") == b.Format("2006-01-02")
+}
@@ -0,0 +1,47 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/lorenzougolini/wimf-app/service/models"
+)
+
+func TestImportKeepsMeasuredAmount(t *testing.T) {
+	db := newTestDB(t)
+	id := addMeasuredLot(t, db, 3, 300)
+	lot, err := db.GetItemById(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a file without the unit keeps the stored one, and the amount follows the new quantity
+	file := lot
+	file.Unit, file.Amount, file.PackageAmount = "", 0, 0
+	file.Quantity = 2
+	if _, err = db.ImportItems([]models.Item{file}, models.ImportMerge, false); err != nil {
+		t.Fatal(err)
+	}
+	if lot, err = db.GetItemById(id); err != nil {
+		t.Fatal(err)
+	}
+	if lot.Unit != models.UnitGram || lot.PackageAmount != 1000 || lot.Quantity != 2 || lot.Amount != 2000 {
+		t.Errorf("updated lot = %d × %v %s, %v left; want 2 × 1000 g, 2000 left", lot.Quantity, lot.PackageAmount,
+			lot.Unit, lot.Amount)
+	}
+
+	// the amount of the file is stored as it is, in new lots too
+	file = lot
+	file.Amount = 1250
+	other := newTestDB(t)
+	for _, db := range []AppDatabase{db, other} {
+		if _, err = db.ImportItems([]models.Item{file}, models.ImportMerge, false); err != nil {
+			t.Fatal(err)
+		}
+		if lot, err = db.GetItemById(id); err != nil {
+			t.Fatal(err)
+		}
+		if lot.Quantity != 2 || lot.Amount != 1250 {
+			t.Errorf("imported lot = %d packages, %v g; want 2, 1250", lot.Quantity, lot.Amount)
+		}
+	}
+}
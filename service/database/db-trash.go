@@ -0,0 +1,124 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lorenzougolini/wimf-app/service/models"
+)
+
+// ErrNotInTrash is returned by RestoreItem when the lot is not in the trash, e.g. because it has already been purged.
+var ErrNotInTrash = errors.New("item not in the trash")
+
+// RestoreItem brings back a lot deleted by DeleteItem, dropping the consumption recorded by the deletion.
+func (db *appdbimpl) RestoreItem(id string) error {
+	tx, err := db.begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	before, err := snapshotLot(tx, id)
+	if err != nil {
+		return err
+	}
+	if before == nil || before.DeletedAt.IsZero() {
+		return ErrNotInTrash
+	}
+
+	if _, err = tx.Exec("UPDATE items SET deleted_at=NULL WHERE id=?;", id); err != nil {
+		return err
+	}
+	deletedAt := before.DeletedAt.Format(models.DbTimeLayout)
+	_, err = tx.Exec("DELETE FROM movements WHERE item_id=? AND moved_at=? AND kind=?;", id, deletedAt, movementDiscard)
+	if err != nil {
+		return fmt.Errorf("error dropping the deletion of item %s: %w", id, err)
+	}
+	after, err := snapshotLot(tx, id)
+	if err != nil {
+		return err
+	}
+	if err = db.auditLot(tx, models.AuditRestore, before, after); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetTrash returns the lots in the trash, most recently deleted first.
+func (db *appdbimpl) GetTrash() ([]models.Item, error) {
+	query := `
+		SELECT ` + lotColumns + `, deleted_at
+		FROM items
+		WHERE deleted_at IS NOT NULL
+		ORDER BY deleted_at DESC;
+	`
+	rows, err := db.c.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []models.Item
+	for rows.Next() {
+		var lot lotScan
+		var deletedAt string
+		if err := rows.Scan(append(lot.dest(), &deletedAt)...); err != nil {
+			return nil, err
+		}
+		i := lot.item()
+		i.DeletedAt, _ = time.Parse(models.DbTimeLayout, deletedAt)
+		items = append(items, i)
+	}
+	return items, rows.Err()
+}
+
+// PurgeTrash permanently removes the lots deleted before `before`, returning how many were removed.
+func (db *appdbimpl) PurgeTrash(before time.Time) (int64, error) {
+	tx, err := db.begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	cutoff := before.Format(models.DbTimeLayout)
+	var purged []*models.Item
+	rows, err := tx.Query(`SELECT id FROM items WHERE deleted_at < ?;`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			_ = rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	_ = rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	for _, id := range ids {
+		lot, err := snapshotLot(tx, id)
+		if err != nil {
+			return 0, err
+		}
+		purged = append(purged, lot)
+	}
+
+	_, err = tx.Exec(`DELETE FROM item_sources WHERE item_id IN (SELECT id FROM items WHERE deleted_at < ?);`, cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("error purging leftover sources: %w", err)
+	}
+	if _, err = tx.Exec(`DELETE FROM items WHERE deleted_at < ?;`, cutoff); err != nil {
+		return 0, fmt.Errorf("error purging the trash: %w", err)
+	}
+	for _, lot := range purged {
+		if err = db.auditLot(tx, models.AuditPurge, lot, nil); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(purged)), tx.Commit()
+}
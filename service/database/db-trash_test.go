@@ -0,0 +1,78 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+func TestDeleteAndRestore(t *testing.T) {
+	db := newTestDB(t)
+	id := addLot(t, db, 2)
+
+	if err := db.DeleteItem(id); err != nil {
+		t.Fatal(err)
+	}
+	trash, err := db.GetTrash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trash) != 1 || trash[0].Id.String() != id || trash[0].DeletedAt.IsZero() {
+		t.Fatalf("trash = %+v, want the deleted lot", trash)
+	}
+
+	// a lot can't be deleted twice, nor a missing one
+	if err = db.DeleteItem(id); !errors.Is(err, ErrItemNotFound) {
+		t.Errorf("deleting a lot in the trash: %v, want ErrItemNotFound", err)
+	}
+	if err = db.DeleteItem(uuid.Must(uuid.NewV7()).String()); !errors.Is(err, ErrItemNotFound) {
+		t.Errorf("deleting a missing lot: %v, want ErrItemNotFound", err)
+	}
+
+	if err = db.RestoreItem(id); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.RestoreItem(id); !errors.Is(err, ErrNotInTrash) {
+		t.Errorf("restoring a lot in stock: %v, want ErrNotInTrash", err)
+	}
+	lot, err := db.GetItemById(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lot.Quantity != 2 {
+		t.Errorf("restored lot = %d units, want 2", lot.Quantity)
+	}
+	// the deletion isn't counted as consumed once undone
+	stats, err := db.GetConsumptionStats(time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if consumed := stats["8005678"].Consumed; consumed != 0 {
+		t.Errorf("%d units consumed, want 0", consumed)
+	}
+}
+
+func TestPurgeTrash(t *testing.T) {
+	db := newTestDB(t)
+	kept := addLot(t, db, 1)
+	purged := addLot(t, db, 1)
+	if err := db.DeleteItem(purged); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := db.PurgeTrash(time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("%d lots purged, want 1", n)
+	}
+	if err = db.RestoreItem(purged); !errors.Is(err, ErrNotInTrash) {
+		t.Errorf("restoring a purged lot: %v, want ErrNotInTrash", err)
+	}
+	if _, err = db.GetItemById(kept); err != nil {
+		t.Errorf("lot in stock after the purge: %v", err)
+	}
+}
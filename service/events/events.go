@@ -0,0 +1,188 @@
+/*
+Package events is the in-process publish/subscribe bus of the inventory changes.
+
+The database publishes an event for every lot it adds, edits, consumes or removes once the transaction making the change
+is committed, and ItemExpired from NotifyExpired when a lot reaches its expiration date, which the api package checks
+every hour. Subscribers are registered at startup in `cmd/webapi`:
+
+	bus := events.New(logger)
+	defer func() { _ = bus.Close() }()
+
+	bus.Subscribe("sse", 16, apirouter.HandleEvent)
+	bus.Subscribe("expirations", 64, events.Handle(func(e events.ItemExpired) {
+		logger.Infof("%s expired", e.Item.Name)
+	}))
+
+Each subscriber gets the events in publishing order, from a goroutine of its own fed by a queue of the given size.
+Publishing never waits: when the queue of a subscriber is full the event is dropped for that subscriber and logged, so a
+slow subscriber can't hold the requests changing the inventory.
+*/
+package events
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/lorenzougolini/wimf-app/service/models"
+	"github.com/sirupsen/logrus"
+)
+
+// Event is an inventory change. Source tells who made it, empty for the changes made by the server itself.
+type Event interface {
+	EventSource() models.AuditSource
+}
+
+// ItemAdded is published when a lot is stored. Restored is set when it's brought back from the trash, or recreated by
+// undoing the consumption that used it up.
+type ItemAdded struct {
+	Source   models.AuditSource
+	Item     models.Item
+	Restored bool
+}
+
+// ItemUpdated is published when a lot is edited, or given back the units of an undone consumption.
+type ItemUpdated struct {
+	Source models.AuditSource
+	Before models.Item
+	After  models.Item
+}
+
+// ItemConsumed is published when `Quantity` units of a lot are eaten or cooked; Item is the lot before the change.
+type ItemConsumed struct {
+	Source   models.AuditSource
+	Item     models.Item
+	Quantity int
+}
+
+// ItemRemoved is published when a lot is moved to the trash.
+type ItemRemoved struct {
+	Source models.AuditSource
+	Item   models.Item
+}
+
+// ItemExpired is published once when a lot in stock reaches its expiration date.
+type ItemExpired struct {
+	Source models.AuditSource
+	Item   models.Item
+}
+
+// ProductUpdated is published when the category or the tags of a product change.
+type ProductUpdated struct {
+	Source models.AuditSource
+	Before models.Product
+	After  models.Product
+}
+
+func (e ItemAdded) EventSource() models.AuditSource      { return e.Source }
+func (e ItemUpdated) EventSource() models.AuditSource    { return e.Source }
+func (e ItemConsumed) EventSource() models.AuditSource   { return e.Source }
+func (e ItemRemoved) EventSource() models.AuditSource    { return e.Source }
+func (e ItemExpired) EventSource() models.AuditSource    { return e.Source }
+func (e ProductUpdated) EventSource() models.AuditSource { return e.Source }
+
+// Publisher is implemented by Bus; a nil Publisher is accepted where one is optional, e.g. by the database.
+type Publisher interface {
+	Publish(events ...Event)
+}
+
+// Handle adapts a handler of the events of type T to Subscribe, skipping the events of other types.
+func Handle[T Event](handle func(T)) func(Event) {
+	return func(e Event) {
+		if t, ok := e.(T); ok {
+			handle(t)
+		}
+	}
+}
+
+// Bus delivers the published events to the subscribers until closed.
+type Bus struct {
+	logger logrus.FieldLogger
+
+	mu          sync.RWMutex
+	subscribers []*subscriber
+	closed      bool
+
+	running sync.WaitGroup
+}
+
+type subscriber struct {
+	name   string
+	queue  chan Event
+	handle func(Event)
+}
+
+// New returns a bus without subscribers.
+func New(logger logrus.FieldLogger) *Bus {
+	return &Bus{logger: logger}
+}
+
+// Subscribe registers `handle`, called from a goroutine of its own for every event published from now on. Up to `size`
+// events wait for it, the ones after are dropped. `name` identifies the subscriber in the logs.
+func (b *Bus) Subscribe(name string, size int, handle func(Event)) {
+	if size < 1 {
+		panic(fmt.Sprintf("events: queue of subscriber %s must hold at least one event", name))
+	}
+	s := &subscriber{name: name, queue: make(chan Event, size), handle: handle}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.subscribers = append(b.subscribers, s)
+	b.running.Add(1)
+	go b.run(s)
+}
+
+// Publish queues `events` for every subscriber, without waiting. Events published after Close are ignored.
+func (b *Bus) Publish(events ...Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return
+	}
+	for _, e := range events {
+		for _, s := range b.subscribers {
+			select {
+			case s.queue <- e:
+			default:
+				b.logger.WithField("subscriber", s.name).Warnf("events: queue full, dropping %T", e)
+			}
+		}
+	}
+}
+
+// run calls the handler of `s` until its queue is closed and drained.
+func (b *Bus) run(s *subscriber) {
+	defer b.running.Done()
+	for e := range s.queue {
+		b.deliver(s, e)
+	}
+}
+
+// deliver calls the handler of `s`, logging its panics so that one event can't stop the subscriber.
+func (b *Bus) deliver(s *subscriber, e Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			b.logger.WithField("subscriber", s.name).WithError(fmt.Errorf("%v", r)).Errorf("events: handler of %T panicked", e)
+		}
+	}()
+	s.handle(e)
+}
+
+// Close stops accepting events and waits for the subscribers to handle the queued ones.
+func (b *Bus) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	for _, s := range b.subscribers {
+		close(s.queue)
+	}
+	b.mu.Unlock()
+
+	b.running.Wait()
+	return nil
+}
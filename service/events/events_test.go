@@ -0,0 +1,86 @@
+package events
+
+import (
+	"io"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/lorenzougolini/wimf-app/service/models"
+	"github.com/sirupsen/logrus"
+)
+
+func newTestBus() *Bus {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return New(logger)
+}
+
+// TestSubscribersGetEventsInOrder publishes to two subscribers, one handling a single type of events and one panicking:
+// each must get its events in publishing order, all of them handled by Close.
+func TestSubscribersGetEventsInOrder(t *testing.T) {
+	bus := newTestBus()
+	var mu sync.Mutex
+	var all []string
+	var removed []string
+	bus.Subscribe("all", 8, func(e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		all = append(all, e.EventSource().Endpoint)
+		if _, ok := e.(ItemConsumed); ok {
+			panic("consumed")
+		}
+	})
+	bus.Subscribe("removed", 8, Handle(func(e ItemRemoved) {
+		mu.Lock()
+		defer mu.Unlock()
+		removed = append(removed, e.Item.Name)
+	}))
+
+	bus.Publish(
+		ItemAdded{Source: models.AuditSource{Endpoint: "add"}, Item: models.Item{Name: "Yogurt"}},
+		ItemConsumed{Source: models.AuditSource{Endpoint: "consume"}, Item: models.Item{Name: "Yogurt"}, Quantity: 1},
+		ItemRemoved{Source: models.AuditSource{Endpoint: "remove"}, Item: models.Item{Name: "Yogurt"}},
+	)
+	if err := bus.Close(); err != nil {
+		t.Fatal(err)
+	}
+	bus.Publish(ItemRemoved{Source: models.AuditSource{Endpoint: "late"}, Item: models.Item{Name: "Latte"}})
+
+	if want := []string{"add", "consume", "remove"}; !slices.Equal(all, want) {
+		t.Errorf("events = %v, want %v", all, want)
+	}
+	if want := []string{"Yogurt"}; !slices.Equal(removed, want) {
+		t.Errorf("removed = %v, want %v", removed, want)
+	}
+}
+
+// TestFullQueueDropsEvents publishes more events than a blocked subscriber can queue: the ones after are dropped for
+// it, without waiting.
+func TestFullQueueDropsEvents(t *testing.T) {
+	bus := newTestBus()
+	started, release := make(chan struct{}), make(chan struct{})
+	var handled []string
+	bus.Subscribe("slow", 1, func(e Event) {
+		if e.EventSource().Endpoint == "first" {
+			close(started)
+			<-release
+		}
+		handled = append(handled, e.EventSource().Endpoint)
+	})
+
+	bus.Publish(ItemRemoved{Source: models.AuditSource{Endpoint: "first"}})
+	<-started
+	bus.Publish(
+		ItemRemoved{Source: models.AuditSource{Endpoint: "queued"}},
+		ItemRemoved{Source: models.AuditSource{Endpoint: "dropped"}},
+	)
+	close(release)
+	if err := bus.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"first", "queued"}; !slices.Equal(handled, want) {
+		t.Errorf("handled = %v, want %v", handled, want)
+	}
+}
@@ -0,0 +1,50 @@
+package foodapi
+
+import "github.com/lorenzougolini/wimf-app/service/models"
+
+// offCategories maps Open Food Facts categories tags to our categories.
+var offCategories = map[string]string{
+	"en:dairies":                "dairy",
+	"en:milks":                  "dairy",
+	"en:cheeses":                "dairy",
+	"en:yogurts":                "dairy",
+	"en:butters":                "dairy",
+	"en:creams":                 "dairy",
+	"en:meats":                  "meat",
+	"en:meat-based-products":    "meat",
+	"en:poultries":              "meat",
+	"en:hams":                   "meat",
+	"en:sausages":               "meat",
+	"en:seafood":                "fish",
+	"en:fishes":                 "fish",
+	"en:fish-and-meat-and-eggs": "meat",
+	"en:vegetables":             "vegetables",
+	"en:vegetables-based-foods": "vegetables",
+	"en:salads":                 "vegetables",
+	"en:fruits":                 "fruit",
+	"en:fruits-based-foods":     "fruit",
+	"en:breads":                 "bakery",
+	"en:biscuits-and-cakes":     "bakery",
+	"en:pastries":               "bakery",
+	"en:beverages":              "beverages",
+	"en:waters":                 "beverages",
+	"en:juices":                 "beverages",
+	"en:snacks":                 "snacks",
+	"en:sweet-snacks":           "snacks",
+	"en:salty-snacks":           "snacks",
+	"en:condiments":             "condiments",
+	"en:sauces":                 "condiments",
+	"en:spreads":                "condiments",
+	"en:frozen-foods":           "frozen",
+}
+
+// categoryFromTags picks our category from the OFF categories tags. Tags go from the most generic to the most specific,
+// so they are scanned backwards to get the most precise match.
+func categoryFromTags(tags []string) string {
+	for i := len(tags) - 1; i >= 0; i-- {
+		if category, ok := offCategories[tags[i]]; ok {
+			return category
+		}
+	}
+	return models.OtherCategory
+}
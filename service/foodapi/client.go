@@ -55,6 +55,9 @@ func (c *Client) GetProductByBarcode(barcode string) (models.ProductInfo, error)
 	}
 	result.Product.Name = finalName
 
+	result.Product.Category = categoryFromTags(result.Product.Categories)
+	result.Product.PackageAmount, result.Product.Unit = packageQuantity(result.Product.Quantity)
+
 	// fallback for missing barcode
 	if result.Product.Barcode == "" {
 		result.Product.Barcode = barcode
@@ -0,0 +1,48 @@
+package foodapi
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/lorenzougolini/wimf-app/service/models"
+)
+
+// offUnits maps the units found in the OFF package quantities to ours, with the factor to convert them.
+var offUnits = map[string]struct {
+	unit   string
+	factor float64
+}{
+	"g":  {models.UnitGram, 1},
+	"gr": {models.UnitGram, 1},
+	"kg": {models.UnitKilogram, 1},
+	"ml": {models.UnitMillilitre, 1},
+	"cl": {models.UnitMillilitre, 10},
+	"dl": {models.UnitMillilitre, 100},
+	"l":  {models.UnitLitre, 1},
+	"lt": {models.UnitLitre, 1},
+}
+
+// quantityPattern matches a package quantity such as "500 g", "1,5 l" or "6 x 125 g", the multiplier being optional.
+var quantityPattern = regexp.MustCompile(`^(?:(\d+)\s*[x×*]\s*)?(\d+(?:[.,]\d+)?)\s*([a-z]+)\b`)
+
+// packageQuantity reads the amount and the unit of a package from its OFF quantity. Multipacks count as a single
+// package of the whole amount, since they are scanned once. It returns a zero amount when the quantity can't be read.
+func packageQuantity(quantity string) (float64, string) {
+	m := quantityPattern.FindStringSubmatch(strings.ToLower(strings.TrimSpace(quantity)))
+	if m == nil {
+		return 0, ""
+	}
+	u, ok := offUnits[m[3]]
+	if !ok {
+		return 0, ""
+	}
+	amount, err := models.ParseAmount(m[2])
+	if err != nil || amount <= 0 {
+		return 0, ""
+	}
+	if packs, err := strconv.Atoi(m[1]); err == nil && packs > 0 {
+		amount *= float64(packs)
+	}
+	return models.RoundAmount(amount * u.factor), u.unit
+}
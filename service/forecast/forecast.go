@@ -0,0 +1,100 @@
+/*
+Package forecast predicts when products will run out, using the consumption history recorded by the database.
+
+The consumption rate of a product is the number of units consumed in the last Window, divided by the days of history
+available in that window. Lots are then assumed to be consumed first-expired, first-out at that rate: a lot whose
+expiration date comes before its predicted consumption is partially or totally wasted, and the product is at risk.
+*/
+package forecast
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/lorenzougolini/wimf-app/service/models"
+)
+
+// Window is how far back the consumption history is used to estimate the rate.
+const Window = 90 * 24 * time.Hour
+
+const day = 24 * time.Hour
+
+// Product computes the forecast of a product from its history and its lots, which must be ordered by expiration date.
+func Product(stats models.ConsumptionStats, lots []models.Item, now time.Time) models.Forecast {
+	var f models.Forecast
+
+	start := now.Add(-Window)
+	if stats.FirstMovement.After(start) {
+		start = stats.FirstMovement
+	}
+	days := now.Sub(start).Hours() / 24
+	if days < 1 {
+		days = 1
+	}
+	f.Rate = float64(stats.Consumed) / days
+	if !f.Known() {
+		return f
+	}
+
+	// walk the lots in expiration order, moving a cursor along the predicted consumption
+	cursor := now
+	for _, lot := range lots {
+		finish := cursor.Add(time.Duration(float64(lot.Quantity) / f.Rate * float64(day)))
+		if lot.ExpirationDate.IsZero() || !finish.After(lot.ExpirationDate) {
+			cursor = finish
+			continue
+		}
+
+		// the lot expires before being finished: what is left at expiration is thrown away
+		eaten := 0.0
+		if lot.ExpirationDate.After(cursor) {
+			eaten = lot.ExpirationDate.Sub(cursor).Hours() / 24 * f.Rate
+			cursor = lot.ExpirationDate
+		}
+		f.Wasted += lot.Quantity - int(math.Floor(eaten))
+	}
+	f.RunOut = cursor
+	f.AtRisk = f.Wasted > 0
+	return f
+}
+
+// All computes the forecast of every product in `lots`, grouping them by barcode.
+func All(stats map[string]models.ConsumptionStats, lots []models.Item, now time.Time) map[string]models.Forecast {
+	byBarcode := make(map[string][]models.Item)
+	for _, lot := range lots {
+		byBarcode[lot.Barcode] = append(byBarcode[lot.Barcode], lot)
+	}
+
+	forecasts := make(map[string]models.Forecast, len(byBarcode))
+	for barcode, productLots := range byBarcode {
+		sort.SliceStable(productLots, func(i, j int) bool {
+			return productLots[i].ExpirationDate.Before(productLots[j].ExpirationDate)
+		})
+		forecasts[barcode] = Product(stats[barcode], productLots, now)
+	}
+	return forecasts
+}
+
+// MostAtRisk returns up to `limit` of the at risk products, the ones with most units predicted to be wasted first and
+// then the ones expiring sooner.
+func MostAtRisk(products []models.Item, limit int) []models.Item {
+	atRisk := make([]models.Item, 0, limit)
+	for _, p := range products {
+		if p.Forecast.AtRisk {
+			atRisk = append(atRisk, p)
+		}
+	}
+
+	sort.SliceStable(atRisk, func(i, j int) bool {
+		if atRisk[i].Forecast.Wasted != atRisk[j].Forecast.Wasted {
+			return atRisk[i].Forecast.Wasted > atRisk[j].Forecast.Wasted
+		}
+		return atRisk[i].ExpirationDate.Before(atRisk[j].ExpirationDate)
+	})
+
+	if len(atRisk) > limit {
+		atRisk = atRisk[:limit]
+	}
+	return atRisk
+}
@@ -0,0 +1,39 @@
+package forecast
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lorenzougolini/wimf-app/service/models"
+)
+
+// TestProductWasted consumes a unit a day: of a lot of 10 expiring in 4 days, 6 units are predicted to be wasted,
+// while the next lot is started once it expires.
+func TestProductWasted(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	stats := models.ConsumptionStats{Consumed: 30, FirstMovement: now.AddDate(0, 0, -30)}
+	lots := []models.Item{
+		{Quantity: 10, ExpirationDate: now.AddDate(0, 0, 4)},
+		{Quantity: 2, ExpirationDate: now.AddDate(0, 2, 0)},
+	}
+
+	f := Product(stats, lots, now)
+	if f.Rate != 1 {
+		t.Errorf("rate = %v, want 1 unit a day", f.Rate)
+	}
+	if f.Wasted != 6 || !f.AtRisk {
+		t.Errorf("%d units wasted, at risk %t; want 6, true", f.Wasted, f.AtRisk)
+	}
+	if want := now.AddDate(0, 0, 6); !f.RunOut.Equal(want) {
+		t.Errorf("run out on %v, want %v", f.RunOut, want)
+	}
+}
+
+// TestProductWithoutHistory gives no forecast to a product never consumed.
+func TestProductWithoutHistory(t *testing.T) {
+	now := time.Now()
+	f := Product(models.ConsumptionStats{FirstMovement: now}, []models.Item{{Quantity: 3, ExpirationDate: now}}, now)
+	if f.Known() || !f.RunOut.IsZero() || f.AtRisk {
+		t.Errorf("forecast = %+v, want none", f)
+	}
+}
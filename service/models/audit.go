@@ -0,0 +1,109 @@
+package models
+
+import (
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Audit actions, as stored in the database. AuditStocktake is an adjustment applied at the end of a stocktake, and
+// AuditMerge folds a duplicate lot into an identical one, while AuditSplit moves part of a lot to a new one.
+const (
+	AuditAdd       = "add"
+	AuditUpdate    = "update"
+	AuditConsume   = "consume"
+	AuditDelete    = "delete"
+	AuditRestore   = "restore"
+	AuditPurge     = "purge"
+	AuditStocktake = "stocktake"
+	AuditMerge     = "merge"
+	AuditSplit     = "split"
+)
+
+// AuditActions are the audit actions, in the order the audit page lists them.
+var AuditActions = []string{
+	AuditAdd, AuditUpdate, AuditConsume, AuditDelete, AuditRestore, AuditPurge, AuditStocktake, AuditMerge, AuditSplit,
+}
+
+// AuditActionLabels are the names shown in the UI for each audit action.
+var AuditActionLabels = map[string]string{
+	AuditAdd:       "Aggiunto",
+	AuditUpdate:    "Modificato",
+	AuditConsume:   "Consumato",
+	AuditDelete:    "Eliminato",
+	AuditRestore:   "Ripristinato",
+	AuditPurge:     "Cancellato",
+	AuditStocktake: "Inventario",
+	AuditMerge:     "Unito",
+	AuditSplit:     "Diviso",
+}
+
+// Audited entities: a lot or the attributes shared by the lots of a barcode.
+const (
+	AuditItem    = "item"
+	AuditProduct = "product"
+)
+
+// AuditSource tells who made a change and through which endpoint. Actor is empty until users exist.
+type AuditSource struct {
+	Actor    string
+	Endpoint string
+}
+
+// AuditEntry is a change of the inventory. Before and After are JSON objects of the changed entity, empty when it
+// didn't exist before (or after) the change.
+type AuditEntry struct {
+	Id int64
+	At time.Time
+	AuditSource
+	Action   string
+	Entity   string
+	EntityId string
+	Barcode  string
+	Name     string
+	Before   string
+	After    string
+}
+
+// AuditFilter selects the entries shown in the audit page. It round-trips to the URL query like FridgeFilter.
+type AuditFilter struct {
+	Action string
+	// Query is matched against the barcode and the name of the changed product
+	Query string
+	// From and To (both days, inclusive) limit when the change happened, ignored when zero
+	From time.Time
+	To   time.Time
+}
+
+// Values encodes the filter as URL query parameters.
+func (f AuditFilter) Values() url.Values {
+	v := url.Values{}
+	if f.Action != "" {
+		v.Set("action", f.Action)
+	}
+	if f.Query != "" {
+		v.Set("q", f.Query)
+	}
+	if !f.From.IsZero() {
+		v.Set("from", f.From.Format("2006-01-02"))
+	}
+	if !f.To.IsZero() {
+		v.Set("to", f.To.Format("2006-01-02"))
+	}
+	return v
+}
+
+// URL returns the audit page URL showing this filter.
+func (f AuditFilter) URL() string {
+	if q := f.Values().Encode(); q != "" {
+		return "/audit?" + q
+	}
+	return "/audit"
+}
+
+// PageURL returns the URL of the audit entries older than the entry `before`.
+func (f AuditFilter) PageURL(before int64) string {
+	v := f.Values()
+	v.Set("before", strconv.FormatInt(before, 10))
+	return "/audit?" + v.Encode()
+}
@@ -0,0 +1,49 @@
+package models
+
+import "time"
+
+// Category keys, as stored in the database. Products without a category are shown as OtherCategory.
+const (
+	OtherCategory     = "other"
+	LeftoversCategory = "leftovers"
+)
+
+// Categories are the product categories, in the order the grouped fridge view shows them.
+var Categories = []string{
+	"dairy", "meat", "fish", "vegetables", "fruit", "bakery", "beverages", "snacks", "condiments", "frozen",
+	LeftoversCategory, OtherCategory,
+}
+
+// CategoryLabels are the names shown in the UI for each category.
+var CategoryLabels = map[string]string{
+	"dairy":           "Latticini",
+	"meat":            "Carne",
+	"fish":            "Pesce",
+	"vegetables":      "Verdura",
+	"fruit":           "Frutta",
+	"bakery":          "Pane e dolci",
+	"beverages":       "Bevande",
+	"snacks":          "Snack",
+	"condiments":      "Condimenti",
+	"frozen":          "Surgelati",
+	LeftoversCategory: "Avanzi",
+	OtherCategory:     "Altro",
+}
+
+// Product holds the attributes shared by every lot of a barcode.
+type Product struct {
+	// Id is a stable number identifying the product, zero until its first lot is stored
+	Id       int64
+	Barcode  string
+	Category string
+	Tags     []string
+}
+
+// CategorySummary aggregates the products of a category.
+type CategorySummary struct {
+	Category       string
+	Products       int
+	Quantity       int
+	Expired        int
+	NextExpiration time.Time
+}
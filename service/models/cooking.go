@@ -0,0 +1,25 @@
+package models
+
+// LeftoverPrefix marks the internal code given to lots created by cooking, in place of a real barcode.
+const LeftoverPrefix = "LO-"
+
+// Ingredient is what's consumed of a lot when cooking a leftover: Quantity units of a lot counted in pieces, or Amount
+// Unit of a measured one.
+type Ingredient struct {
+	ItemId   string
+	Quantity int
+	Amount   float64
+	Unit     string
+}
+
+// ItemSource is a lot that was used to cook a leftover. Product details are copied at cooking time, so the lineage
+// survives even when the source lot is fully consumed. Amount is what was used of a measured lot, in its Unit.
+type ItemSource struct {
+	SourceId string
+	Barcode  string
+	Name     string
+	Brand    string
+	Quantity int
+	Amount   float64
+	Unit     string
+}
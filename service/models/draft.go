@@ -0,0 +1,45 @@
+package models
+
+import "time"
+
+// Lookup states of a draft item, as stored in the database.
+const (
+	DraftPending  = "pending"
+	DraftFound    = "found"
+	DraftNotFound = "not_found"
+)
+
+// DraftItem is a lot scanned in the bulk add session, stored once the whole draft is committed. ExpirationDate is zero
+// until it's set; Name, Brand and the package size of measured products are filled in by the lookup of the barcode,
+// until then Status is DraftPending.
+type DraftItem struct {
+	Id             string
+	Barcode        string
+	Name           string
+	Brand          string
+	Category       string
+	Quantity       int
+	Unit           string
+	PackageAmount  float64
+	ExpirationDate time.Time
+	Location       string
+	Status         string
+	ScannedAt      time.Time
+}
+
+// ShelfLife is how many days the products of each category usually last once bought, used to guess the expiration
+// dates in the bulk add session.
+var ShelfLife = map[string]int{
+	"dairy":           7,
+	"meat":            3,
+	"fish":            2,
+	"vegetables":      5,
+	"fruit":           7,
+	"bakery":          3,
+	"beverages":       180,
+	"snacks":          90,
+	"condiments":      180,
+	"frozen":          90,
+	LeftoversCategory: 3,
+	OtherCategory:     30,
+}
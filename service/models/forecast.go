@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// ConsumptionStats summarizes the history of a product, as recorded by additions and consumptions.
+type ConsumptionStats struct {
+	Barcode       string
+	Consumed      int
+	FirstMovement time.Time
+}
+
+// Forecast is the predicted consumption of a product. A zero Rate means there is not enough history to predict
+// anything, and RunOut is then zero too.
+type Forecast struct {
+	// Rate is the estimated number of units consumed per day
+	Rate float64
+	// RunOut is the predicted date when the stock will be finished
+	RunOut time.Time
+	// Wasted is the number of units predicted to expire before being consumed, AtRisk is set when it's not zero
+	Wasted int
+	AtRisk bool
+}
+
+// Known reports if the forecast is based on some consumption history.
+func (f Forecast) Known() bool {
+	return f.Rate > 0
+}
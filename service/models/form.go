@@ -0,0 +1,11 @@
+package models
+
+// FormErrors maps the names of the invalid fields of a form to the message shown next to them. The empty name is for
+// the errors not tied to a single field.
+type FormErrors map[string]string
+
+// Has reports if `field` is invalid.
+func (e FormErrors) Has(field string) bool {
+	_, ok := e[field]
+	return ok
+}
@@ -0,0 +1,114 @@
+package models
+
+import (
+	"net/url"
+	"slices"
+	"strconv"
+)
+
+// Orders of the fridge view: by next expiration, by name, latest added first, largest quantity first.
+const (
+	SortExpiry   = "expiry"
+	SortName     = "name"
+	SortAdded    = "added"
+	SortQuantity = "quantity"
+)
+
+// FridgeFilter selects and arranges the products shown in the fridge view. It round-trips to the URL query, so every
+// view can be bookmarked.
+type FridgeFilter struct {
+	Category string
+	// Tags lists the tags a product must all have to be shown
+	Tags []string
+	// Grouped shows the products grouped by category
+	Grouped bool
+	// Query is searched in the names, brands and notes of the lots
+	Query string
+	// Sort is one of the Sort* orders, SortExpiry when empty
+	Sort     string
+	Location string
+	// Expired keeps only the expired lots, Within (when not zero) only the lots expiring in that many days
+	Expired bool
+	Within  int
+}
+
+// Values encodes the filter as URL query parameters.
+func (f FridgeFilter) Values() url.Values {
+	v := url.Values{}
+	if f.Category != "" {
+		v.Set("category", f.Category)
+	}
+	for _, t := range f.Tags {
+		v.Add("tag", t)
+	}
+	if f.Grouped {
+		v.Set("group", "category")
+	}
+	if f.Query != "" {
+		v.Set("q", f.Query)
+	}
+	if f.Sort != "" && f.Sort != SortExpiry {
+		v.Set("sort", f.Sort)
+	}
+	if f.Location != "" {
+		v.Set("location", f.Location)
+	}
+	if f.Expired {
+		v.Set("expired", "true")
+	}
+	if f.Within > 0 {
+		v.Set("within", strconv.Itoa(f.Within))
+	}
+	return v
+}
+
+// URL returns the fridge page URL showing this filter.
+func (f FridgeFilter) URL() string {
+	if q := f.Values().Encode(); q != "" {
+		return "/fridge?" + q
+	}
+	return "/fridge"
+}
+
+// PageURL returns the URL of the fridge rows after `cursor`, used to load the next page.
+func (f FridgeFilter) PageURL(cursor string) string {
+	v := f.Values()
+	v.Set("cursor", cursor)
+	return "/fridge/rows?" + v.Encode()
+}
+
+// Filtered reports if some products may be hidden by the filter.
+func (f FridgeFilter) Filtered() bool {
+	return f.Category != "" || len(f.Tags) > 0 || f.Query != "" || f.Location != "" || f.Expired || f.Within > 0
+}
+
+// WithTag returns a copy of the filter also requiring `tag`.
+func (f FridgeFilter) WithTag(tag string) FridgeFilter {
+	if !slices.Contains(f.Tags, tag) {
+		f.Tags = append(slices.Clone(f.Tags), tag)
+	}
+	return f
+}
+
+// WithoutTag returns a copy of the filter not requiring `tag`.
+func (f FridgeFilter) WithoutTag(tag string) FridgeFilter {
+	f.Tags = slices.DeleteFunc(slices.Clone(f.Tags), func(t string) bool { return t == tag })
+	return f
+}
+
+// Without returns a copy of the filter dropping the URL query parameter `param`.
+func (f FridgeFilter) Without(param string) FridgeFilter {
+	switch param {
+	case "category":
+		f.Category = ""
+	case "q":
+		f.Query = ""
+	case "location":
+		f.Location = ""
+	case "expired":
+		f.Expired = false
+	case "within":
+		f.Within = 0
+	}
+	return f
+}
@@ -6,17 +6,55 @@ import (
 	"github.com/gofrs/uuid"
 )
 
+// MaxLotQuantity is the largest quantity of a lot: the forms and the APIs refuse more, and identical lots are only
+// merged while their units stay within it.
+const MaxLotQuantity = 999
+
+// Item is a lot of a product. Unit is the unit of measure of a measured lot, empty for the lots counted in pieces:
+// Amount is what's left of it across its packages and PackageAmount what a full package holds, both in Unit, while
+// Quantity counts the packages not used up yet, the last one possibly opened.
+// When returned as a product aggregate (e.g. by GetFridge), Note joins the notes of all the lots, Label is the label of
+// one of them and Amount sums the measured lots in the smallest common Unit. DeletedAt is only set on the lots in the
+// trash. The JSON fields are the ones of a lot in the JSON API.
 type Item struct {
-	Id             uuid.UUID
-	Barcode        string
-	Name           string
-	Brand          string
-	Quantity       int
-	ExpirationDate time.Time
-	AdditionDate   time.Time
+	Id             uuid.UUID `json:"id"`
+	Barcode        string    `json:"barcode"`
+	Name           string    `json:"name"`
+	Brand          string    `json:"brand"`
+	Quantity       int       `json:"quantity"`
+	Unit           string    `json:"unit,omitempty"`
+	Amount         float64   `json:"amount,omitempty"`
+	PackageAmount  float64   `json:"package_amount,omitempty"`
+	ExpirationDate time.Time `json:"expiration_date"`
+	AdditionDate   time.Time `json:"added_at"`
+	Location       string    `json:"location"`
+	Note           string    `json:"note"`
+	Label          string    `json:"label"`
+	Category       string    `json:"category,omitempty"`
+	Tags           []string  `json:"tags,omitempty"`
+	Forecast       Forecast  `json:"-"`
+	DeletedAt      time.Time `json:"-"`
+}
+
+// Measured reports if the lot is measured in a unit, rather than counted in pieces.
+func (i Item) Measured() bool {
+	return i.Unit != ""
+}
+
+// AmountFor returns what's left of a measured lot once it counts `quantity` packages: the opened package goes first
+// when there are fewer, and full packages are added when there are more. It's zero for the lots counted in pieces.
+func (i Item) AmountFor(quantity int) float64 {
+	if !i.Measured() || quantity <= 0 {
+		return 0
+	}
+	if quantity < i.Quantity {
+		return RoundAmount(min(i.Amount, float64(quantity)*i.PackageAmount))
+	}
+	return RoundAmount(i.Amount + float64(quantity-i.Quantity)*i.PackageAmount)
 }
 
 type HomeItems struct {
 	RecentItems   []Item
 	ExpiringItems []Item
+	AtRiskItems   []Item
 }
@@ -0,0 +1,10 @@
+package models
+
+// DefaultLocation is where a lot is stored when no location is given.
+const DefaultLocation = "Frigo"
+
+// Locations are the storage places offered in the forms.
+var Locations = []string{DefaultLocation, "Freezer", "Dispensa"}
+
+// Labels are the colours a lot can be marked with.
+var Labels = []string{"red", "orange", "yellow", "green", "blue", "purple"}
@@ -6,4 +6,12 @@ type ProductInfo struct {
 	NameIT  string `json:"product_name_it"`
 	NameEN  string `json:"product_name_en"`
 	Brand   string `json:"brands"`
+	// Categories are the OFF categories tags, Category the one of our categories derived from them
+	Categories []string `json:"categories_tags"`
+	Category   string   `json:"-"`
+	// Quantity is the OFF package quantity as printed, e.g. "500 g"; PackageAmount and Unit are read from it, and are
+	// zero for the products sold by the piece or when it can't be read
+	Quantity      string  `json:"quantity"`
+	PackageAmount float64 `json:"-"`
+	Unit          string  `json:"-"`
 }
@@ -0,0 +1,17 @@
+package models
+
+// Markers surrounding the matches in the highlighted fields of SearchHit.
+const (
+	HighlightStart = "\x02"
+	HighlightEnd   = "\x03"
+)
+
+// SearchHit is a lot matching a search. Name, Brand, Note and Tags are copies of the lot fields where the matches are
+// surrounded by the database highlight markers.
+type SearchHit struct {
+	Item  Item
+	Name  string
+	Brand string
+	Note  string
+	Tags  string
+}
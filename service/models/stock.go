@@ -0,0 +1,33 @@
+package models
+
+// StockSummary describes what is already stored for a barcode, to warn about duplicate purchases.
+type StockSummary struct {
+	Barcode  string `json:"barcode"`
+	Name     string `json:"name,omitempty"`
+	Brand    string `json:"brand,omitempty"`
+	InStock  bool   `json:"in_stock"`
+	Quantity int    `json:"quantity"`
+	Lots     int    `json:"lots"`
+	// NextExpiration is the nearest expiration date (YYYY-MM-DD) and NextLocation where that lot is stored
+	NextExpiration string `json:"next_expiration,omitempty"`
+	NextLocation   string `json:"next_location,omitempty"`
+}
+
+// SummarizeStock builds the StockSummary of the lots of a barcode, which must be ordered by expiration date.
+func SummarizeStock(barcode string, lots []Item) StockSummary {
+	summary := StockSummary{Barcode: barcode}
+	if len(lots) == 0 {
+		return summary
+	}
+
+	summary.InStock = true
+	summary.Name = lots[0].Name
+	summary.Brand = lots[0].Brand
+	summary.Lots = len(lots)
+	summary.NextExpiration = lots[0].ExpirationDate.Format("2006-01-02")
+	summary.NextLocation = lots[0].Location
+	for _, lot := range lots {
+		summary.Quantity += lot.Quantity
+	}
+	return summary
+}
@@ -0,0 +1,43 @@
+package models
+
+import "time"
+
+// Stocktake is the count of the lots of a location, compared with what the database expects once it's done.
+type Stocktake struct {
+	Location  string
+	StartedAt time.Time
+	Lines     []StocktakeLine
+}
+
+// StocktakeLine is a lot of the location when the stocktake started, with the units expected and the ones counted so
+// far. ItemId is empty for the products counted that weren't recorded in the location: Expected is 0 and
+// ExpirationDate is zero for them.
+type StocktakeLine struct {
+	Id             string
+	ItemId         string
+	Barcode        string
+	Name           string
+	Brand          string
+	ExpirationDate time.Time
+	Expected       int
+	Counted        int
+}
+
+// Recorded tells if the line is a lot of the snapshot, rather than a product found during the count.
+func (l StocktakeLine) Recorded() bool {
+	return l.ItemId != ""
+}
+
+// Differences returns the lines whose count doesn't match the snapshot: the lots with fewer or more units than
+// expected, then the products that weren't recorded.
+func (s Stocktake) Differences() (lots []StocktakeLine, unrecorded []StocktakeLine) {
+	for _, line := range s.Lines {
+		switch {
+		case !line.Recorded():
+			unrecorded = append(unrecorded, line)
+		case line.Counted != line.Expected:
+			lots = append(lots, line)
+		}
+	}
+	return lots, unrecorded
+}
@@ -0,0 +1,15 @@
+package models
+
+// Import modes. ImportMerge updates the lots of the file with a known id and adds the others; ImportReplace also moves
+// to the trash the stored lots missing from the file.
+const (
+	ImportMerge   = "merge"
+	ImportReplace = "replace"
+)
+
+// ImportResult counts the changes made by an import, or the ones it would make in a dry run.
+type ImportResult struct {
+	Added   int `json:"added"`
+	Updated int `json:"updated"`
+	Removed int `json:"removed"`
+}
@@ -0,0 +1,109 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Units of measure of the measured lots, as stored in the database. The lots without a unit are counted in pieces.
+const (
+	UnitGram       = "g"
+	UnitKilogram   = "kg"
+	UnitMillilitre = "ml"
+	UnitLitre      = "l"
+)
+
+// Units are the units of measure offered in the forms.
+var Units = []string{UnitGram, UnitKilogram, UnitMillilitre, UnitLitre}
+
+// unitBases maps each unit to the smallest unit measuring the same thing, and how many of them it's worth.
+var unitBases = map[string]struct {
+	base   string
+	factor float64
+}{
+	UnitGram:       {UnitGram, 1},
+	UnitKilogram:   {UnitGram, 1000},
+	UnitMillilitre: {UnitMillilitre, 1},
+	UnitLitre:      {UnitMillilitre, 1000},
+}
+
+// ValidUnit reports if `unit` is one of Units, or empty for pieces.
+func ValidUnit(unit string) bool {
+	_, ok := unitBases[unit]
+	return ok || unit == ""
+}
+
+// BaseAmount converts `amount` of `unit` to the smallest unit measuring the same thing: grams or millilitres.
+func BaseAmount(amount float64, unit string) (float64, string) {
+	b, ok := unitBases[unit]
+	if !ok {
+		return amount, unit
+	}
+	return amount * b.factor, b.base
+}
+
+// ConvertAmount converts `amount` from the unit `from` to the unit `to`. It returns false when the units don't measure
+// the same thing, e.g. grams and litres, or when one of them is pieces.
+func ConvertAmount(amount float64, from, to string) (float64, bool) {
+	f, okFrom := unitBases[from]
+	t, okTo := unitBases[to]
+	if !okFrom || !okTo || f.base != t.base {
+		return 0, false
+	}
+	return RoundAmount(amount * f.factor / t.factor), true
+}
+
+// CompatibleUnits returns the units `amount`s of `unit` can be given in.
+func CompatibleUnits(unit string) []string {
+	var units []string
+	for _, u := range Units {
+		if _, ok := ConvertAmount(1, u, unit); ok {
+			units = append(units, u)
+		}
+	}
+	return units
+}
+
+// RoundAmount rounds an amount to the thousandth, the precision amounts are stored with.
+func RoundAmount(amount float64) float64 {
+	return math.Round(amount*1000) / 1000
+}
+
+// PackagesOf returns how many packages of `packageAmount` are needed to hold `amount`, the last one opened.
+func PackagesOf(amount, packageAmount float64) int {
+	if packageAmount <= 0 {
+		return 0
+	}
+	return int(math.Ceil(RoundAmount(amount / packageAmount)))
+}
+
+// FormatAmount formats an amount for the UI in the larger unit when it's at least one: 1500 g is "1,5 kg", 250 ml is
+// "250 ml".
+func FormatAmount(amount float64, unit string) string {
+	amount, unit = BaseAmount(amount, unit)
+	if amount >= 1000 {
+		switch unit {
+		case UnitGram:
+			amount, unit = amount/1000, UnitKilogram
+		case UnitMillilitre:
+			amount, unit = amount/1000, UnitLitre
+		}
+	}
+	s := strconv.FormatFloat(RoundAmount(amount), 'f', -1, 64)
+	return strings.Replace(s, ".", ",", 1) + " " + unit
+}
+
+// ParseAmount reads an amount typed in a form, with either a comma or a dot as decimal separator. NaN and infinities are
+// rejected, as no bound check can catch them.
+func ParseAmount(s string) (float64, error) {
+	amount, err := strconv.ParseFloat(strings.Replace(strings.TrimSpace(s), ",", ".", 1), 64)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(amount) || math.IsInf(amount, 0) {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	return RoundAmount(amount), nil
+}
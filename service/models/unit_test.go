@@ -0,0 +1,16 @@
+package models
+
+import "testing"
+
+func TestParseAmount(t *testing.T) {
+	for s, want := range map[string]float64{"500": 500, "1,5": 1.5, " 0.25 ": 0.25, "1.0004": 1} {
+		if got, err := ParseAmount(s); err != nil || got != want {
+			t.Errorf("ParseAmount(%q) = %v, %v; want %v", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "abc", "NaN", "nan", "Inf", "+Inf", "-Infinity", "1e400"} {
+		if got, err := ParseAmount(s); err == nil {
+			t.Errorf("ParseAmount(%q) = %v, want an error", s, got)
+		}
+	}
+}
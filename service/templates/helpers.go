@@ -1,7 +1,14 @@
 package templates
 
 import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/lorenzougolini/wimf-app/service/models"
 )
 
 func getCardClass(exp time.Time) string {
@@ -27,3 +34,232 @@ func getDateClass(exp time.Time) string {
 		return baseClass + " text-green-600 dark:text-green-400"
 	}
 }
+
+// formatIsoDate renders a YYYY-MM-DD date in the format used across the UI.
+func formatIsoDate(date string) string {
+	parsed, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return date
+	}
+	return parsed.Format("02/01/2006")
+}
+
+// formatRunOut renders the predicted run-out date of a forecast, or a dash when it can't be predicted.
+func formatRunOut(f models.Forecast) string {
+	if !f.Known() {
+		return "—"
+	}
+	return f.RunOut.Format("02/01/2006")
+}
+
+// formatRate renders the consumption rate of a forecast, e.g. "1,5 al giorno" or "2 a settimana" for slow products.
+func formatRate(f models.Forecast) string {
+	if f.Rate >= 1 {
+		return strings.Replace(strconv.FormatFloat(f.Rate, 'f', 1, 64), ".", ",", 1) + " al giorno"
+	}
+	return strings.Replace(strconv.FormatFloat(f.Rate*7, 'f', 1, 64), ".", ",", 1) + " a settimana"
+}
+
+// categoryLabel returns the name shown for a category key.
+func categoryLabel(category string) string {
+	if label, ok := models.CategoryLabels[category]; ok {
+		return label
+	}
+	return models.CategoryLabels[models.OtherCategory]
+}
+
+// fridgeGroup is a section of the grouped fridge view.
+type fridgeGroup struct {
+	Summary models.CategorySummary
+	Items   []models.Item
+}
+
+// groupByCategory splits the products in sections following the order of models.Categories, keeping the products
+// order inside each section.
+func groupByCategory(items []models.Item, summaries []models.CategorySummary) []fridgeGroup {
+	var groups []fridgeGroup
+	for _, category := range models.Categories {
+		group := fridgeGroup{Summary: models.CategorySummary{Category: category}}
+		for _, s := range summaries {
+			if s.Category == category {
+				group.Summary = s
+			}
+		}
+		for _, item := range items {
+			if item.Category == category || (category == models.OtherCategory && models.CategoryLabels[item.Category] == "") {
+				group.Items = append(group.Items, item)
+			}
+		}
+		if len(group.Items) > 0 {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+// labelClass returns the background class of a colour label.
+func labelClass(label string) string {
+	switch label {
+	case "red":
+		return "bg-red-500"
+	case "orange":
+		return "bg-orange-500"
+	case "yellow":
+		return "bg-yellow-400"
+	case "green":
+		return "bg-green-500"
+	case "blue":
+		return "bg-blue-500"
+	case "purple":
+		return "bg-purple-500"
+	default:
+		return "bg-gray-400"
+	}
+}
+
+// highlightHTML escapes a field of a search hit and turns the database highlight markers into <mark> elements.
+func highlightHTML(s string) string {
+	s = html.EscapeString(s)
+	s = strings.ReplaceAll(s, models.HighlightStart, `<mark class="bg-yellow-200 dark:bg-yellow-700 dark:text-white rounded">`)
+	return strings.ReplaceAll(s, models.HighlightEnd, "</mark>")
+}
+
+// sortOptions lists the orders of the fridge view with their labels.
+var sortOptions = []struct{ Value, Label string }{
+	{models.SortExpiry, "Scadenza"},
+	{models.SortName, "Nome"},
+	{models.SortAdded, "Ultimi aggiunti"},
+	{models.SortQuantity, "Quantità"},
+}
+
+const filterSelectClass = "bg-gray-50 border border-gray-300 text-gray-900 text-sm rounded-full px-3 py-1.5 dark:bg-gray-700 dark:border-gray-600 dark:text-white"
+
+// withGrouping returns a copy of the filter switched to the grouped or plain list view.
+func withGrouping(filter models.FridgeFilter, grouped bool) models.FridgeFilter {
+	filter.Grouped = grouped
+	return filter
+}
+
+// auditChange is a field changed by an audit entry, with its values formatted for the UI.
+type auditChange struct {
+	Field, Before, After string
+}
+
+// auditFields are the audited fields, in the order the audit page shows them, with their names.
+var auditFields = []struct{ Key, Label string }{
+	{"name", "Nome"},
+	{"brand", "Marca"},
+	{"quantity", "Quantità"},
+	{"amount", "Rimasto"},
+	{"expiration_date", "Scadenza"},
+	{"added_at", "Aggiunto il"},
+	{"location", "Posizione"},
+	{"note", "Nota"},
+	{"label", "Etichetta"},
+	{"deleted_at", "Eliminato il"},
+	{"category", "Categoria"},
+	{"tags", "Tag"},
+}
+
+// auditChanges lists the fields that differ between the before and after states of an audit entry. When the entity
+// was created or removed, every field it had is listed.
+func auditChanges(entry models.AuditEntry) []auditChange {
+	before, after := map[string]any{}, map[string]any{}
+	_ = json.Unmarshal([]byte(entry.Before), &before)
+	_ = json.Unmarshal([]byte(entry.After), &after)
+
+	var changes []auditChange
+	for _, f := range auditFields {
+		b, a := formatAuditValue(f.Key, before[f.Key]), formatAuditValue(f.Key, after[f.Key])
+		if b != a {
+			changes = append(changes, auditChange{Field: f.Label, Before: b, After: a})
+		}
+	}
+	return changes
+}
+
+// formatAuditValue formats a value of the JSON state of an audit entry.
+func formatAuditValue(key string, v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		if t, err := time.Parse(models.DbTimeLayout, v); err == nil {
+			if key == "deleted_at" {
+				return t.Format("02/01/2006 15:04")
+			}
+			return t.Format("02/01/2006")
+		}
+		if key == "category" && v != "" {
+			return categoryLabel(v)
+		}
+		return v
+	case []any:
+		tags := make([]string, 0, len(v))
+		for _, t := range v {
+			tags = append(tags, fmt.Sprint(t))
+		}
+		return strings.Join(tags, ", ")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// auditActionLabel returns the name shown in the UI for an audit action.
+func auditActionLabel(action string) string {
+	if label, ok := models.AuditActionLabels[action]; ok {
+		return label
+	}
+	return action
+}
+
+// formatFormDate formats a date for a date input, empty when zero.
+func formatFormDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
+
+// amountValue formats an amount for a text input, empty when zero.
+func amountValue(amount float64) string {
+	if amount == 0 {
+		return ""
+	}
+	return strings.Replace(strconv.FormatFloat(amount, 'f', -1, 64), ".", ",", 1)
+}
+
+// formInputClass returns the class of an input of the modals, with a red border when `field` is invalid.
+func formInputClass(errs models.FormErrors, field string) string {
+	base := "box-border bg-gray-50 border text-gray-900 text-sm rounded-lg focus:ring-blue-500 focus:border-blue-500 block w-full p-2.5 dark:bg-gray-700 dark:placeholder-gray-400 dark:text-white"
+	if errs.Has(field) {
+		return base + " border-red-500 dark:border-red-500"
+	}
+	return base + " border-gray-300 dark:border-gray-600"
+}
+
+// stocktakeLineName is the name shown for a line of the stocktake, the barcode for the products never seen before.
+func stocktakeLineName(line models.StocktakeLine) string {
+	if line.Name == "" {
+		return line.Barcode
+	}
+	return line.Name
+}
+
+// ingredientOf returns what the cook form uses of the lot `id`, zero when it's not among `ingredients`.
+func ingredientOf(ingredients []models.Ingredient, id string) models.Ingredient {
+	for _, ing := range ingredients {
+		if ing.ItemId == id {
+			return ing
+		}
+	}
+	return models.Ingredient{}
+}
+
+// ingredientUnit returns the unit selected in the cook form for the measured lot `item`, its own unit by default.
+func ingredientUnit(ingredients []models.Ingredient, item models.Item) string {
+	if unit := ingredientOf(ingredients, item.Id.String()).Unit; unit != "" {
+		return unit
+	}
+	return item.Unit
+}
@@ -8,7 +8,9 @@ package templates
 import "github.com/a-h/templ"
 import templruntime "github.com/a-h/templ/runtime"
 
-func Home() templ.Component {
+// Home is the scanner page, adding the scanned products or consuming them; while `pairing` is the code of a scan
+// session, the barcodes are sent to the paired desktop
+func Home(pairing string) templ.Component {
 	return templruntime.GeneratedTemplate(func(templ_7745c5c3_Input templruntime.GeneratedComponentInput) (templ_7745c5c3_Err error) {
 		templ_7745c5c3_W, ctx := templ_7745c5c3_Input.Writer, templ_7745c5c3_Input.Context
 		if templ_7745c5c3_CtxErr := ctx.Err(); templ_7745c5c3_CtxErr != nil {
@@ -29,7 +31,63 @@ func Home() templ.Component {
 			templ_7745c5c3_Var1 = templ.NopComponent
 		}
 		ctx = templ.ClearChildren(ctx)
-		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 1, "<section class=\"overflow-hidden bg-gray-50 sm:grid sm:grid-cols-2 sm:items-center dark:bg-gray-900\"><script src=\"https://cdn.jsdelivr.net/npm/@ericblade/quagga2/dist/quagga.min.js\"></script><div class=\"flex flex-col items-center justify-center p-8\"><div id=\"interactive\" class=\"viewport relative w-full max-w-[300px] h-64 bg-black rounded-2xl overflow-hidden border-4 border-gray-800 shadow-xl\"><video class=\"w-full h-full object-cover\"></video><div class=\"absolute top-1/2 left-0 w-full h-1 bg-red-500 opacity-50 pointer-events-none\"></div></div><div class=\"mt-4 w-full max-w-md flex flex-col items-center gap-2\"><div id=\"scan-status\" class=\"text-center text-lg font-bold text-gray-700 dark:text-gray-200\">Scansiona un codice a barre!<div class=\"text-xs font-normal text-gray-500\">oppure</div></div><div><button hx-get=\"/fridge/items/manual-form\" hx-target=\"#modals\" hx-swap=\"innerHTML\" class=\"flex items-center justify-center px-4 py-2 gap-1.5 bg-blue-600 text-white text-sm rounded-md shadow hover:bg-blue-700 transition-all focus:outline-none focus:ring-2 focus:ring-blue-300 dark:focus:ring-blue-800\" aria-label=\"Aggiungi Manualmente\"><svg class=\"w-4 h-4\" fill=\"none\" stroke=\"currentColor\" viewBox=\"0 0 24 24\" xmlns=\"http://www.w3.org/2000/svg\"><path stroke-linecap=\"round\" stroke-linejoin=\"round\" stroke-width=\"2\" d=\"M12 6v6m0 0v6m0-6h6m-6 0H6\"></path></svg> <span class=\"font-medium\">Inserisci Manualmente</span></button></div><div id=\"debug-log\" class=\"text-xs text-red-500 font-mono bg-gray-100 p-2 rounded hidden\"></div></div></div><div class=\"p-8 md:p-12 lg:px-16 lg:py-24\"><div class=\"mx-auto max-w-xl text-center\" id=\"splash-right\" hx-get=\"/fridge/home-items\" hx-trigger=\"load, item-added from:body, every 10s\" hx-swap=\"innerHTML\"></div></div></section><script>\n  function validateEan13(code) {\n    if (!code || code.length !== 13) return false;\n\n    let sum = 0;\n    for (let i = 0; i < 12; i++) {\n      const digit = parseInt(code[i]);\n      sum += (i % 2 === 0) ? digit : digit * 3;\n    }\n    const checkDigit = (10 - (sum % 10)) % 10;\n    return checkDigit === parseInt(code[12]);\n  }\n\n  // Scanner Logic\n  let lastScanned = null;\n  let confidenceCounter = null;\n  const CONFIDENCE_THRESHOLD = 5;\n  let isPaused = false;\n\n  function startScanner() {\n    Quagga.init({\n      inputStream: {\n        name: \"Live\",\n        type: \"LiveStream\",\n        target: document.querySelector('#interactive'),\n        constraints: {\n          facingMode: \"environment\",\n          // Higher resolution\n          width: {min: 440, ideal: 1280, max: 1920},\n          height: {min: 480, ideal: 720, max: 1080},\n          aspectRatio: {min: 1, max: 2}\n        },\n      },\n      locator: {\n        patchSize: \"medium\",\n        halfSample: true,\n      },\n      numOfWorkers: 2,\n      frequency: 10,\n      decoder: {\n        // Only look for EAN\n        readers: [\"ean_reader\"]\n      },\n      locate: true\n    }, function (err) {\n      if (err) {\n        document.getElementById('scan-status').innerText = \"Error: \" + err;\n        return;\n      }\n      Quagga.start();\n    });\n\n    // Detection Event\n    Quagga.onDetected(function (result) {\n      if (isPaused) return;\n      const code = result.codeResult.code;\n      const status = document.getElementById('scan-status');\n\n      if (!validateEan13(code)) return;\n\n      // Don't scan the same thing twice in 3 seconds\n      if (lastScanned === code) {\n        status.innerText = `Scansionando...`;\n        confidenceCounter++;\n      } else {\n        lastScanned = code;\n        confidenceCounter = 1;\n      }\n\n      if (confidenceCounter >= CONFIDENCE_THRESHOLD) {\n        isPaused = true;\n\n        status.innerText = `Trovato: ${code}`;\n        status.classList.remove('text-yellow-600');\n        status.classList.add('text-green-600');\n\n        // Send to Backend\n        htmx.ajax('GET', `/fridge/items/form?barcode=${code}`, {\n          target: '#modals',\n          swap: 'innerHTML',\n        });\n\n        setTimeout(() => {\n          isPaused = false;\n          lastScanned = null;\n          confidenceCounter = 0;\n\n          status.innerText = \"Scansiona un codice a barre!\"\n        }, 3000)\n      }\n    });\n  }\n\n  document.addEventListener('DOMContentLoaded', startScanner);\n</script><style>\n  /* Quagga adds a canvas overlay for drawing boxes, ensure it fits */\n  #interactive canvas.drawingBuffer {\n    position: absolute;\n    top: 0;\n    left: 0;\n    width: 100%;\n    height: 100%;\n  }\n</style>")
+		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 1, "<section class=\"overflow-hidden bg-gray-50 sm:grid sm:grid-cols-2 sm:items-center dark:bg-gray-900\"><div class=\"flex flex-col items-center justify-center p-8\" id=\"home-scanner\" data-pair=\"")
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		var templ_7745c5c3_Var2 string
+		templ_7745c5c3_Var2, templ_7745c5c3_Err = templ.JoinStringErrs(pairing)
+		if templ_7745c5c3_Err != nil {
+			return templ.Error{Err: templ_7745c5c3_Err, FileName: `service/templates/home.templ`, Line: 7, Col: 98}
+		}
+		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var2))
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 2, "\">")
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		if pairing != "" {
+			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 3, "<div class=\"mb-4 w-full max-w-md p-3 rounded-lg bg-blue-50 text-sm text-blue-800 text-center dark:bg-blue-900/30 dark:text-blue-300\">Le scansioni si aprono sul computer (codice <span class=\"font-mono font-bold\">")
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			var templ_7745c5c3_Var3 string
+			templ_7745c5c3_Var3, templ_7745c5c3_Err = templ.JoinStringErrs(pairing)
+			if templ_7745c5c3_Err != nil {
+				return templ.Error{Err: templ_7745c5c3_Err, FileName: `service/templates/home.templ`, Line: 10, Col: 92}
+			}
+			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var3))
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 4, "</span>). <a href=\"/\" class=\"font-medium underline\">Scollega</a></div>")
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+		}
+		if pairing == "" {
+			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 5, "<div class=\"mb-4 inline-flex rounded-md shadow-sm\" role=\"group\" aria-label=\"Modalità di scansione\"><button type=\"button\" data-scan-mode=\"add\" class=\"scan-mode px-4 py-2 text-sm font-medium border border-gray-300 rounded-l-md dark:border-gray-600\">Aggiungi</button> <button type=\"button\" data-scan-mode=\"consume\" class=\"scan-mode px-4 py-2 text-sm font-medium border border-l-0 border-gray-300 rounded-r-md dark:border-gray-600\">Consuma</button></div>")
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+		}
+		templ_7745c5c3_Err = barcodeScanner().Render(ctx, templ_7745c5c3_Buffer)
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 6, "<div class=\"mt-4 w-full max-w-md flex flex-col items-center gap-2\"><div id=\"scan-status\" class=\"text-center text-lg font-bold text-gray-700 dark:text-gray-200\">Scansiona un codice a barre!<div class=\"text-xs font-normal text-gray-500\">oppure</div></div><div><button hx-get=\"/fridge/items/manual-form\" hx-target=\"#modals\" hx-swap=\"innerHTML\" class=\"flex items-center justify-center px-4 py-2 gap-1.5 bg-blue-600 text-white text-sm rounded-md shadow hover:bg-blue-700 transition-all focus:outline-none focus:ring-2 focus:ring-blue-300 dark:focus:ring-blue-800\" aria-label=\"Aggiungi Manualmente\"><svg class=\"w-4 h-4\" fill=\"none\" stroke=\"currentColor\" viewBox=\"0 0 24 24\" xmlns=\"http://www.w3.org/2000/svg\"><path stroke-linecap=\"round\" stroke-linejoin=\"round\" stroke-width=\"2\" d=\"M12 6v6m0 0v6m0-6h6m-6 0H6\"></path></svg> <span class=\"font-medium\">Inserisci Manualmente</span></button></div>")
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		if pairing == "" {
+			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 7, "<div class=\"flex gap-4\"><a href=\"/bulk\" class=\"text-sm text-gray-500 underline hover:text-orange-600 dark:text-gray-400\">Modalità spesa</a> <a href=\"/pair/join\" class=\"text-sm text-gray-500 underline hover:text-orange-600 dark:text-gray-400\">Scansiona per il computer</a></div>")
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+		}
+		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 8, "<div id=\"debug-log\" class=\"text-xs text-red-500 font-mono bg-gray-100 p-2 rounded hidden\"></div></div></div><div class=\"p-8 md:p-12 lg:px-16 lg:py-24\"><div class=\"mx-auto max-w-xl text-center\" id=\"splash-right\" hx-get=\"/fridge/home-items\" hx-trigger=\"load, sse:inventory-changed\" hx-swap=\"innerHTML\"></div></div></section><script>\n\t\t(function () {\n\t\t\t// The scanner adds the products or, in consume mode, takes one unit of them; the mode is kept across visits\n\t\t\tvar scanner = document.getElementById(\"home-scanner\");\n\t\t\tfunction setMode(mode) {\n\t\t\t\tscanner.dataset.mode = mode;\n\t\t\t\tlocalStorage.setItem(\"scanMode\", mode);\n\t\t\t\tdocument.querySelectorAll(\".scan-mode\").forEach(function (button) {\n\t\t\t\t\tvar active = button.dataset.scanMode === mode;\n\t\t\t\t\tbutton.classList.toggle(\"bg-blue-600\", active);\n\t\t\t\t\tbutton.classList.toggle(\"text-white\", active);\n\t\t\t\t\tbutton.classList.toggle(\"bg-white\", !active);\n\t\t\t\t\tbutton.classList.toggle(\"text-gray-700\", !active);\n\t\t\t\t});\n\t\t\t}\n\t\t\tdocument.querySelectorAll(\".scan-mode\").forEach(function (button) {\n\t\t\t\tbutton.addEventListener(\"click\", function () {\n\t\t\t\t\tsetMode(button.dataset.scanMode);\n\t\t\t\t});\n\t\t\t});\n\t\t\tsetMode(localStorage.getItem(\"scanMode\") === \"consume\" ? \"consume\" : \"add\");\n\t\t})();\n\n\t\t// Send the scans to the backend, or to the paired desktop\n\t\tdocument.getElementById(\"interactive\").addEventListener(\"barcode\", function (e) {\n\t\t\tvar scanner = document.getElementById(\"home-scanner\");\n\t\t\tvar pairing = scanner.dataset.pair;\n\t\t\tif (pairing) {\n\t\t\t\thtmx.ajax(\"POST\", \"/pair/scan\", {\n\t\t\t\t\tvalues: { code: pairing, barcode: e.detail.code },\n\t\t\t\t\ttarget: \"#scan-status\",\n\t\t\t\t\tswap: \"innerHTML\",\n\t\t\t\t});\n\t\t\t} else if (scanner.dataset.mode === \"consume\") {\n\t\t\t\thtmx.ajax(\"POST\", \"/fridge/consume\", {\n\t\t\t\t\tvalues: { barcode: e.detail.code },\n\t\t\t\t\ttarget: \"#scan-status\",\n\t\t\t\t\tswap: \"innerHTML\",\n\t\t\t\t});\n\t\t\t} else {\n\t\t\t\thtmx.ajax(\"GET\", \"/fridge/items/form?barcode=\" + encodeURIComponent(e.detail.code), {\n\t\t\t\t\ttarget: \"#modals\",\n\t\t\t\t\tswap: \"innerHTML\",\n\t\t\t\t});\n\t\t\t}\n\t\t});\n\t</script>")
 		if templ_7745c5c3_Err != nil {
 			return templ_7745c5c3_Err
 		}
@@ -0,0 +1,43 @@
+// Code generated by templ - DO NOT EDIT.
+
+// templ: version: v0.3.960
+package templates
+
+//lint:file-ignore SA4006 This context is only used if a nested component is present.
+
+import "github.com/a-h/templ"
+import templruntime "github.com/a-h/templ/runtime"
+
+// barcodeScanner is the camera scanner of EAN-13 barcodes. Once a barcode is read with enough confidence it fires a
+// `barcode` event on #interactive, with the code in `detail.code`, and shows its progress in the #scan-status element
+// of the page.
+func barcodeScanner() templ.Component {
+	return templruntime.GeneratedTemplate(func(templ_7745c5c3_Input templruntime.GeneratedComponentInput) (templ_7745c5c3_Err error) {
+		templ_7745c5c3_W, ctx := templ_7745c5c3_Input.Writer, templ_7745c5c3_Input.Context
+		if templ_7745c5c3_CtxErr := ctx.Err(); templ_7745c5c3_CtxErr != nil {
+			return templ_7745c5c3_CtxErr
+		}
+		templ_7745c5c3_Buffer, templ_7745c5c3_IsBuffer := templruntime.GetBuffer(templ_7745c5c3_W)
+		if !templ_7745c5c3_IsBuffer {
+			defer func() {
+				templ_7745c5c3_BufErr := templruntime.ReleaseBuffer(templ_7745c5c3_Buffer)
+				if templ_7745c5c3_Err == nil {
+					templ_7745c5c3_Err = templ_7745c5c3_BufErr
+				}
+			}()
+		}
+		ctx = templ.InitializeContext(ctx)
+		templ_7745c5c3_Var1 := templ.GetChildren(ctx)
+		if templ_7745c5c3_Var1 == nil {
+			templ_7745c5c3_Var1 = templ.NopComponent
+		}
+		ctx = templ.ClearChildren(ctx)
+		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 1, "<script src=\"https://cdn.jsdelivr.net/npm/@ericblade/quagga2/dist/quagga.min.js\"></script><div id=\"interactive\" class=\"viewport relative w-full max-w-[300px] h-64 bg-black rounded-2xl overflow-hidden border-4 border-gray-800 shadow-xl\"><video class=\"w-full h-full object-cover\"></video><div class=\"absolute top-1/2 left-0 w-full h-1 bg-red-500 opacity-50 pointer-events-none\"></div></div><script>\n  function validateEan13(code) {\n    if (!code || code.length !== 13) return false;\n\n    let sum = 0;\n    for (let i = 0; i < 12; i++) {\n      const digit = parseInt(code[i]);\n      sum += (i % 2 === 0) ? digit : digit * 3;\n    }\n    const checkDigit = (10 - (sum % 10)) % 10;\n    return checkDigit === parseInt(code[12]);\n  }\n\n  // Scanner Logic\n  let lastScanned = null;\n  let confidenceCounter = null;\n  const CONFIDENCE_THRESHOLD = 5;\n  let isPaused = false;\n\n  function startScanner() {\n    Quagga.init({\n      inputStream: {\n        name: \"Live\",\n        type: \"LiveStream\",\n        target: document.querySelector('#interactive'),\n        constraints: {\n          facingMode: \"environment\",\n          // Higher resolution\n          width: {min: 440, ideal: 1280, max: 1920},\n          height: {min: 480, ideal: 720, max: 1080},\n          aspectRatio: {min: 1, max: 2}\n        },\n      },\n      locator: {\n        patchSize: \"medium\",\n        halfSample: true,\n      },\n      numOfWorkers: 2,\n      frequency: 10,\n      decoder: {\n        // Only look for EAN\n        readers: [\"ean_reader\"]\n      },\n      locate: true\n    }, function (err) {\n      if (err) {\n        document.getElementById('scan-status').innerText = \"Error: \" + err;\n        return;\n      }\n      Quagga.start();\n    });\n\n    // Detection Event\n    Quagga.onDetected(function (result) {\n      if (isPaused) return;\n      const code = result.codeResult.code;\n      const status = document.getElementById('scan-status');\n\n      if (!validateEan13(code)) return;\n\n      // Don't scan the same thing twice in 3 seconds\n      if (lastScanned === code) {\n        status.innerText = `Scansionando...`;\n        confidenceCounter++;\n      } else {\n        lastScanned = code;\n        confidenceCounter = 1;\n      }\n\n      if (confidenceCounter >= CONFIDENCE_THRESHOLD) {\n        isPaused = true;\n\n        status.innerText = `Trovato: ${code}`;\n        status.classList.remove('text-yellow-600');\n        status.classList.add('text-green-600');\n\n        document.getElementById('interactive').dispatchEvent(new CustomEvent('barcode', {detail: {code: code}}));\n\n        setTimeout(() => {\n          isPaused = false;\n          lastScanned = null;\n          confidenceCounter = 0;\n\n          status.innerText = \"Scansiona un codice a barre!\"\n        }, 3000)\n      }\n    });\n  }\n\n  document.addEventListener('DOMContentLoaded', startScanner);\n</script><style>\n  /* Quagga adds a canvas overlay for drawing boxes, ensure it fits */\n  #interactive canvas.drawingBuffer {\n    position: absolute;\n    top: 0;\n    left: 0;\n    width: 100%;\n    height: 100%;\n  }\n</style>")
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		return nil
+	})
+}
+
+var _ = templruntime.GeneratedTemplate
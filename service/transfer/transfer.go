@@ -0,0 +1,371 @@
+/*
+Package transfer reads and writes the lots of the inventory as CSV or JSON files, to migrate from spreadsheets and to
+edit large batches offline.
+
+Both formats have the same fields: id, barcode, name, brand, quantity, unit, amount, package_amount, expiration_date,
+added_at, location, note and label. CSV files have a header row naming the columns, in any order. Only barcode, name
+and expiration_date are required when importing: new lots have no id, a quantity of 1, today as addition date and the
+default location. Dates are written as YYYY-MM-DD (the addition date with the time too) and also read as DD/MM/YYYY.
+
+Unit, amount and package_amount are only written for measured lots. When importing, package_amount is required with a
+unit, and a missing amount means full packages; amounts are also read with a comma as decimal separator.
+*/
+package transfer
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/lorenzougolini/wimf-app/service/models"
+)
+
+// Supported file formats.
+const (
+	FormatCSV  = "csv"
+	FormatJSON = "json"
+)
+
+// Formats are the supported file formats.
+var Formats = []string{FormatCSV, FormatJSON}
+
+// Record is a lot as written in the files.
+type Record struct {
+	Id             string  `json:"id,omitempty"`
+	Barcode        string  `json:"barcode"`
+	Name           string  `json:"name"`
+	Brand          string  `json:"brand"`
+	Quantity       int     `json:"quantity"`
+	Unit           string  `json:"unit,omitempty"`
+	Amount         float64 `json:"amount,omitempty"`
+	PackageAmount  float64 `json:"package_amount,omitempty"`
+	ExpirationDate string  `json:"expiration_date"`
+	AdditionDate   string  `json:"added_at"`
+	Location       string  `json:"location"`
+	Note           string  `json:"note,omitempty"`
+	Label          string  `json:"label,omitempty"`
+}
+
+// columns are the CSV columns, in the order they're exported.
+var columns = []string{
+	"id", "barcode", "name", "brand", "quantity", "unit", "amount", "package_amount", "expiration_date", "added_at",
+	"location", "note", "label",
+}
+
+// RowError is a problem with a record of an imported file. Row counts the records from 1, excluding the CSV header.
+type RowError struct {
+	Row     int    `json:"row"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e RowError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("row %d: %s", e.Row, e.Message)
+	}
+	return fmt.Sprintf("row %d, %s: %s", e.Row, e.Field, e.Message)
+}
+
+// Export writes `lots` to `w` in `format`. Lots without an id (not stored yet) are written without it.
+func Export(w io.Writer, format string, lots []models.Item) error {
+	records := make([]Record, 0, len(lots))
+	for _, lot := range lots {
+		var id string
+		if lot.Id != uuid.Nil {
+			id = lot.Id.String()
+		}
+		records = append(records, Record{
+			Id:             id,
+			Barcode:        lot.Barcode,
+			Name:           lot.Name,
+			Brand:          lot.Brand,
+			Quantity:       lot.Quantity,
+			Unit:           lot.Unit,
+			Amount:         lot.Amount,
+			PackageAmount:  lot.PackageAmount,
+			ExpirationDate: lot.ExpirationDate.Format("2006-01-02"),
+			AdditionDate:   lot.AdditionDate.Format(models.DbTimeLayout),
+			Location:       lot.Location,
+			Note:           lot.Note,
+			Label:          lot.Label,
+		})
+	}
+
+	switch format {
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		_ = cw.Write(columns)
+		for _, r := range records {
+			_ = cw.Write([]string{
+				r.Id, r.Barcode, r.Name, r.Brand, strconv.Itoa(r.Quantity), r.Unit, formatAmount(r.Amount),
+				formatAmount(r.PackageAmount), r.ExpirationDate, r.AdditionDate, r.Location, r.Note, r.Label,
+			})
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return fmt.Errorf("unsupported format: %s", format)
+	}
+}
+
+// Import reads the lots of a file in `format`. Invalid records are reported as RowErrors and left out; the error is
+// only returned when the file itself can't be read.
+func Import(r io.Reader, format string) ([]models.Item, []RowError, error) {
+	var records []Record
+	var err error
+	switch format {
+	case FormatJSON:
+		records, err = readJSON(r)
+	case FormatCSV:
+		records, err = readCSV(r)
+	default:
+		err = fmt.Errorf("unsupported format: %s", format)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var lots []models.Item
+	var rowErrors []RowError
+	ids := make(map[uuid.UUID]int)
+	for i, rec := range records {
+		lot, errs := validate(i+1, rec)
+		if lot.Id != uuid.Nil {
+			if first, dup := ids[lot.Id]; dup {
+				errs = append(errs, RowError{Row: i + 1, Field: "id", Message: fmt.Sprintf("same id of row %d", first)})
+			}
+			ids[lot.Id] = i + 1
+		}
+		if len(errs) > 0 {
+			rowErrors = append(rowErrors, errs...)
+			continue
+		}
+		lots = append(lots, lot)
+	}
+	return lots, rowErrors, nil
+}
+
+// readJSON reads the records of a JSON array.
+func readJSON(r io.Reader) ([]Record, error) {
+	// Quantity is a pointer to tell a missing quantity (defaults to 1) from a zero one (invalid)
+	var objects []struct {
+		Record
+		Quantity *int `json:"quantity"`
+	}
+	if err := json.NewDecoder(r).Decode(&objects); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	records := make([]Record, 0, len(objects))
+	for _, o := range objects {
+		rec := o.Record
+		rec.Quantity = 1
+		if o.Quantity != nil {
+			rec.Quantity = *o.Quantity
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+// readCSV reads the records of a CSV file, mapping the columns by the header row.
+func readCSV(r io.Reader) ([]Record, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	cr := csv.NewReader(bytes.NewReader(data))
+	cr.TrimLeadingSpace = true
+	cr.FieldsPerRecord = -1
+	// spreadsheets with a comma as decimal separator (e.g. in Italian) save CSV files separated by semicolons
+	firstLine, _, _ := bytes.Cut(data, []byte("\n"))
+	if bytes.Count(firstLine, []byte(";")) > bytes.Count(firstLine, []byte(",")) {
+		cr.Comma = ';'
+	}
+	header, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, errors.New("the file is empty")
+	} else if err != nil {
+		return nil, fmt.Errorf("invalid CSV: %w", err)
+	}
+
+	index := make(map[string]int)
+	for i, h := range header {
+		h = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+		if !slices.Contains(columns, h) {
+			return nil, fmt.Errorf("unknown column %q", h)
+		}
+		index[h] = i
+	}
+	for _, required := range []string{"barcode", "name", "expiration_date"} {
+		if _, ok := index[required]; !ok {
+			return nil, fmt.Errorf("missing column %q", required)
+		}
+	}
+
+	var records []Record
+	for {
+		row, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+		get := func(column string) string {
+			if i, ok := index[column]; ok && i < len(row) {
+				return strings.TrimSpace(row[i])
+			}
+			return ""
+		}
+
+		rec := Record{
+			Id:             get("id"),
+			Barcode:        get("barcode"),
+			Name:           get("name"),
+			Brand:          get("brand"),
+			Unit:           get("unit"),
+			ExpirationDate: get("expiration_date"),
+			AdditionDate:   get("added_at"),
+			Location:       get("location"),
+			Note:           get("note"),
+			Label:          get("label"),
+		}
+		// a missing quantity defaults to 1, an invalid one is reported by validate
+		rec.Quantity = 1
+		if q := get("quantity"); q != "" {
+			if rec.Quantity, err = strconv.Atoi(q); err != nil {
+				rec.Quantity = -1
+			}
+		}
+		// like quantities, invalid amounts are reported by validate
+		for column, amount := range map[string]*float64{"amount": &rec.Amount, "package_amount": &rec.PackageAmount} {
+			if a := get(column); a != "" {
+				if *amount, err = models.ParseAmount(a); err != nil {
+					*amount = -1
+				}
+			}
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+// formatAmount writes an amount of a CSV file, empty when zero.
+func formatAmount(amount float64) string {
+	if amount == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(amount, 'f', -1, 64)
+}
+
+// validate checks a record and converts it to a lot.
+func validate(row int, rec Record) (models.Item, []RowError) {
+	var errs []RowError
+	fail := func(field, message string) {
+		errs = append(errs, RowError{Row: row, Field: field, Message: message})
+	}
+
+	lot := models.Item{
+		Barcode:  strings.TrimSpace(rec.Barcode),
+		Name:     strings.TrimSpace(rec.Name),
+		Brand:    strings.TrimSpace(rec.Brand),
+		Quantity: rec.Quantity,
+		Location: strings.TrimSpace(rec.Location),
+		Note:     strings.TrimSpace(rec.Note),
+		Label:    strings.TrimSpace(rec.Label),
+	}
+	if rec.Id != "" {
+		id, err := uuid.FromString(rec.Id)
+		if err != nil {
+			fail("id", "not a valid id")
+		}
+		lot.Id = id
+	}
+	if len(lot.Barcode) < 3 {
+		fail("barcode", "must be at least 3 characters")
+	}
+	if lot.Name == "" {
+		fail("name", "is required")
+	}
+	if lot.Quantity < 1 {
+		fail("quantity", "must be a positive number")
+	}
+	if err := validateMeasure(&lot, rec); err != nil {
+		fail(err.field, err.message)
+	}
+
+	var err error
+	if lot.ExpirationDate, err = parseDate(rec.ExpirationDate); err != nil {
+		fail("expiration_date", err.Error())
+	}
+	if rec.AdditionDate == "" {
+		lot.AdditionDate = time.Now()
+	} else if lot.AdditionDate, err = parseDate(rec.AdditionDate); err != nil {
+		fail("added_at", err.Error())
+	}
+
+	if lot.Location == "" {
+		lot.Location = models.DefaultLocation
+	} else if !slices.Contains(models.Locations, lot.Location) {
+		fail("location", fmt.Sprintf("must be one of %s", strings.Join(models.Locations, ", ")))
+	}
+	if lot.Label != "" && !slices.Contains(models.Labels, lot.Label) {
+		fail("label", fmt.Sprintf("must be empty or one of %s", strings.Join(models.Labels, ", ")))
+	}
+	return lot, errs
+}
+
+// measureError is a problem with the unit or the amounts of a record.
+type measureError struct {
+	field, message string
+}
+
+// validateMeasure sets the unit and the amounts of `rec` on `lot`. The amounts of the lots without a unit are ignored.
+func validateMeasure(lot *models.Item, rec Record) *measureError {
+	unit := strings.TrimSpace(rec.Unit)
+	if !models.ValidUnit(unit) {
+		return &measureError{"unit", fmt.Sprintf("must be empty or one of %s", strings.Join(models.Units, ", "))}
+	}
+	if unit == "" {
+		return nil
+	}
+	lot.Unit = unit
+	lot.PackageAmount = models.RoundAmount(rec.PackageAmount)
+	lot.Amount = models.RoundAmount(rec.Amount)
+	if lot.PackageAmount <= 0 {
+		return &measureError{"package_amount", "must be a positive number with a unit"}
+	}
+	// the amount fills every package but the last, which may be opened; zero means all full
+	if lot.Amount < 0 || (lot.Amount > 0 && lot.Quantity > 0 &&
+		models.PackagesOf(lot.Amount, lot.PackageAmount) != lot.Quantity) {
+		return &measureError{"amount", "doesn't match the quantity and the package_amount"}
+	}
+	return nil
+}
+
+// dateLayouts are the accepted date formats, the first one is the exported one.
+var dateLayouts = []string{"2006-01-02", models.DbTimeLayout, "02/01/2006"}
+
+func parseDate(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, errors.New("is required")
+	}
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q, use YYYY-MM-DD", s)
+}
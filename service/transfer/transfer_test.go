@@ -0,0 +1,65 @@
+package transfer
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/lorenzougolini/wimf-app/service/models"
+)
+
+func TestMeasuredLotRoundTrip(t *testing.T) {
+	lot := models.Item{
+		Id:             uuid.Must(uuid.NewV7()),
+		Barcode:        "8001234",
+		Name:           "Farina",
+		Quantity:       2,
+		Unit:           models.UnitGram,
+		Amount:         1500.5,
+		PackageAmount:  1000,
+		ExpirationDate: time.Date(2026, 11, 30, 0, 0, 0, 0, time.Local),
+		AdditionDate:   time.Date(2026, 10, 19, 9, 30, 0, 0, time.Local),
+		Location:       models.DefaultLocation,
+	}
+	for _, format := range []string{FormatCSV, FormatJSON} {
+		var buf bytes.Buffer
+		if err := Export(&buf, format, []models.Item{lot}); err != nil {
+			t.Fatal(err)
+		}
+		lots, rowErrors, err := Import(&buf, format)
+		if err != nil || len(rowErrors) > 0 {
+			t.Fatalf("%s: import = %v, %v", format, rowErrors, err)
+		}
+		got := lots[0]
+		if got.Unit != lot.Unit || got.Amount != lot.Amount || got.PackageAmount != lot.PackageAmount {
+			t.Errorf("%s: imported %v %s of %v; want %v %s of %v", format, got.Amount, got.Unit, got.PackageAmount,
+				lot.Amount, lot.Unit, lot.PackageAmount)
+		}
+	}
+}
+
+func TestInvalidMeasure(t *testing.T) {
+	csv := "barcode;name;quantity;unit;amount;package_amount;expiration_date\n" +
+		"8001234;Farina;2;oz;;1000;2026-11-30\n" +
+		"8001234;Farina;2;g;;;2026-11-30\n" +
+		"8001234;Farina;2;g;500;1000;2026-11-30\n" +
+		"8001234;Farina;2;g;NaN;1000;2026-11-30\n" +
+		"8001234;Farina;2;g;;Inf;2026-11-30\n" +
+		"8001234;Farina;2;g;1500,5;1000;2026-11-30\n"
+	lots, rowErrors, err := Import(strings.NewReader(csv), FormatCSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields []string
+	for _, e := range rowErrors {
+		fields = append(fields, e.Field)
+	}
+	if strings.Join(fields, ",") != "unit,package_amount,amount,amount,package_amount" {
+		t.Errorf("errors on %v; want unit, package_amount, amount, amount and package_amount", fields)
+	}
+	if len(lots) != 1 || lots[0].Amount != 1500.5 {
+		t.Errorf("imported %v; want one lot of 1500.5 g", lots)
+	}
+}